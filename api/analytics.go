@@ -0,0 +1,265 @@
+package api
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+	"github.com/shopspring/decimal"
+	"go.uber.org/zap"
+
+	"github.com/Kale-Grabovski/impay/domain"
+	"github.com/Kale-Grabovski/impay/sketch"
+)
+
+const (
+	topDefaultLimit = 10
+	topMaxLimit     = 100
+)
+
+type topWalletResp struct {
+	ID         string          `json:"id"`
+	Amount     decimal.Decimal `json:"amount"`
+	Operations int64           `json:"operations"`
+}
+
+type topWalletsResp struct {
+	By       string          `json:"by,omitempty"`
+	Currency string          `json:"currency,omitempty"`
+	Wallets  []topWalletResp `json:"wallets"`
+	Err      string          `json:"err_code,omitempty"`
+	Success  bool            `json:"success"`
+}
+
+type histogramBucketResp struct {
+	// UpTo is empty for the last bucket of larger amounts
+	UpTo  *decimal.Decimal `json:"up_to,omitempty"`
+	Count int64            `json:"count"`
+}
+
+type amountDistributionResp struct {
+	Count     int64                 `json:"count"`
+	Sum       decimal.Decimal       `json:"sum"`
+	Average   decimal.Decimal       `json:"average"`
+	Min       decimal.Decimal       `json:"min"`
+	Max       decimal.Decimal       `json:"max"`
+	P50       decimal.Decimal       `json:"p50"`
+	P95       decimal.Decimal       `json:"p95"`
+	P99       decimal.Decimal       `json:"p99"`
+	Histogram []histogramBucketResp `json:"histogram"`
+}
+
+type distributionResp struct {
+	Currency   string                            `json:"currency,omitempty"`
+	Operations map[string]amountDistributionResp `json:"operations"`
+	Err        string                            `json:"err_code,omitempty"`
+	Success    bool                              `json:"success"`
+}
+
+// GetTop returns the wallets of the currency query param with the largest lifetime amounts
+// of the operation in the by query param, transferred ranks by the sent amounts. Only wallets
+// kept in the per wallet projection are ranked.
+func (s *StatsAction) GetTop(c echo.Context) error {
+	by := c.QueryParam("by")
+	amount := map[string]func(o *domain.WalletOperations) (decimal.Decimal, int64){
+		domain.OperationDeposited: func(o *domain.WalletOperations) (decimal.Decimal, int64) {
+			return o.Deposited, o.Deposits
+		},
+		domain.OperationWithdrawn: func(o *domain.WalletOperations) (decimal.Decimal, int64) {
+			return o.Withdrawn, o.Withdrawals
+		},
+		domain.OperationTransferred: func(o *domain.WalletOperations) (decimal.Decimal, int64) {
+			return o.TransferredOut, o.TransfersOut
+		},
+	}[by]
+	if amount == nil {
+		return c.JSON(http.StatusBadRequest, topWalletsResp{
+			Err: "wrong by",
+		})
+	}
+
+	limit := topDefaultLimit
+	if v := c.QueryParam("limit"); v != "" {
+		var err error
+		limit, err = strconv.Atoi(v)
+		if err != nil || limit <= 0 || limit > topMaxLimit {
+			return c.JSON(http.StatusBadRequest, topWalletsResp{
+				Err: "wrong limit",
+			})
+		}
+	}
+	currency := c.QueryParam("currency")
+	if _, ok := domain.CurrencyScale(currency); !ok {
+		return c.JSON(http.StatusBadRequest, topWalletsResp{
+			Err: "wrong currency",
+		})
+	}
+
+	s.RLock()
+	wallets := make([]topWalletResp, 0, len(s.wallets.wallets))
+	for _, a := range s.wallets.all() {
+		// Wallets created before currencies are in the default one
+		if a.Currency != currency && (a.Currency != "" || currency != domain.DefaultCurrency) {
+			continue
+		}
+		sum, n := amount(&a.Lifetime)
+		if n == 0 {
+			continue
+		}
+		wallets = append(wallets, topWalletResp{
+			ID:         a.WalletID,
+			Amount:     sum,
+			Operations: n,
+		})
+	}
+	s.RUnlock()
+
+	sort.Slice(wallets, func(i, j int) bool {
+		if c := wallets[i].Amount.Cmp(wallets[j].Amount); c != 0 {
+			return c > 0
+		}
+		return wallets[i].ID < wallets[j].ID
+	})
+	if len(wallets) > limit {
+		wallets = wallets[:limit]
+	}
+	return c.JSON(http.StatusOK, topWalletsResp{
+		By:       by,
+		Currency: currency,
+		Wallets:  wallets,
+		Success:  true,
+	})
+}
+
+// GetDistribution returns the amount distribution of every operation type in the
+// currency query param. Quantiles are estimated within the sketch accuracy.
+func (s *StatsAction) GetDistribution(c echo.Context) error {
+	currency := c.QueryParam("currency")
+	scale, ok := domain.CurrencyScale(currency)
+	if !ok {
+		return c.JSON(http.StatusBadRequest, distributionResp{
+			Err: "wrong currency",
+		})
+	}
+
+	s.RLock()
+	distributions := make(map[string]*domain.AmountDistribution, len(domain.Operations))
+	for operation, d := range s.distributions[currency] {
+		distributions[operation] = copyDistribution(d)
+	}
+	bounds := s.histogramBounds
+	s.RUnlock()
+
+	resp := distributionResp{
+		Currency:   currency,
+		Operations: make(map[string]amountDistributionResp, len(distributions)),
+		Success:    true,
+	}
+	for operation, d := range distributions {
+		quantile := func(q float64) decimal.Decimal {
+			return decimal.NewFromFloat(d.Sketch.Quantile(q)).Round(scale)
+		}
+		histogram := make([]histogramBucketResp, len(d.Histogram))
+		for i, n := range d.Histogram {
+			histogram[i].Count = n
+			if i < len(bounds) {
+				histogram[i].UpTo = &bounds[i]
+			}
+		}
+		resp.Operations[operation] = amountDistributionResp{
+			Count:     d.Count,
+			Sum:       d.Sum,
+			Average:   d.Sum.Div(decimal.NewFromInt(d.Count)).Round(scale),
+			Min:       d.Min,
+			Max:       d.Max,
+			P50:       quantile(0.5),
+			P95:       quantile(0.95),
+			P99:       quantile(0.99),
+			Histogram: histogram,
+		}
+	}
+	return c.JSON(http.StatusOK, resp)
+}
+
+// recordAmount adds the amount to the distribution of the operation in the currency.
+// Must be called under lock.
+func (s *StatsAction) recordAmount(currency, operation string, amount decimal.Decimal) {
+	if currency == "" {
+		currency = domain.DefaultCurrency
+	}
+	ds, ok := s.distributions[currency]
+	if !ok {
+		ds = make(map[string]*domain.AmountDistribution, len(domain.Operations))
+		s.distributions[currency] = ds
+	}
+	d, ok := ds[operation]
+	if !ok {
+		d = &domain.AmountDistribution{
+			Histogram: make([]int64, len(s.histogramBounds)+1),
+			Sketch:    sketch.New(sketch.DefaultAccuracy, sketch.DefaultMaxBins),
+		}
+		ds[operation] = d
+	}
+
+	if d.Count == 0 || amount.LessThan(d.Min) {
+		d.Min = amount
+	}
+	if d.Count == 0 || amount.GreaterThan(d.Max) {
+		d.Max = amount
+	}
+	d.Count++
+	d.Sum = d.Sum.Add(amount)
+	d.Histogram[sort.Search(len(s.histogramBounds), func(i int) bool {
+		return amount.LessThanOrEqual(s.histogramBounds[i])
+	})]++
+	v, _ := amount.Float64()
+	d.Sketch.Add(v)
+}
+
+// setDistributions replaces the distributions, histograms counted with other
+// bounds than configured are reset. Must be called under lock.
+func (s *StatsAction) setDistributions(stats domain.WalletStats) {
+	sameBounds := len(stats.HistogramBounds) == len(s.histogramBounds)
+	for i := 0; sameBounds && i < len(s.histogramBounds); i++ {
+		sameBounds = stats.HistogramBounds[i].Equal(s.histogramBounds[i])
+	}
+	if !sameBounds && len(stats.Distributions) > 0 {
+		s.logger.Warn("histogram bounds changed, amount histograms are reset",
+			zap.Any("bounds", stats.HistogramBounds))
+	}
+
+	s.distributions = make(map[string]map[string]*domain.AmountDistribution, len(stats.Distributions))
+	for currency, ds := range stats.Distributions {
+		s.distributions[currency] = make(map[string]*domain.AmountDistribution, len(ds))
+		for operation, d := range ds {
+			c := copyDistribution(d)
+			if !sameBounds {
+				c.Histogram = make([]int64, len(s.histogramBounds)+1)
+			}
+			s.distributions[currency][operation] = c
+		}
+	}
+}
+
+func copyDistributions(distributions map[string]map[string]*domain.AmountDistribution) map[string]map[string]*domain.AmountDistribution {
+	c := make(map[string]map[string]*domain.AmountDistribution, len(distributions))
+	for currency, ds := range distributions {
+		c[currency] = make(map[string]*domain.AmountDistribution, len(ds))
+		for operation, d := range ds {
+			c[currency][operation] = copyDistribution(d)
+		}
+	}
+	return c
+}
+
+func copyDistribution(d *domain.AmountDistribution) *domain.AmountDistribution {
+	c := *d
+	c.Histogram = append([]int64(nil), d.Histogram...)
+	if d.Sketch != nil {
+		c.Sketch = d.Sketch.Copy()
+	} else {
+		c.Sketch = sketch.New(sketch.DefaultAccuracy, sketch.DefaultMaxBins)
+	}
+	return &c
+}
@@ -0,0 +1,158 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	mockery "github.com/stretchr/testify/mock"
+
+	"github.com/Kale-Grabovski/impay/api/mock"
+	"github.com/Kale-Grabovski/impay/domain"
+)
+
+func TestStatsTop(t *testing.T) {
+	e := echo.New()
+	statsAction := NewStatsAction(&domain.Config{}, &mock.ConsumerMock{}, &mock.LoggerMock{})
+
+	handlers := statsAction.handlers()
+	for _, ev := range []struct {
+		topic string
+		event domain.WalletEvent
+	}{
+		{domain.TopicWalletDeposited, domain.WalletEvent{WalletID: "a", Currency: "USD", Amount: decimal.NewFromInt(10)}},
+		{domain.TopicWalletDeposited, domain.WalletEvent{WalletID: "a", Currency: "USD", Amount: decimal.NewFromInt(15)}},
+		{domain.TopicWalletDeposited, domain.WalletEvent{WalletID: "b", Currency: "USD", Amount: decimal.NewFromInt(30)}},
+		{domain.TopicWalletDeposited, domain.WalletEvent{WalletID: "c", Currency: "EUR", Amount: decimal.NewFromInt(20)}},
+		{domain.TopicWalletTransferred, domain.WalletEvent{WalletID: "c", CounterpartyID: "a", Currency: "EUR", Amount: decimal.NewFromInt(5)}},
+	} {
+		e := ev.event
+		handlers[ev.topic](&e)
+	}
+
+	get := func(query string) (int, topWalletsResp) {
+		req := httptest.NewRequest(http.MethodGet, "/stats/wallets/top?"+query, nil)
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+
+		var resp topWalletsResp
+		assert.NoError(t, statsAction.GetTop(c))
+		assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+		return rec.Code, resp
+	}
+
+	code, resp := get("by=deposited&currency=USD&limit=2")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "USD", resp.Currency)
+	if assert.Len(t, resp.Wallets, 2) {
+		assert.Equal(t, "b", resp.Wallets[0].ID)
+		assert.Equal(t, "a", resp.Wallets[1].ID)
+		assert.True(t, resp.Wallets[1].Amount.Equal(decimal.NewFromInt(25)))
+		assert.Equal(t, int64(2), resp.Wallets[1].Operations)
+	}
+
+	code, resp = get("by=deposited&currency=EUR")
+	assert.Equal(t, http.StatusOK, code)
+	if assert.Len(t, resp.Wallets, 1) {
+		assert.Equal(t, "c", resp.Wallets[0].ID)
+	}
+
+	// Ranked by the sent amounts, wallets without transfers are left out
+	code, resp = get("by=transferred&currency=EUR")
+	assert.Equal(t, http.StatusOK, code)
+	if assert.Len(t, resp.Wallets, 1) {
+		assert.Equal(t, "c", resp.Wallets[0].ID)
+	}
+
+	// Amounts of different currencies are not ranked together
+	for query, err := range map[string]string{
+		"":                                     "wrong by",
+		"by=balance&currency=USD":              "wrong by",
+		"by=deposited&currency=USD&limit=0":    "wrong limit",
+		"by=deposited&currency=USD&limit=x":    "wrong limit",
+		"by=withdrawn&currency=USD&limit=1000": "wrong limit",
+		"by=deposited":                         "wrong currency",
+		"by=deposited&currency=XXX":            "wrong currency",
+	} {
+		code, resp = get(query)
+		assert.Equal(t, http.StatusBadRequest, code, query)
+		assert.Equal(t, err, resp.Err, query)
+	}
+}
+
+func TestStatsDistribution(t *testing.T) {
+	e := echo.New()
+	cfg := &domain.Config{}
+	cfg.Stats.HistogramBounds = []float64{100, 10}
+	statsAction := NewStatsAction(cfg, &mock.ConsumerMock{}, &mock.LoggerMock{})
+
+	handlers := statsAction.handlers()
+	for v := 1; v <= 100; v++ {
+		handlers[domain.TopicWalletDeposited](&domain.WalletEvent{WalletID: "a", Currency: "USD", Amount: decimal.NewFromInt(int64(v))})
+	}
+	handlers[domain.TopicWalletDeposited](&domain.WalletEvent{WalletID: "b", Currency: "JPY", Amount: decimal.NewFromInt(1000)})
+	handlers[domain.TopicWalletWithdrawn](&domain.WalletEvent{WalletID: "a", Currency: "USD", Amount: decimal.RequireFromString("2.5")})
+
+	get := func(query string) (int, distributionResp) {
+		req := httptest.NewRequest(http.MethodGet, "/stats/wallets/distribution?"+query, nil)
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+
+		var resp distributionResp
+		assert.NoError(t, statsAction.GetDistribution(c))
+		assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+		return rec.Code, resp
+	}
+
+	code, resp := get("currency=USD")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Len(t, resp.Operations, 2)
+	d := resp.Operations[domain.OperationDeposited]
+	assert.Equal(t, int64(100), d.Count)
+	assert.True(t, d.Sum.Equal(decimal.NewFromInt(5050)))
+	assert.True(t, d.Average.Equal(decimal.RequireFromString("50.5")))
+	assert.True(t, d.Min.Equal(decimal.NewFromInt(1)))
+	assert.True(t, d.Max.Equal(decimal.NewFromInt(100)))
+	assert.InDelta(t, 50, d.P50.InexactFloat64(), 1)
+	assert.InDelta(t, 95, d.P95.InexactFloat64(), 1.5)
+	assert.InDelta(t, 99, d.P99.InexactFloat64(), 1.5)
+	if assert.Len(t, d.Histogram, 3) {
+		assert.True(t, d.Histogram[0].UpTo.Equal(decimal.NewFromInt(10)))
+		assert.Equal(t, int64(10), d.Histogram[0].Count)
+		assert.Equal(t, int64(90), d.Histogram[1].Count)
+		assert.Nil(t, d.Histogram[2].UpTo)
+		assert.Equal(t, int64(0), d.Histogram[2].Count)
+	}
+	assert.Equal(t, int64(1), resp.Operations[domain.OperationWithdrawn].Count)
+
+	// Every currency is rounded to its own minor units
+	code, resp = get("currency=JPY")
+	assert.Equal(t, http.StatusOK, code)
+	d = resp.Operations[domain.OperationDeposited]
+	assert.Equal(t, int64(1), d.Count)
+	assert.True(t, d.Max.Equal(decimal.NewFromInt(1000)))
+	assert.Equal(t, int64(1), d.Histogram[2].Count)
+	assert.Equal(t, int32(0), d.P50.Exponent())
+
+	// Amounts of different currencies are not merged
+	for _, query := range []string{"", "currency=XXX"} {
+		code, resp = get(query)
+		assert.Equal(t, http.StatusBadRequest, code, query)
+		assert.Equal(t, "wrong currency", resp.Err, query)
+	}
+
+	// Distributions survive a checkpoint, histograms of other bounds are reset
+	stats := statsAction.stats()
+	loggerMock := &mock.LoggerMock{}
+	loggerMock.On("Warn", "histogram bounds changed, amount histograms are reset", mockery.Anything).Once().Return(nil)
+	restored := NewStatsAction(&domain.Config{}, &mock.ConsumerMock{}, loggerMock)
+	restored.setStats(stats)
+	restoredDeposits := restored.distributions["USD"][domain.OperationDeposited]
+	assert.Equal(t, int64(100), restoredDeposits.Sketch.Count)
+	assert.Equal(t, make([]int64, len(domain.DefaultHistogramBounds)+1), restoredDeposits.Histogram)
+	loggerMock.AssertExpectations(t)
+}
@@ -0,0 +1,56 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/Kale-Grabovski/impay/domain"
+)
+
+// Authenticator returns the principal making the request.
+type Authenticator interface {
+	Authenticate(r *http.Request) (*domain.Principal, error)
+}
+
+type authResp struct {
+	Err     string `json:"err_code,omitempty"`
+	Success bool   `json:"success"`
+}
+
+// AuthMiddleware rejects requests without valid credentials, handlers get
+// the principal from the request context.
+func AuthMiddleware(auth Authenticator) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			req := c.Request()
+			p, err := auth.Authenticate(req)
+			if err != nil {
+				c.Response().Header().Set(echo.HeaderWWWAuthenticate, `Bearer realm="impay"`)
+				return c.JSON(http.StatusUnauthorized, authResp{
+					Err: "unauthorized",
+				})
+			}
+			c.SetRequest(req.WithContext(domain.WithPrincipal(req.Context(), p)))
+			return next(c)
+		}
+	}
+}
+
+// principal returns the caller of the request, nil without authentication.
+func principal(c echo.Context) *domain.Principal {
+	return domain.PrincipalFrom(c.Request().Context())
+}
+
+// usableWallet returns the wallet if the caller may use it, wallets of others
+// are reported as not found not to reveal them.
+func usableWallet(c echo.Context, tx domain.WalletTx, id string) (*domain.Wallet, error) {
+	wallet, err := tx.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	if !principal(c).CanUse(wallet) {
+		return nil, domain.ErrWalletNotFound
+	}
+	return wallet, nil
+}
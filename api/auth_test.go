@@ -0,0 +1,107 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	mockery "github.com/stretchr/testify/mock"
+
+	"github.com/Kale-Grabovski/impay/api/mock"
+	"github.com/Kale-Grabovski/impay/domain"
+	"github.com/Kale-Grabovski/impay/storage"
+)
+
+// keyAuthenticator authenticates the principals by their API keys as is.
+type keyAuthenticator map[string]*domain.Principal
+
+func (a keyAuthenticator) Authenticate(r *http.Request) (*domain.Principal, error) {
+	p, ok := a[r.Header.Get(domain.HeaderAPIKey)]
+	if !ok {
+		return nil, errors.New("invalid credentials")
+	}
+	return p, nil
+}
+
+func TestWalletOwnership(t *testing.T) {
+	repo := storage.NewMemoryRepository()
+	producerMock := &mock.ProducerMock{}
+	producerMock.On("Produce", mockery.Anything, mockery.Anything, mockery.Anything).Return(nil)
+	walletAction := NewWalletAction(&domain.Config{}, repo, &mock.RateProviderMock{}, producerMock, &mock.LoggerMock{})
+
+	e := echo.New()
+	wallets := e.Group("/wallets", AuthMiddleware(keyAuthenticator{
+		"alice": {Subject: "alice"},
+		"bob":   {Subject: "bob"},
+		"ops":   {Subject: "ops", Admin: true},
+	}))
+	wallets.GET("", walletAction.GetAll)
+	wallets.GET("/:id", walletAction.GetById)
+	wallets.POST("", walletAction.Create)
+	wallets.POST("/:id/deposit", walletAction.Deposit)
+	wallets.POST("/:id/transfer", walletAction.Transfer)
+	wallets.POST("/:id/holds", walletAction.CreateHold)
+	e.POST("/holds/:id/void", walletAction.VoidHold, AuthMiddleware(keyAuthenticator{"bob": {Subject: "bob"}}))
+
+	var idempotencyKey string
+	call := func(key, method, path, body string, resp any) int {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		if idempotencyKey != "" {
+			req.Header.Set(domain.HeaderIdempotencyKey, idempotencyKey)
+		}
+		if key != "" {
+			req.Header.Set(domain.HeaderAPIKey, key)
+		}
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		if resp != nil {
+			assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), resp))
+		}
+		return rec.Code
+	}
+
+	assert.Equal(t, http.StatusUnauthorized, call("", http.MethodGet, "/wallets", "", nil))
+	assert.Equal(t, http.StatusUnauthorized, call("eve", http.MethodGet, "/wallets", "", nil))
+
+	var alice, bob createWalletResp
+	assert.Equal(t, http.StatusOK, call("alice", http.MethodPost, "/wallets", `{"name": "a"}`, &alice))
+	assert.Equal(t, http.StatusOK, call("bob", http.MethodPost, "/wallets", `{"name": "b"}`, &bob))
+	// Idempotency keys of different callers do not clash
+	idempotencyKey = "deposit-1"
+	assert.Equal(t, http.StatusOK, call("alice", http.MethodPost, "/wallets/"+alice.ID+"/deposit", `{"amount": 10}`, nil))
+	assert.Equal(t, http.StatusOK, call("bob", http.MethodPost, "/wallets/"+bob.ID+"/deposit", `{"amount": 1}`, nil))
+	idempotencyKey = ""
+
+	// Wallets of others are not found and not listed
+	var list []domain.Wallet
+	assert.Equal(t, http.StatusOK, call("bob", http.MethodGet, "/wallets", "", &list))
+	if assert.Len(t, list, 1) {
+		assert.Equal(t, bob.ID, list[0].ID)
+		assert.Equal(t, "bob", list[0].Owner)
+	}
+	assert.Equal(t, http.StatusNotFound, call("bob", http.MethodGet, "/wallets/"+alice.ID, "", nil))
+	assert.Equal(t, http.StatusNotFound, call("bob", http.MethodPost, "/wallets/"+alice.ID+"/transfer",
+		`{"amount": 5, "transfer_to": "`+bob.ID+`"}`, nil))
+
+	// Money may be sent to a wallet of another owner
+	assert.Equal(t, http.StatusOK, call("alice", http.MethodPost, "/wallets/"+alice.ID+"/transfer",
+		`{"amount": 5, "transfer_to": "`+bob.ID+`"}`, nil))
+
+	var hold holdResp
+	assert.Equal(t, http.StatusOK, call("alice", http.MethodPost, "/wallets/"+alice.ID+"/holds", `{"amount": 1}`, &hold))
+	assert.NotEmpty(t, hold.ID)
+	assert.Equal(t, http.StatusNotFound, call("bob", http.MethodPost, "/holds/"+hold.ID+"/void", "", nil))
+
+	// Admins use every wallet
+	var wallet getWalletResp
+	assert.Equal(t, http.StatusOK, call("ops", http.MethodGet, "/wallets/"+bob.ID, "", &wallet))
+	assert.Equal(t, "6", wallet.Balance.String())
+	assert.Equal(t, http.StatusOK, call("ops", http.MethodGet, "/wallets", "", &list))
+	assert.Len(t, list, 2)
+}
@@ -0,0 +1,147 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/Kale-Grabovski/impay/domain"
+)
+
+const (
+	bucketsDefaultCount = 24
+	bucketsMaxCount     = 1440
+)
+
+type statsBucketsResp struct {
+	Granularity string               `json:"granularity"`
+	From        time.Time            `json:"from"`
+	To          time.Time            `json:"to"`
+	Buckets     []domain.StatsBucket `json:"buckets"`
+	Err         string               `json:"err_code,omitempty"`
+	Success     bool                 `json:"success"`
+}
+
+type bucketsQuery struct {
+	granularity string
+	size        time.Duration
+	from, to    time.Time
+}
+
+// getBuckets returns the stats of every bucket within [from, to), empty buckets
+// included. Supported query params: granularity (minute, hour or day, hour
+// by default), from and to (RFC3339, the last 24 buckets up to now by default).
+func (s *StatsAction) getBuckets(c echo.Context) error {
+	q, err := parseBucketsQuery(c, s.now())
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, statsBucketsResp{
+			Err: err.Error(),
+		})
+	}
+
+	s.RLock()
+	defer s.RUnlock()
+	resp := statsBucketsResp{
+		Granularity: q.granularity,
+		From:        q.from,
+		To:          q.to,
+		Buckets:     make([]domain.StatsBucket, 0, q.to.Sub(q.from)/q.size),
+		Success:     true,
+	}
+	for start := q.from; start.Before(q.to); start = start.Add(q.size) {
+		b, ok := s.buckets[q.granularity][start.Unix()]
+		if !ok {
+			b = &domain.StatsBucket{Start: start}
+		}
+		resp.Buckets = append(resp.Buckets, *b)
+	}
+	return c.JSON(http.StatusOK, resp)
+}
+
+// parseBucketsQuery aligns from and to with the bucket boundaries, a bucket is
+// returned if it starts before to.
+func parseBucketsQuery(c echo.Context, now time.Time) (q bucketsQuery, err error) {
+	q.granularity = domain.GranularityHour
+	if v := c.QueryParam("granularity"); v != "" {
+		q.granularity = v
+	}
+	size, ok := domain.Granularities[q.granularity]
+	if !ok {
+		return q, errors.New("wrong granularity")
+	}
+	q.size = size
+
+	q.to = now
+	if v := c.QueryParam("to"); v != "" {
+		q.to, err = time.Parse(time.RFC3339, v)
+		if err != nil {
+			return q, errors.New("wrong to")
+		}
+	}
+	q.to = q.to.UTC()
+	if end := q.to.Truncate(size); end.Before(q.to) {
+		q.to = end.Add(size)
+	}
+
+	q.from = q.to.Add(-bucketsDefaultCount * size)
+	if v := c.QueryParam("from"); v != "" {
+		q.from, err = time.Parse(time.RFC3339, v)
+		if err != nil {
+			return q, errors.New("wrong from")
+		}
+	}
+	q.from = q.from.UTC().Truncate(size)
+
+	if !q.from.Before(q.to) {
+		return q, errors.New("wrong range")
+	}
+	if q.to.Sub(q.from)/size > bucketsMaxCount {
+		return q, errors.New("too many buckets")
+	}
+	return q, nil
+}
+
+// eventTime returns when the event occurred, events published without
+// time are taken as occurred when consumed.
+func (s *StatsAction) eventTime(e *domain.WalletEvent) time.Time {
+	if e.OccurredAt.IsZero() {
+		return s.now().UTC()
+	}
+	return e.OccurredAt.UTC()
+}
+
+// record applies fn to the buckets of every granularity the event falls into.
+// Events published without time are counted at the time they are consumed,
+// events older than the retention are not bucketed. Must be called under lock.
+func (s *StatsAction) record(e *domain.WalletEvent, fn func(b *domain.StatsBucket)) {
+	now := s.now().UTC()
+	at := s.eventTime(e)
+
+	for granularity, size := range domain.Granularities {
+		cutoff := now.Add(-s.retention[granularity])
+		start := at.Truncate(size)
+		if !start.Add(size).After(cutoff) {
+			continue
+		}
+
+		buckets, ok := s.buckets[granularity]
+		if !ok {
+			buckets = make(map[int64]*domain.StatsBucket)
+			s.buckets[granularity] = buckets
+		}
+		b, ok := buckets[start.Unix()]
+		if !ok {
+			// Buckets are added at most once per granularity period, expired ones are removed meanwhile
+			for key, old := range buckets {
+				if !old.Start.Add(size).After(cutoff) {
+					delete(buckets, key)
+				}
+			}
+			b = &domain.StatsBucket{Start: start}
+			buckets[start.Unix()] = b
+		}
+		fn(b)
+	}
+}
@@ -0,0 +1,101 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/Kale-Grabovski/impay/api/mock"
+	"github.com/Kale-Grabovski/impay/domain"
+)
+
+func TestStatsBuckets(t *testing.T) {
+	e := echo.New()
+	cfg := &domain.Config{}
+	cfg.Stats.Retention.Minute = 2 * time.Hour
+	statsAction := NewStatsAction(cfg, &mock.ConsumerMock{}, &mock.LoggerMock{})
+	hour := time.Date(2026, 10, 17, 10, 0, 0, 0, time.UTC)
+	statsAction.now = func() time.Time {
+		return hour.Add(90 * time.Minute)
+	}
+
+	handlers := statsAction.handlers()
+	events := []struct {
+		topic string
+		event domain.WalletEvent
+	}{
+		{domain.TopicWalletCreated, domain.WalletEvent{OccurredAt: hour.Add(time.Minute)}},
+		{domain.TopicWalletDeposited, domain.WalletEvent{Amount: decimal.NewFromInt(5), OccurredAt: hour.Add(2 * time.Minute)}},
+		{domain.TopicWalletDeposited, domain.WalletEvent{Amount: decimal.NewFromInt(3), OccurredAt: hour.Add(70 * time.Minute)}},
+		{domain.TopicWalletWithdrawn, domain.WalletEvent{Amount: decimal.NewFromInt(1), OccurredAt: hour.Add(71 * time.Minute)}},
+		{domain.TopicWalletTransferred, domain.WalletEvent{Amount: decimal.NewFromInt(2), OccurredAt: hour.Add(72 * time.Minute)}},
+		{domain.TopicWalletDeleted, domain.WalletEvent{OccurredAt: hour.Add(73 * time.Minute)}},
+		// Beyond the minute retention, counted in hour and day buckets only
+		{domain.TopicWalletDeposited, domain.WalletEvent{Amount: decimal.NewFromInt(7), OccurredAt: hour.Add(-45 * time.Minute)}},
+	}
+	for _, ev := range events {
+		e := ev.event
+		handlers[ev.topic](&e)
+	}
+
+	get := func(query string) (int, statsBucketsResp) {
+		req := httptest.NewRequest(http.MethodGet, "/stats/wallets?"+query, nil)
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+
+		var resp statsBucketsResp
+		assert.NoError(t, statsAction.Get(c))
+		assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+		return rec.Code, resp
+	}
+
+	from := hour.Add(-time.Hour).Format(time.RFC3339)
+	to := hour.Add(2 * time.Hour).Format(time.RFC3339)
+	code, resp := get("granularity=hour&from=" + from + "&to=" + to)
+	assert.Equal(t, http.StatusOK, code)
+	if assert.Len(t, resp.Buckets, 3) {
+		assert.True(t, resp.Buckets[0].Start.Equal(hour.Add(-time.Hour)))
+		assert.True(t, resp.Buckets[0].Deposited.Equal(decimal.NewFromInt(7)))
+
+		assert.Equal(t, int64(1), resp.Buckets[1].Created)
+		assert.True(t, resp.Buckets[1].Deposited.Equal(decimal.NewFromInt(5)))
+
+		assert.Equal(t, int64(1), resp.Buckets[2].Deleted)
+		assert.True(t, resp.Buckets[2].Deposited.Equal(decimal.NewFromInt(3)))
+		assert.True(t, resp.Buckets[2].Withdrawn.Equal(decimal.NewFromInt(1)))
+		assert.True(t, resp.Buckets[2].Transferred.Equal(decimal.NewFromInt(2)))
+	}
+
+	// Empty buckets are returned as well, to is rounded up to the bucket end
+	code, resp = get("granularity=minute&from=" + hour.Format(time.RFC3339) + "&to=" + hour.Add(150*time.Second).Format(time.RFC3339))
+	assert.Equal(t, http.StatusOK, code)
+	if assert.Len(t, resp.Buckets, 3) {
+		assert.Equal(t, int64(0), resp.Buckets[0].Created)
+		assert.Equal(t, int64(1), resp.Buckets[1].Created)
+		assert.True(t, resp.Buckets[2].Deposited.Equal(decimal.NewFromInt(5)))
+	}
+
+	code, resp = get("granularity=day")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Len(t, resp.Buckets, bucketsDefaultCount)
+	assert.Len(t, statsAction.buckets[domain.GranularityMinute], 6)
+
+	for query, err := range map[string]string{
+		"granularity=week":           "wrong granularity",
+		"from=yesterday":             "wrong from",
+		"to=1":                       "wrong to",
+		"from=" + to + "&to=" + from: "wrong range",
+		"granularity=minute&from=2026-10-01T00:00:00Z": "too many buckets",
+		"granularity=minute&from=" + from[:4]:          "wrong from",
+	} {
+		code, resp = get(query)
+		assert.Equal(t, http.StatusBadRequest, code, query)
+		assert.Equal(t, err, resp.Err, query)
+	}
+}
@@ -0,0 +1,213 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/Kale-Grabovski/impay/domain"
+	"github.com/Kale-Grabovski/impay/ledger"
+)
+
+// EventSource reads wallet events published to Kafka.
+type EventSource interface {
+	// Replay calls fn for the events after offsets and advances offsets past them.
+	Replay(offsets domain.Offsets, fn func(topic string, payload []byte) error) error
+	// Offsets returns the offsets of the end of the event topics.
+	Offsets() (domain.Offsets, error)
+}
+
+// Replay rebuilds wallets from the latest snapshot and the events published
+// after it. It must be called before serving traffic. Pending outbox events
+// are published first, otherwise replay would roll wallets back to the state
+// known to Kafka. Holds are not part of events, held amounts are kept as stored.
+// Balance changes made by replay are journaled as replay transactions, so the
+// ledger keeps matching the wallets.
+func (s *WalletAction) Replay(source EventSource, snapshots domain.SnapshotStore) error {
+	if err := s.relayOutbox(); err != nil {
+		return fmt.Errorf("cannot publish pending events: %w", err)
+	}
+
+	offsets := make(domain.Offsets)
+	var wallets []*domain.Wallet
+	if snapshots != nil {
+		snapshot, err := snapshots.Load()
+		if err != nil {
+			return err
+		}
+		if snapshot != nil {
+			offsets, wallets = snapshot.Offsets, snapshot.Wallets
+		}
+	}
+	if offsets == nil {
+		offsets = make(domain.Offsets)
+	}
+
+	// Every topic keeps its own order, events are sorted by time to apply
+	// the changes of a wallet published to different topics in order
+	var events []*domain.WalletEvent
+	skipped := 0
+	err := source.Replay(offsets, func(topic string, payload []byte) error {
+		e, err := domain.ParseWalletEvent(topic, payload)
+		if err != nil || e.WalletID == "" {
+			skipped++
+			return nil
+		}
+		events = append(events, e)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("cannot replay events: %w", err)
+	}
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].OccurredAt.Before(events[j].OccurredAt)
+	})
+
+	err = s.repo.Update(func(tx domain.WalletTx) error {
+		for _, w := range wallets {
+			if err := restoreWallet(tx, w.ID, func(wallet *domain.Wallet) {
+				wallet.Name, wallet.Currency, wallet.Status, wallet.Balance = w.Name, w.Currency, w.Status, w.Balance
+			}); err != nil {
+				return err
+			}
+		}
+		for _, e := range events {
+			if err := applyEvent(tx, e); err != nil {
+				return err
+			}
+		}
+
+		restored, err := tx.GetAll()
+		if err != nil {
+			return err
+		}
+		for _, w := range restored {
+			if err = ledger.Reconcile(tx, w.ID); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("cannot restore wallets: %w", err)
+	}
+
+	s.logger.Info("wallets replayed",
+		zap.Int("snapshot", len(wallets)),
+		zap.Int("events", len(events)),
+		zap.Int("skipped", skipped),
+	)
+	return nil
+}
+
+// StartSnapshots saves a wallet snapshot every interval and once more on Stop.
+func (s *WalletAction) StartSnapshots(source EventSource, snapshots domain.SnapshotStore, interval time.Duration) {
+	if interval <= 0 {
+		interval = domain.DefaultSnapshotInterval
+	}
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+			case <-s.ctx.Done():
+				if err := s.snapshot(source, snapshots); err != nil {
+					s.logger.Error("cannot save wallet snapshot", zap.Error(err))
+				}
+				return
+			}
+			if err := s.snapshot(source, snapshots); err != nil {
+				s.logger.Error("cannot save wallet snapshot", zap.Error(err))
+			}
+		}
+	}()
+}
+
+var errOutboxPending = errors.New("outbox has undelivered events")
+
+// snapshot saves wallets together with the end offsets of the event topics.
+// Both match only when every event is delivered, so the relay is held
+// meanwhile and the snapshot is skipped if the outbox is not empty.
+func (s *WalletAction) snapshot(source EventSource, snapshots domain.SnapshotStore) error {
+	s.relayMu.Lock()
+	defer s.relayMu.Unlock()
+
+	var wallets []*domain.Wallet
+	err := s.repo.View(func(tx domain.WalletTx) error {
+		pending, err := tx.PendingOutbox(1)
+		if err != nil {
+			return err
+		}
+		if len(pending) > 0 {
+			return errOutboxPending
+		}
+		wallets, err = tx.GetAll()
+		return err
+	})
+	if errors.Is(err, errOutboxPending) {
+		s.logger.Debug("wallet snapshot skipped: " + err.Error())
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	offsets, err := source.Offsets()
+	if err != nil {
+		return err
+	}
+	return snapshots.Save(&domain.WalletSnapshot{
+		Wallets:   wallets,
+		Offsets:   offsets,
+		CreatedAt: time.Now().UTC(),
+	})
+}
+
+// applyEvent sets the wallet state carried by the event. Events published
+// before the state was added carry only the balance.
+func applyEvent(tx domain.WalletTx, e *domain.WalletEvent) error {
+	err := restoreWallet(tx, e.WalletID, func(wallet *domain.Wallet) {
+		if e.Name != "" {
+			wallet.Name = e.Name
+		}
+		if e.Currency != "" {
+			wallet.Currency = e.Currency
+		}
+		if e.Owner != "" {
+			wallet.Owner = e.Owner
+		}
+		if e.Status != "" {
+			wallet.Status = e.Status
+		} else if e.Type == domain.TopicWalletDeleted {
+			wallet.Status = domain.StatusInactive
+		}
+		if e.Balance != nil {
+			wallet.Balance = *e.Balance
+		}
+	})
+	if err != nil || e.CounterpartyID == "" || e.CounterpartyBalance == nil {
+		return err
+	}
+	return restoreWallet(tx, e.CounterpartyID, func(wallet *domain.Wallet) {
+		wallet.Balance = *e.CounterpartyBalance
+	})
+}
+
+// restoreWallet applies fn to the stored wallet, a missing wallet is created.
+func restoreWallet(tx domain.WalletTx, id string, fn func(*domain.Wallet)) error {
+	wallet, err := tx.Get(id)
+	if errors.Is(err, domain.ErrWalletNotFound) {
+		wallet, err = domain.NewWallet(id, "", ""), nil
+	}
+	if err != nil {
+		return err
+	}
+	fn(wallet)
+	return tx.Put(wallet)
+}
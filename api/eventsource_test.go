@@ -0,0 +1,150 @@
+package api
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	mockery "github.com/stretchr/testify/mock"
+
+	"github.com/Kale-Grabovski/impay/api/mock"
+	"github.com/Kale-Grabovski/impay/domain"
+	"github.com/Kale-Grabovski/impay/ledger"
+	"github.com/Kale-Grabovski/impay/storage"
+)
+
+func TestReplay(t *testing.T) {
+	repo := storage.NewMemoryRepository()
+	producerMock := &mock.ProducerMock{}
+	loggerMock := &mock.LoggerMock{}
+	sourceMock := &mock.EventSourceMock{}
+	walletAction := NewWalletAction(&domain.Config{}, repo, &mock.RateProviderMock{}, producerMock, loggerMock)
+
+	snapshots, err := storage.NewFileSnapshotStore(filepath.Join(t.TempDir(), "snapshot.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	snapshotWallet := domain.NewWallet("a", "first", "USD")
+	snapshotWallet.Balance = decimal.NewFromInt(10)
+	assert.NoError(t, snapshots.Save(&domain.WalletSnapshot{
+		Wallets: []*domain.Wallet{snapshotWallet},
+		Offsets: domain.Offsets{domain.TopicWalletCreated: {0: 1}},
+	}))
+
+	// Topics are read one after another, events are applied in the order they occurred
+	events := []struct {
+		topic   string
+		payload string
+	}{
+		{
+			domain.TopicWalletDeposited,
+			`{"version":1,"wallet_id":"a","amount":"5","currency":"USD","name":"first","status":"active","balance":"15","occurred_at":"2026-10-01T10:00:01Z"}`,
+		},
+		{
+			domain.TopicWalletTransferred,
+			`{"version":1,"wallet_id":"a","counterparty_id":"b","amount":"3","currency":"USD","name":"first","status":"active","balance":"12","counterparty_balance":"3","occurred_at":"2026-10-01T10:00:03Z"}`,
+		},
+		{
+			domain.TopicWalletCreated,
+			`{"version":1,"wallet_id":"b","amount":"0","currency":"USD","name":"second","status":"active","balance":"0","occurred_at":"2026-10-01T10:00:02Z"}`,
+		},
+		{
+			domain.TopicWalletUpdated,
+			`{"version":1,"wallet_id":"b","amount":"0","currency":"USD","name":"renamed","status":"active","balance":"3","occurred_at":"2026-10-01T10:00:04Z"}`,
+		},
+		{
+			domain.TopicWalletDeleted,
+			`{"version":1,"wallet_id":"a","amount":"0","currency":"USD","name":"first","status":"inactive","balance":"12","occurred_at":"2026-10-01T10:00:05Z"}`,
+		},
+		// Events without wallet IDs cannot be replayed
+		{domain.TopicWalletDeposited, `{"amount":"7","currency":"USD"}`},
+		{domain.TopicWalletDeposited, `{"amount":`},
+	}
+	sourceMock.
+		On("Replay", domain.Offsets{domain.TopicWalletCreated: {0: 1}}, mockery.Anything).
+		Once().
+		Run(func(args mockery.Arguments) {
+			fn := args.Get(1).(func(string, []byte) error)
+			for _, e := range events {
+				assert.NoError(t, fn(e.topic, []byte(e.payload)))
+			}
+		}).
+		Return(nil)
+	loggerMock.On("Info", "wallets replayed", mockery.Anything).Once().Return(nil)
+
+	assert.NoError(t, walletAction.Replay(sourceMock, snapshots))
+	mockery.AssertExpectationsForObjects(t, loggerMock, sourceMock, producerMock)
+
+	expected := map[string]*domain.Wallet{
+		"a": {ID: "a", Name: "first", Currency: "USD", Status: domain.StatusInactive, Balance: decimal.NewFromInt(12)},
+		"b": {ID: "b", Name: "renamed", Currency: "USD", Status: domain.StatusActive, Balance: decimal.NewFromInt(3)},
+	}
+	assert.NoError(t, repo.View(func(tx domain.WalletTx) error {
+		wallets, err := tx.GetAll()
+		assert.NoError(t, err)
+		assert.Len(t, wallets, len(expected))
+		for _, w := range wallets {
+			e := expected[w.ID]
+			if assert.NotNil(t, e, w.ID) {
+				assert.Equal(t, e.Name, w.Name)
+				assert.Equal(t, e.Currency, w.Currency)
+				assert.Equal(t, e.Status, w.Status)
+				assert.True(t, e.Balance.Equal(w.Balance), w.Balance.String())
+			}
+			// Replayed balances are journaled
+			assert.NoError(t, ledger.Verify(tx, w.ID))
+		}
+		return nil
+	}))
+}
+
+func TestSnapshot(t *testing.T) {
+	repo := storage.NewMemoryRepository()
+	loggerMock := &mock.LoggerMock{}
+	sourceMock := &mock.EventSourceMock{}
+	walletAction := NewWalletAction(&domain.Config{}, repo, &mock.RateProviderMock{}, &mock.ProducerMock{}, loggerMock)
+
+	snapshots, err := storage.NewFileSnapshotStore(filepath.Join(t.TempDir(), "snapshot.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.NoError(t, repo.Update(func(tx domain.WalletTx) error {
+		return tx.Put(domain.NewWallet("a", "first", "USD"))
+	}))
+
+	offsets := domain.Offsets{domain.TopicWalletCreated: {0: 1, 1: 0}}
+	sourceMock.On("Offsets").Once().Return(offsets, nil)
+	assert.NoError(t, walletAction.snapshot(sourceMock, snapshots))
+
+	snapshot, err := snapshots.Load()
+	assert.NoError(t, err)
+	if assert.NotNil(t, snapshot) {
+		assert.Equal(t, offsets, snapshot.Offsets)
+		if assert.Len(t, snapshot.Wallets, 1) {
+			assert.Equal(t, "a", snapshot.Wallets[0].ID)
+		}
+	}
+
+	// Wallets are ahead of Kafka while events wait in the outbox
+	assert.NoError(t, repo.Update(func(tx domain.WalletTx) error {
+		if err := tx.Put(domain.NewWallet("b", "second", "USD")); err != nil {
+			return err
+		}
+		e, err := walletEvent(tx, domain.TopicWalletCreated, "b")
+		if err != nil {
+			return err
+		}
+		return walletAction.outboxAdd(context.Background(), tx, e)
+	}))
+	loggerMock.On("Debug", "wallet snapshot skipped: "+errOutboxPending.Error(), mockery.Anything).Once().Return(nil)
+	assert.NoError(t, walletAction.snapshot(sourceMock, snapshots))
+	mockery.AssertExpectationsForObjects(t, loggerMock, sourceMock)
+
+	snapshot, err = snapshots.Load()
+	assert.NoError(t, err)
+	if assert.NotNil(t, snapshot) {
+		assert.Len(t, snapshot.Wallets, 1)
+	}
+}
@@ -0,0 +1,112 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+
+	"github.com/Kale-Grabovski/impay/domain"
+)
+
+const healthOK = "ok"
+
+type healthResp struct {
+	// Checks hold "ok" or the error of every readiness check by name
+	Checks  map[string]string `json:"checks,omitempty"`
+	Success bool              `json:"success"`
+}
+
+// HealthAction serves the liveness and readiness probes.
+type HealthAction struct {
+	mu      sync.Mutex
+	checks  map[string]domain.HealthCheck
+	timeout time.Duration
+	logger  domain.Logger
+}
+
+func NewHealthAction(cfg *domain.Config, logger domain.Logger) *HealthAction {
+	timeout := cfg.Health.Timeout
+	if timeout <= 0 {
+		timeout = domain.DefaultHealthTimeout
+	}
+	return &HealthAction{
+		checks:  make(map[string]domain.HealthCheck),
+		timeout: timeout,
+		logger:  logger,
+	}
+}
+
+// AddCheck registers a readiness check, a check added under the same name replaces the previous one.
+func (s *HealthAction) AddCheck(name string, check domain.HealthCheck) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.checks[name] = check
+}
+
+// Live reports that the process serves requests.
+func (s *HealthAction) Live(c echo.Context) error {
+	return c.JSON(http.StatusOK, healthResp{
+		Success: true,
+	})
+}
+
+// Ready runs all checks concurrently within health.timeout and fails with
+// 503 Service Unavailable if any of them fails.
+func (s *HealthAction) Ready(c echo.Context) error {
+	s.mu.Lock()
+	checks := make(map[string]domain.HealthCheck, len(s.checks))
+	for name, check := range s.checks {
+		checks[name] = check
+	}
+	s.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(c.Request().Context(), s.timeout)
+	defer cancel()
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	resp := healthResp{
+		Checks:  make(map[string]string, len(checks)),
+		Success: true,
+	}
+	for name, check := range checks {
+		wg.Add(1)
+		go func(name string, check domain.HealthCheck) {
+			defer wg.Done()
+			err := runCheck(ctx, check)
+
+			mu.Lock()
+			defer mu.Unlock()
+			resp.Checks[name] = healthOK
+			if err != nil {
+				requestLogger(c, s.logger).Warn("readiness check "+name+" failed", zap.Error(err))
+				resp.Checks[name] = err.Error()
+				resp.Success = false
+			}
+		}(name, check)
+	}
+	wg.Wait()
+
+	if !resp.Success {
+		return c.JSON(http.StatusServiceUnavailable, resp)
+	}
+	return c.JSON(http.StatusOK, resp)
+}
+
+// runCheck gives up on a check ignoring ctx once ctx is done.
+func runCheck(ctx context.Context, check domain.HealthCheck) error {
+	done := make(chan error, 1)
+	go func() {
+		done <- check(ctx)
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
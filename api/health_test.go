@@ -0,0 +1,69 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	mockery "github.com/stretchr/testify/mock"
+
+	"github.com/Kale-Grabovski/impay/api/mock"
+	"github.com/Kale-Grabovski/impay/domain"
+)
+
+func TestHealth(t *testing.T) {
+	e := echo.New()
+	cfg := &domain.Config{}
+	cfg.Health.Timeout = 50 * time.Millisecond
+	loggerMock := &mock.LoggerMock{}
+	healthAction := NewHealthAction(cfg, loggerMock)
+
+	get := func(handler echo.HandlerFunc) (int, healthResp) {
+		rec := httptest.NewRecorder()
+		c := e.NewContext(httptest.NewRequest(http.MethodGet, "/readyz", nil), rec)
+
+		var resp healthResp
+		assert.NoError(t, handler(c))
+		assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+		return rec.Code, resp
+	}
+
+	healthAction.AddCheck("kafka", func(ctx context.Context) error {
+		return nil
+	})
+	code, resp := get(healthAction.Ready)
+	assert.Equal(t, http.StatusOK, code)
+	assert.True(t, resp.Success)
+	assert.Equal(t, map[string]string{"kafka": healthOK}, resp.Checks)
+
+	// A check ignoring the context is given up on after the timeout
+	healthAction.AddCheck("storage", func(ctx context.Context) error {
+		time.Sleep(time.Second)
+		return nil
+	})
+	healthAction.AddCheck("consumer", func(ctx context.Context) error {
+		return errors.New("no partitions assigned")
+	})
+	loggerMock.On("Warn", "readiness check storage failed", mockery.Anything).Once().Return(nil)
+	loggerMock.On("Warn", "readiness check consumer failed", mockery.Anything).Once().Return(nil)
+	code, resp = get(healthAction.Ready)
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.False(t, resp.Success)
+	assert.Equal(t, map[string]string{
+		"kafka":    healthOK,
+		"storage":  context.DeadlineExceeded.Error(),
+		"consumer": "no partitions assigned",
+	}, resp.Checks)
+	loggerMock.AssertExpectations(t)
+
+	// Liveness does not depend on the checks
+	code, resp = get(healthAction.Live)
+	assert.Equal(t, http.StatusOK, code)
+	assert.True(t, resp.Success)
+}
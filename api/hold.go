@@ -0,0 +1,266 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/shopspring/decimal"
+	"go.uber.org/zap"
+
+	"github.com/Kale-Grabovski/impay/domain"
+	"github.com/Kale-Grabovski/impay/ledger"
+)
+
+const holdIDBytes = 8
+
+type holdReq struct {
+	Amount decimal.Decimal `json:"amount"`
+}
+
+type holdResp struct {
+	ID            string          `json:"id"`
+	WalletID      string          `json:"wallet_id"`
+	Amount        decimal.Decimal `json:"amount"`
+	Captured      decimal.Decimal `json:"captured"`
+	Currency      string          `json:"currency"`
+	Status        string          `json:"status"`
+	ExpiresAt     time.Time       `json:"expires_at"`
+	TransactionID uint64          `json:"transaction_id,omitempty"`
+	Err           string          `json:"err_code,omitempty"`
+	Success       bool            `json:"success"`
+}
+
+// CreateHold reserves the amount against the available wallet balance until
+// the hold is captured, voided or expires.
+func (s *WalletAction) CreateHold(c echo.Context) (err error) {
+	return s.holdProcess(c, func(tx domain.WalletTx, req *holdReq) (int, holdResp, error) {
+		wallet, err := usableWallet(c, tx, c.Param("id"))
+		if errors.Is(err, domain.ErrWalletNotFound) {
+			return http.StatusNotFound, holdResp{Err: "wallet not found"}, nil
+		}
+		if err != nil {
+			return 0, holdResp{}, err
+		}
+		if !req.Amount.IsPositive() {
+			return http.StatusBadRequest, holdResp{Err: "wrong amount"}, nil
+		}
+		if domain.ValidateAmount(req.Amount, wallet.Currency) != nil {
+			return http.StatusBadRequest, holdResp{Err: "wrong amount scale"}, nil
+		}
+		if wallet.Available().LessThan(req.Amount) {
+			return http.StatusBadRequest, holdResp{Err: "not enough money to hold"}, nil
+		}
+
+		id, err := randomID(holdIDBytes)
+		if err != nil {
+			return 0, holdResp{}, err
+		}
+		now := time.Now().UTC()
+		h := &domain.Hold{
+			ID:        id,
+			WalletID:  wallet.ID,
+			Amount:    req.Amount,
+			Currency:  wallet.Currency,
+			Status:    domain.HoldActive,
+			CreatedAt: now,
+			ExpiresAt: now.Add(s.holdTTL),
+		}
+		wallet.Held = wallet.Held.Add(h.Amount)
+		if err = tx.Put(wallet); err != nil {
+			return 0, holdResp{}, err
+		}
+		return http.StatusOK, newHoldResp(h), tx.PutHold(h)
+	})
+}
+
+// CaptureHold withdraws the held money. A smaller amount than the hold
+// may be captured, the rest is released back to the wallet.
+func (s *WalletAction) CaptureHold(c echo.Context) (err error) {
+	return s.holdProcess(c, func(tx domain.WalletTx, req *holdReq) (int, holdResp, error) {
+		h, code, resp, err := s.activeHold(c, tx, c.Param("id"))
+		if h == nil {
+			return code, resp, err
+		}
+
+		amount := h.Amount
+		if !req.Amount.IsZero() {
+			amount = req.Amount
+		}
+		if !amount.IsPositive() || amount.GreaterThan(h.Amount) {
+			return http.StatusBadRequest, holdResp{Err: "wrong amount"}, nil
+		}
+		if domain.ValidateAmount(amount, h.Currency) != nil {
+			return http.StatusBadRequest, holdResp{Err: "wrong amount scale"}, nil
+		}
+
+		if err = s.releaseHold(tx, h, domain.HoldCaptured); err != nil {
+			return 0, holdResp{}, err
+		}
+		t := ledger.Capture(h.WalletID, h.Currency, amount)
+		if err = ledger.Post(tx, t); err != nil {
+			return 0, holdResp{}, err
+		}
+		h.Captured = amount
+		h.TransactionID = t.ID
+		return http.StatusOK, newHoldResp(h), tx.PutHold(h)
+	})
+}
+
+// VoidHold releases the held money back to the wallet.
+func (s *WalletAction) VoidHold(c echo.Context) (err error) {
+	return s.holdProcess(c, func(tx domain.WalletTx, req *holdReq) (int, holdResp, error) {
+		h, code, resp, err := s.activeHold(c, tx, c.Param("id"))
+		if h == nil {
+			return code, resp, err
+		}
+		if err = s.releaseHold(tx, h, domain.HoldVoided); err != nil {
+			return 0, holdResp{}, err
+		}
+		return http.StatusOK, newHoldResp(h), nil
+	})
+}
+
+// holdProcess runs callback inside a single storage transaction, honoring
+// the Idempotency-Key header. Captured holds are published as withdrawals.
+func (s *WalletAction) holdProcess(
+	c echo.Context,
+	callback func(domain.WalletTx, *holdReq) (int, holdResp, error),
+) error {
+	ireq, err := readIdempotency(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, holdResp{
+			Err: "wrong input params",
+		})
+	}
+	req := &holdReq{}
+	if err = c.Bind(req); err != nil {
+		return c.JSON(http.StatusBadRequest, holdResp{
+			Err: "wrong input params",
+		})
+	}
+
+	var (
+		code   int
+		resp   holdResp
+		replay *domain.IdempotencyRecord
+	)
+	err = s.repo.Update(func(tx domain.WalletTx) (err error) {
+		replay, err = s.idempotencyLookup(tx, ireq)
+		if err != nil || replay != nil {
+			return err
+		}
+		code, resp, err = callback(tx, req)
+		if err != nil {
+			return err
+		}
+		if code == http.StatusOK && resp.Status == domain.HoldCaptured {
+			e, err := walletEvent(tx, domain.TopicWalletWithdrawn, resp.WalletID)
+			if err != nil {
+				return err
+			}
+			e.Amount = resp.Captured
+			e.TransactionID = resp.TransactionID
+			if err = s.outboxAdd(c.Request().Context(), tx, e); err != nil {
+				return err
+			}
+		}
+		return s.idempotencySave(tx, ireq, code, resp)
+	})
+	if errors.Is(err, domain.ErrIdempotencyConflict) {
+		return c.JSON(http.StatusConflict, holdResp{
+			Err: err.Error(),
+		})
+	}
+	if err != nil {
+		requestLogger(c, s.logger).Error("cannot process hold", zap.Error(err))
+		return c.JSON(http.StatusInternalServerError, holdResp{
+			Err: errInternal,
+		})
+	}
+	if replay != nil {
+		return replayResponse(c, replay)
+	}
+
+	if resp.WalletID != "" {
+		setWalletID(c, resp.WalletID)
+	}
+	if code == http.StatusOK && resp.Status == domain.HoldCaptured {
+		s.publish()
+	}
+	return c.JSON(code, resp)
+}
+
+// activeHold returns the hold if it can still be captured or voided, otherwise
+// the response to send. A hold found past its expiration is expired on the spot.
+// Holds on wallets the caller may not use are not found.
+func (s *WalletAction) activeHold(c echo.Context, tx domain.WalletTx, id string) (*domain.Hold, int, holdResp, error) {
+	h, err := tx.GetHold(id)
+	if err == nil {
+		_, err = usableWallet(c, tx, h.WalletID)
+	}
+	if errors.Is(err, domain.ErrHoldNotFound) || errors.Is(err, domain.ErrWalletNotFound) {
+		return nil, http.StatusNotFound, holdResp{Err: "hold not found"}, nil
+	}
+	if err != nil {
+		return nil, 0, holdResp{}, err
+	}
+	if h.Status == domain.HoldActive && h.Expired(time.Now()) {
+		if err = s.releaseHold(tx, h, domain.HoldExpired); err != nil {
+			return nil, 0, holdResp{}, err
+		}
+	}
+	if h.Status != domain.HoldActive {
+		return nil, http.StatusConflict, holdResp{Err: "hold is " + h.Status}, nil
+	}
+	return h, 0, holdResp{}, nil
+}
+
+// releaseHold returns the held amount to the available wallet balance.
+func (s *WalletAction) releaseHold(tx domain.WalletTx, h *domain.Hold, status string) error {
+	wallet, err := tx.Get(h.WalletID)
+	if err != nil {
+		return err
+	}
+	wallet.Held = wallet.Held.Sub(h.Amount)
+	if err = tx.Put(wallet); err != nil {
+		return err
+	}
+	h.Status = status
+	return tx.PutHold(h)
+}
+
+// expireHolds releases active holds past their expiration time.
+func (s *WalletAction) expireHolds() error {
+	return s.repo.Update(func(tx domain.WalletTx) error {
+		holds, err := tx.ActiveHolds()
+		if err != nil {
+			return err
+		}
+		now := time.Now()
+		for _, h := range holds {
+			if !h.Expired(now) {
+				continue
+			}
+			if err = s.releaseHold(tx, h, domain.HoldExpired); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func newHoldResp(h *domain.Hold) holdResp {
+	return holdResp{
+		ID:            h.ID,
+		WalletID:      h.WalletID,
+		Amount:        h.Amount,
+		Captured:      h.Captured,
+		Currency:      h.Currency,
+		Status:        h.Status,
+		ExpiresAt:     h.ExpiresAt,
+		TransactionID: h.TransactionID,
+		Success:       true,
+	}
+}
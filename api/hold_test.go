@@ -0,0 +1,140 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	mockery "github.com/stretchr/testify/mock"
+
+	"github.com/Kale-Grabovski/impay/api/mock"
+	"github.com/Kale-Grabovski/impay/domain"
+	"github.com/Kale-Grabovski/impay/ledger"
+	"github.com/Kale-Grabovski/impay/storage"
+)
+
+func TestHolds(t *testing.T) {
+	e := echo.New()
+	repo := storage.NewMemoryRepository()
+	producerMock := &mock.ProducerMock{}
+	loggerMock := &mock.LoggerMock{}
+	walletAction := NewWalletAction(&domain.Config{}, repo, &mock.RateProviderMock{}, producerMock, loggerMock)
+
+	assert.NoError(t, repo.Update(func(tx domain.WalletTx) error {
+		if err := tx.Put(domain.NewWallet("a", "", "USD")); err != nil {
+			return err
+		}
+		return ledger.Post(tx, ledger.Deposit("a", "USD", decimal.NewFromInt(10)))
+	}))
+
+	producerMock.
+		On(
+			"Produce",
+			domain.TopicWalletWithdrawn,
+			"a",
+			eventMatching(domain.WalletEvent{Amount: decimal.NewFromInt(3), Currency: "USD"}),
+		).
+		Once().
+		Return(nil)
+
+	call := func(handler echo.HandlerFunc, path, id, body string) (int, holdResp) {
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		c.SetPath(path)
+		c.SetParamNames("id")
+		c.SetParamValues(id)
+
+		var resp holdResp
+		assert.NoError(t, handler(c))
+		assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+		return rec.Code, resp
+	}
+	available := func(expected int64) {
+		assert.NoError(t, repo.View(func(tx domain.WalletTx) error {
+			w, err := tx.Get("a")
+			assert.NoError(t, err)
+			assert.True(t, decimal.NewFromInt(expected).Equal(w.Available()), w.Available().String())
+			return nil
+		}))
+	}
+
+	code, resp := call(walletAction.CreateHold, "/wallets/:id/holds", "a", `{"amount": 11}`)
+	assert.Equal(t, http.StatusBadRequest, code)
+	assert.Equal(t, "not enough money to hold", resp.Err)
+
+	code, resp = call(walletAction.CreateHold, "/wallets/:id/holds", "b", `{"amount": 1}`)
+	assert.Equal(t, http.StatusNotFound, code)
+	assert.Equal(t, "wallet not found", resp.Err)
+
+	code, captured := call(walletAction.CreateHold, "/wallets/:id/holds", "a", `{"amount": 4}`)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, domain.HoldActive, captured.Status)
+	available(6)
+
+	code, voided := call(walletAction.CreateHold, "/wallets/:id/holds", "a", `{"amount": 5}`)
+	assert.Equal(t, http.StatusOK, code)
+	available(1)
+
+	// Held money cannot be withdrawn
+	code, _ = call(walletAction.Withdraw, "/wallets/:id/withdraw", "a", `{"amount": 2}`)
+	assert.Equal(t, http.StatusBadRequest, code)
+
+	code, resp = call(walletAction.CaptureHold, "/holds/:id/capture", captured.ID, `{"amount": 5}`)
+	assert.Equal(t, http.StatusBadRequest, code)
+	assert.Equal(t, "wrong amount", resp.Err)
+
+	// Partial capture releases the rest of the hold
+	code, resp = call(walletAction.CaptureHold, "/holds/:id/capture", captured.ID, `{"amount": 3}`)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, domain.HoldCaptured, resp.Status)
+	assert.NotZero(t, resp.TransactionID)
+	assert.True(t, decimal.NewFromInt(4).Equal(resp.Amount), resp.Amount.String())
+	assert.True(t, decimal.NewFromInt(3).Equal(resp.Captured), resp.Captured.String())
+	available(2)
+
+	code, resp = call(walletAction.VoidHold, "/holds/:id/void", captured.ID, ``)
+	assert.Equal(t, http.StatusConflict, code)
+	assert.Equal(t, "hold is "+domain.HoldCaptured, resp.Err)
+
+	code, resp = call(walletAction.VoidHold, "/holds/:id/void", voided.ID, ``)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, domain.HoldVoided, resp.Status)
+	available(7)
+
+	code, resp = call(walletAction.CaptureHold, "/holds/:id/capture", "missing", ``)
+	assert.Equal(t, http.StatusNotFound, code)
+	assert.Equal(t, "hold not found", resp.Err)
+
+	// Expired holds are released by the janitor
+	walletAction.holdTTL = time.Nanosecond
+	code, expired := call(walletAction.CreateHold, "/wallets/:id/holds", "a", `{"amount": 7}`)
+	assert.Equal(t, http.StatusOK, code)
+	available(0)
+	time.Sleep(time.Millisecond)
+	assert.NoError(t, walletAction.expireHolds())
+	available(7)
+
+	code, resp = call(walletAction.CaptureHold, "/holds/:id/capture", expired.ID, ``)
+	assert.Equal(t, http.StatusConflict, code)
+	assert.Equal(t, "hold is "+domain.HoldExpired, resp.Err)
+
+	relayNotified(walletAction)
+	mockery.AssertExpectationsForObjects(t, loggerMock, producerMock)
+
+	assert.NoError(t, repo.View(func(tx domain.WalletTx) error {
+		w, err := tx.Get("a")
+		assert.NoError(t, err)
+		assert.True(t, decimal.NewFromInt(7).Equal(w.Balance))
+		assert.True(t, w.Held.IsZero())
+		assert.NoError(t, ledger.Verify(tx, "a"))
+		return nil
+	}))
+}
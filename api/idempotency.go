@@ -0,0 +1,108 @@
+package api
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/Kale-Grabovski/impay/domain"
+)
+
+const headerIdempotentReplayed = "Idempotent-Replayed"
+
+// idempotencyReq identifies a request sent with an Idempotency-Key header.
+type idempotencyReq struct {
+	key  string
+	hash string
+}
+
+// readIdempotency returns nil if the request has no Idempotency-Key header.
+// The request body is fingerprinted and restored so it can still be bound.
+func readIdempotency(c echo.Context) (*idempotencyReq, error) {
+	key := c.Request().Header.Get(domain.HeaderIdempotencyKey)
+	if key == "" {
+		return nil, nil
+	}
+	// Keys are scoped to the caller, another one reusing a key does not get its response
+	if p := principal(c); p != nil {
+		key = p.Subject + ":" + key
+	}
+
+	var body []byte
+	if c.Request().Body != nil {
+		var err error
+		body, err = io.ReadAll(c.Request().Body)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read request body: %w", err)
+		}
+		c.Request().Body = io.NopCloser(bytes.NewReader(body))
+	}
+
+	h := sha256.New()
+	h.Write([]byte(c.Request().Method + " " + c.Path() + " " + strings.Join(c.ParamValues(), "/") + "\n"))
+	h.Write(body)
+	return &idempotencyReq{
+		key:  key,
+		hash: hex.EncodeToString(h.Sum(nil)),
+	}, nil
+}
+
+// idempotencyLookup returns the stored response of an already processed request.
+// Reusing the key for a different request fails with domain.ErrIdempotencyConflict.
+func (s *WalletAction) idempotencyLookup(
+	tx domain.WalletTx,
+	req *idempotencyReq,
+) (*domain.IdempotencyRecord, error) {
+	if req == nil {
+		return nil, nil
+	}
+	r, err := tx.GetIdempotency(req.key)
+	if err != nil || r == nil || r.Expired(s.idempotencyTTL, time.Now()) {
+		return nil, err
+	}
+	if r.RequestHash != req.hash {
+		return nil, domain.ErrIdempotencyConflict
+	}
+	return r, nil
+}
+
+func (s *WalletAction) idempotencySave(
+	tx domain.WalletTx,
+	req *idempotencyReq,
+	code int,
+	resp any,
+) error {
+	if req == nil {
+		return nil
+	}
+	body, err := json.Marshal(resp)
+	if err != nil {
+		return fmt.Errorf("cannot marshal response: %w", err)
+	}
+	return tx.PutIdempotency(&domain.IdempotencyRecord{
+		Key:         req.key,
+		RequestHash: req.hash,
+		StatusCode:  code,
+		Body:        body,
+		CreatedAt:   time.Now().UTC(),
+	})
+}
+
+func replayResponse(c echo.Context, r *domain.IdempotencyRecord) error {
+	c.Response().Header().Set(headerIdempotentReplayed, "true")
+	return c.JSONBlob(r.StatusCode, r.Body)
+}
+
+// purgeIdempotency removes expired idempotency records.
+func (s *WalletAction) purgeIdempotency() error {
+	return s.repo.Update(func(tx domain.WalletTx) error {
+		return tx.PurgeIdempotency(time.Now().Add(-s.idempotencyTTL))
+	})
+}
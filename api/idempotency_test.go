@@ -0,0 +1,101 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	mockery "github.com/stretchr/testify/mock"
+
+	"github.com/Kale-Grabovski/impay/api/mock"
+	"github.com/Kale-Grabovski/impay/domain"
+	"github.com/Kale-Grabovski/impay/storage"
+)
+
+func TestIdempotentDeposit(t *testing.T) {
+	e := echo.New()
+	repo := storage.NewMemoryRepository()
+	producerMock := &mock.ProducerMock{}
+	loggerMock := &mock.LoggerMock{}
+	walletAction := NewWalletAction(&domain.Config{}, repo, &mock.RateProviderMock{}, producerMock, loggerMock)
+
+	assert.NoError(t, repo.Update(func(tx domain.WalletTx) error {
+		return tx.Put(domain.NewWallet("a", "", "USD"))
+	}))
+
+	producerMock.
+		On(
+			"Produce",
+			domain.TopicWalletDeposited,
+			"a",
+			eventMatching(domain.WalletEvent{Amount: decimal.NewFromInt(5), Currency: "USD"}),
+		).
+		Once().
+		Return(nil)
+
+	testCases := []struct {
+		key      string
+		req      string
+		respCode int
+		replayed bool
+		err      string
+	}{
+		{key: "k1", req: `{"amount": 5}`, respCode: http.StatusOK},
+		{key: "k1", req: `{"amount": 5}`, respCode: http.StatusOK, replayed: true},
+		{key: "k1", req: `{"amount": 6}`, respCode: http.StatusConflict, err: domain.ErrIdempotencyConflict.Error()},
+		{key: "k2", req: `{"amount": 0}`, respCode: http.StatusBadRequest, err: "wrong amount"},
+		{key: "k2", req: `{"amount": 0}`, respCode: http.StatusBadRequest, replayed: true, err: "wrong amount"},
+	}
+
+	var first financeResp
+	for i, tc := range testCases {
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(tc.req))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		req.Header.Set(domain.HeaderIdempotencyKey, tc.key)
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		c.SetPath("/wallets/:id/deposit")
+		c.SetParamNames("id")
+		c.SetParamValues("a")
+
+		if assert.NoError(t, walletAction.Deposit(c)) {
+			assert.Equal(t, tc.respCode, rec.Code)
+			assert.Equal(t, tc.replayed, rec.Header().Get(headerIdempotentReplayed) == "true")
+
+			var resp financeResp
+			assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+			assert.Equal(t, tc.err, resp.Err)
+			if i == 0 {
+				first = resp
+			}
+			if tc.replayed && tc.err == "" {
+				assert.Equal(t, first.TransactionID, resp.TransactionID)
+			}
+		}
+	}
+	relayNotified(walletAction)
+	mockery.AssertExpectationsForObjects(t, loggerMock, producerMock)
+
+	assert.NoError(t, repo.View(func(tx domain.WalletTx) error {
+		w, err := tx.Get("a")
+		assert.NoError(t, err)
+		assert.True(t, decimal.NewFromInt(5).Equal(w.Balance))
+		return nil
+	}))
+
+	// Expired keys are purged and can be used again
+	walletAction.idempotencyTTL = time.Nanosecond
+	assert.NoError(t, walletAction.purgeIdempotency())
+	assert.NoError(t, repo.View(func(tx domain.WalletTx) error {
+		r, err := tx.GetIdempotency("k1")
+		assert.NoError(t, err)
+		assert.Nil(t, r)
+		return nil
+	}))
+}
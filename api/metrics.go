@@ -0,0 +1,120 @@
+package api
+
+import (
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+
+	"github.com/Kale-Grabovski/impay/domain"
+	"github.com/Kale-Grabovski/impay/metrics"
+)
+
+// MetricsMiddleware counts the requests and their latency per route and status.
+func MetricsMiddleware(reg prometheus.Registerer) echo.MiddlewareFunc {
+	labels := []string{"method", "route", "status"}
+	requests := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "impay_http_requests_total",
+		Help: "HTTP requests.",
+	}, labels)
+	latency := prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "impay_http_request_duration_seconds",
+		Help:    "HTTP request latency.",
+		Buckets: prometheus.DefBuckets,
+	}, labels)
+	reg.MustRegister(requests, latency)
+
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			start := time.Now()
+			err := next(c)
+
+			route := c.Path()
+			if route == "" {
+				route = "unmatched"
+			}
+			status := strconv.Itoa(c.Response().Status)
+			requests.WithLabelValues(c.Request().Method, route, status).Inc()
+			latency.WithLabelValues(c.Request().Method, route, status).Observe(time.Since(start).Seconds())
+			return err
+		}
+	}
+}
+
+// RegisterMetrics exposes the number of wallets per status and currency. Counting
+// reads every wallet, so the counts are cached for domain.WalletMetricsTTL.
+func (s *WalletAction) RegisterMetrics(reg prometheus.Registerer) {
+	var (
+		mu        sync.Mutex
+		samples   []metrics.Sample
+		countedAt time.Time
+	)
+	reg.MustRegister(metrics.GaugeFunc("impay_wallets", "Wallets by status and currency.",
+		[]string{"status", "currency"}, func() []metrics.Sample {
+			mu.Lock()
+			defer mu.Unlock()
+			if time.Since(countedAt) < domain.WalletMetricsTTL {
+				return samples
+			}
+			counted, err := s.countWallets()
+			if err != nil {
+				s.logger.Error("cannot count wallets", zap.Error(err))
+				return samples
+			}
+			samples, countedAt = counted, time.Now()
+			return samples
+		}))
+}
+
+func (s *WalletAction) countWallets() ([]metrics.Sample, error) {
+	counts := make(map[[2]string]int)
+	err := s.repo.View(func(tx domain.WalletTx) error {
+		wallets, err := tx.GetAll()
+		if err != nil {
+			return err
+		}
+		for _, w := range wallets {
+			counts[[2]string{w.Status, w.Currency}]++
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	samples := make([]metrics.Sample, 0, len(counts))
+	for key, n := range counts {
+		samples = append(samples, metrics.Sample{Labels: []string{key[0], key[1]}, Value: float64(n)})
+	}
+	return samples, nil
+}
+
+// RegisterMetrics exposes the stats totals.
+func (s *StatsAction) RegisterMetrics(reg prometheus.Registerer) {
+	reg.MustRegister(metrics.GaugeFunc("impay_stats_wallets", "Wallets counted by the stats by status.",
+		[]string{"status"}, func() []metrics.Sample {
+			s.RLock()
+			defer s.RUnlock()
+			return []metrics.Sample{
+				{Labels: []string{domain.StatusActive}, Value: s.Active.InexactFloat64()},
+				{Labels: []string{domain.StatusInactive}, Value: s.Inactive.InexactFloat64()},
+			}
+		}))
+	reg.MustRegister(metrics.GaugeFunc("impay_stats_amount", "Amounts of operations by operation and currency.",
+		[]string{"operation", "currency"}, func() []metrics.Sample {
+			s.RLock()
+			defer s.RUnlock()
+			samples := make([]metrics.Sample, 0, len(s.Currencies)*len(domain.Operations))
+			for currency, cs := range s.Currencies {
+				samples = append(samples,
+					metrics.Sample{Labels: []string{domain.OperationDeposited, currency}, Value: cs.Deposited.InexactFloat64()},
+					metrics.Sample{Labels: []string{domain.OperationWithdrawn, currency}, Value: cs.Withdrawn.InexactFloat64()},
+					metrics.Sample{Labels: []string{domain.OperationTransferred, currency}, Value: cs.Transferred.InexactFloat64()},
+				)
+			}
+			return samples
+		}))
+}
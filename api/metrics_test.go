@@ -0,0 +1,81 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/Kale-Grabovski/impay/api/mock"
+	"github.com/Kale-Grabovski/impay/domain"
+	"github.com/Kale-Grabovski/impay/storage"
+)
+
+func TestMetrics(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	e := echo.New()
+	e.Use(MetricsMiddleware(reg), ErrorMiddleware())
+	e.GET("/wallets/:id", func(c echo.Context) error {
+		return c.NoContent(http.StatusOK)
+	})
+	e.GET("/fail", func(c echo.Context) error {
+		return errors.New("fail")
+	})
+	e.GET("/metrics", echo.WrapHandler(promhttp.HandlerFor(reg, promhttp.HandlerOpts{})))
+
+	repo := storage.NewMemoryRepository()
+	assert.NoError(t, repo.Update(func(tx domain.WalletTx) error {
+		for _, w := range []*domain.Wallet{
+			domain.NewWallet("a", "a", "USD"),
+			domain.NewWallet("b", "b", "USD"),
+			{ID: "c", Currency: "EUR", Status: domain.StatusInactive},
+		} {
+			if err := tx.Put(w); err != nil {
+				return err
+			}
+		}
+		return nil
+	}))
+	walletAction := NewWalletAction(&domain.Config{}, repo, &mock.RateProviderMock{}, &mock.ProducerMock{}, &mock.LoggerMock{})
+	walletAction.RegisterMetrics(reg)
+
+	statsAction := NewStatsAction(&domain.Config{}, &mock.ConsumerMock{}, &mock.LoggerMock{})
+	statsAction.handlers()[domain.TopicWalletCreated](&domain.WalletEvent{})
+	statsAction.handlers()[domain.TopicWalletDeposited](&domain.WalletEvent{Currency: "USD", Amount: decimal.RequireFromString("5.5")})
+	statsAction.RegisterMetrics(reg)
+
+	for _, path := range []string{"/wallets/a", "/wallets/b", "/fail", "/missing"} {
+		e.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+
+	scrape := func() string {
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+		return rec.Body.String()
+	}
+	body := scrape()
+	for _, line := range []string{
+		`impay_http_requests_total{method="GET",route="/wallets/:id",status="200"} 2`,
+		`impay_http_requests_total{method="GET",route="/fail",status="500"} 1`,
+		`impay_http_requests_total{method="GET",route="unmatched",status="404"} 1`,
+		`impay_http_request_duration_seconds_count{method="GET",route="/wallets/:id",status="200"} 2`,
+		`impay_wallets{currency="USD",status="active"} 2`,
+		`impay_wallets{currency="EUR",status="inactive"} 1`,
+		`impay_stats_wallets{status="active"} 1`,
+		`impay_stats_amount{currency="USD",operation="deposited"} 5.5`,
+	} {
+		assert.Contains(t, body, line+"\n")
+	}
+
+	// Wallet counts are cached between scrapes
+	assert.NoError(t, repo.Update(func(tx domain.WalletTx) error {
+		return tx.Put(domain.NewWallet("d", "d", "USD"))
+	}))
+	assert.Contains(t, scrape(), `impay_wallets{currency="USD",status="active"} 2`+"\n")
+}
@@ -6,14 +6,40 @@ import (
 
 	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
 	"github.com/stretchr/testify/mock"
+
+	"github.com/Kale-Grabovski/impay/domain"
 )
 
 type ConsumerMock struct {
 	mock.Mock
 }
 
-func (p *ConsumerMock) Subscribe(ctx context.Context, topic string, ch chan []byte) error {
-	return p.Called(ctx, topic, ch).Error(0)
+func (p *ConsumerMock) Handle(topic string, handler func([]byte) error) {
+	p.Called(topic, handler)
+}
+
+func (p *ConsumerMock) Start(ctx context.Context) error {
+	return p.Called(ctx).Error(0)
+}
+
+func (p *ConsumerMock) Wait() {
+	p.Called()
+}
+
+func (p *ConsumerMock) Resume(offsets domain.Offsets) {
+	p.Called(offsets)
+}
+
+func (p *ConsumerMock) Checkpoint(fn func(offsets domain.Offsets) error) error {
+	return p.Called(fn).Error(0)
+}
+
+type DeadLetterProducerMock struct {
+	mock.Mock
+}
+
+func (p *DeadLetterProducerMock) SendRaw(topic string, key, value []byte, headers []kafka.Header) error {
+	return p.Called(topic, key, value, headers).Error(0)
 }
 
 type BaseConsumerMock struct {
@@ -27,3 +53,46 @@ func (p *BaseConsumerMock) ReadMessage(timeout time.Duration) (*kafka.Message, e
 	args := p.Called(timeout)
 	return args.Get(0).(*kafka.Message), args.Error(1)
 }
+
+func (p *BaseConsumerMock) CommitMessage(m *kafka.Message) ([]kafka.TopicPartition, error) {
+	args := p.Called(m)
+	return nil, args.Error(1)
+}
+
+func (p *BaseConsumerMock) StoreMessage(m *kafka.Message) ([]kafka.TopicPartition, error) {
+	args := p.Called(m)
+	return nil, args.Error(1)
+}
+
+func (p *BaseConsumerMock) Commit() ([]kafka.TopicPartition, error) {
+	args := p.Called()
+	return nil, args.Error(1)
+}
+
+func (p *BaseConsumerMock) Assign(partitions []kafka.TopicPartition) error {
+	return p.Called(partitions).Error(0)
+}
+
+func (p *BaseConsumerMock) Assignment() ([]kafka.TopicPartition, error) {
+	args := p.Called()
+	return args.Get(0).([]kafka.TopicPartition), args.Error(1)
+}
+
+func (p *BaseConsumerMock) Position(partitions []kafka.TopicPartition) ([]kafka.TopicPartition, error) {
+	args := p.Called(partitions)
+	return args.Get(0).([]kafka.TopicPartition), args.Error(1)
+}
+
+func (p *BaseConsumerMock) GetWatermarkOffsets(topic string, partition int32) (int64, int64, error) {
+	args := p.Called(topic, partition)
+	return args.Get(0).(int64), args.Get(1).(int64), args.Error(2)
+}
+
+func (p *BaseConsumerMock) GetMetadata(topic *string, allTopics bool, timeoutMs int) (*kafka.Metadata, error) {
+	args := p.Called(topic, allTopics, timeoutMs)
+	return args.Get(0).(*kafka.Metadata), args.Error(1)
+}
+
+func (p *BaseConsumerMock) Close() error {
+	return p.Called().Error(0)
+}
@@ -0,0 +1,21 @@
+package mock
+
+import (
+	"github.com/stretchr/testify/mock"
+
+	"github.com/Kale-Grabovski/impay/domain"
+)
+
+type EventSourceMock struct {
+	mock.Mock
+}
+
+func (p *EventSourceMock) Replay(offsets domain.Offsets, fn func(topic string, payload []byte) error) error {
+	return p.Called(offsets, fn).Error(0)
+}
+
+func (p *EventSourceMock) Offsets() (domain.Offsets, error) {
+	args := p.Called()
+	offsets, _ := args.Get(0).(domain.Offsets)
+	return offsets, args.Error(1)
+}
@@ -1,11 +1,22 @@
 package mock
 
-import "github.com/stretchr/testify/mock"
+import (
+	"context"
+
+	"github.com/stretchr/testify/mock"
+)
 
 type ProducerMock struct {
 	mock.Mock
 }
 
-func (p *ProducerMock) Send(topic string, partition int32, msg any) error {
-	return p.Called(topic, partition, msg).Error(0)
+func (p *ProducerMock) Send(_ context.Context, topic string, key string, msg any) error {
+	return p.Called(topic, key, msg).Error(0)
+}
+
+// Produce reports the delivery result set for the message in background.
+func (p *ProducerMock) Produce(_ context.Context, topic string, key string, msg any, done func(error)) error {
+	err := p.Called(topic, key, msg).Error(0)
+	go done(err)
+	return nil
 }
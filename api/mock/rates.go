@@ -0,0 +1,15 @@
+package mock
+
+import (
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/mock"
+)
+
+type RateProviderMock struct {
+	mock.Mock
+}
+
+func (p *RateProviderMock) Rate(from, to string) (decimal.Decimal, error) {
+	args := p.Called(from, to)
+	return args.Get(0).(decimal.Decimal), args.Error(1)
+}
@@ -0,0 +1,255 @@
+package api
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	"go.uber.org/zap"
+
+	"github.com/Kale-Grabovski/impay/domain"
+)
+
+const (
+	eventIDBytes    = 16
+	relayInterval   = time.Second
+	relayMaxBackoff = time.Minute
+	relayBatch      = 100
+)
+
+// outboxAdd completes the event envelope and stores it in the same transaction
+// as the state change, the relay publishes it to e.Type topic after the commit.
+// Events are keyed by the wallet ID to keep them ordered per wallet. With
+// a state topic configured the event is stored for it as well, followed by
+// the state of the transfer target. The trace context of ctx is stored with the
+// events so their delivery belongs to the same trace, and so is the request ID.
+func (s *WalletAction) outboxAdd(ctx context.Context, tx domain.WalletTx, e domain.WalletEvent) (err error) {
+	e.Version = domain.EventSchemaVersion
+	e.EventID, err = randomID(eventIDBytes)
+	if err != nil {
+		return err
+	}
+	e.OccurredAt = time.Now().UTC()
+	trace := propagation.MapCarrier{}
+	otel.GetTextMapPropagator().Inject(ctx, trace)
+	requestID := domain.RequestID(ctx)
+	if err = outboxPut(tx, e.Type, e, trace, requestID); err != nil || s.stateTopic == "" {
+		return err
+	}
+
+	if err = outboxPut(tx, s.stateTopic, e, trace, requestID); err != nil || e.CounterpartyID == "" {
+		return err
+	}
+	target, err := walletEvent(tx, e.Type, e.CounterpartyID)
+	if err != nil {
+		return err
+	}
+	target.Version = e.Version
+	target.EventID, err = randomID(eventIDBytes)
+	if err != nil {
+		return err
+	}
+	target.CounterpartyID = e.WalletID
+	target.TransactionID = e.TransactionID
+	target.OccurredAt = e.OccurredAt
+	return outboxPut(tx, s.stateTopic, target, trace, requestID)
+}
+
+func outboxPut(tx domain.WalletTx, topic string, e domain.WalletEvent, trace propagation.MapCarrier, requestID string) error {
+	payload, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("cannot marshal event: %w", err)
+	}
+	return tx.AddOutbox(&domain.OutboxEvent{
+		Topic:        topic,
+		Key:          e.WalletID,
+		Payload:      payload,
+		CreatedAt:    e.OccurredAt,
+		TraceContext: trace,
+		RequestID:    requestID,
+	})
+}
+
+// walletEvent returns the event of the operation on the wallet with its resulting state.
+func walletEvent(tx domain.WalletTx, topic, walletID string) (domain.WalletEvent, error) {
+	wallet, err := tx.Get(walletID)
+	if err != nil {
+		return domain.WalletEvent{}, err
+	}
+	return domain.WalletEvent{
+		Type:     topic,
+		WalletID: wallet.ID,
+		Currency: wallet.Currency,
+		Name:     wallet.Name,
+		Status:   wallet.Status,
+		Owner:    wallet.Owner,
+		Balance:  &wallet.Balance,
+	}, nil
+}
+
+// publish hands the events stored by a handler over to the relay. In sync mode
+// they are delivered before the handler returns, a failed delivery is left to
+// the background relay to retry.
+func (s *WalletAction) publish() {
+	if !s.syncPublish {
+		s.notifyRelay()
+		return
+	}
+	_ = s.relayOutbox()
+}
+
+// notifyRelay wakes up the relay without waiting for the next tick.
+func (s *WalletAction) notifyRelay() {
+	select {
+	case s.relayWake <- struct{}{}:
+	default:
+	}
+}
+
+// StartRelay publishes pending outbox events in background. Failed deliveries
+// are retried with exponential backoff, events are kept in order.
+func (s *WalletAction) StartRelay() {
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		var backoff time.Duration
+		for {
+			wake := s.relayWake
+			delay := relayInterval
+			if err := s.relayOutbox(); err != nil {
+				backoff = min(max(2*backoff, relayInterval), relayMaxBackoff)
+				delay, wake = backoff, nil
+			} else {
+				backoff = 0
+			}
+
+			timer := time.NewTimer(delay)
+			select {
+			case <-timer.C:
+			case <-wake:
+				timer.Stop()
+			case <-s.ctx.Done():
+				timer.Stop()
+				return
+			}
+		}
+	}()
+}
+
+// relayOutbox publishes pending events oldest first. An event is removed
+// from the outbox only after the producer confirms its delivery. A failure
+// stops the relay until the next pass, the failed event is retried then.
+func (s *WalletAction) relayOutbox() error {
+	s.relayMu.Lock()
+	defer s.relayMu.Unlock()
+
+	for {
+		var events []*domain.OutboxEvent
+		err := s.repo.View(func(tx domain.WalletTx) (err error) {
+			events, err = tx.PendingOutbox(relayBatch)
+			return err
+		})
+		if err != nil {
+			s.logger.Error("cannot read outbox", zap.Error(err))
+			return err
+		}
+
+		if s.syncPublish {
+			err = s.relaySync(events)
+		} else {
+			err = s.relayAsync(events)
+		}
+		if err != nil || len(events) < relayBatch {
+			return err
+		}
+	}
+}
+
+// relaySync sends the events one by one, the first failure stops the pass so
+// later events do not overtake it.
+func (s *WalletAction) relaySync(events []*domain.OutboxEvent) error {
+	for _, e := range events {
+		if err := s.relayed(e, s.producer.Send(relayContext(e), e.Topic, e.Key, e.Payload)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// relayAsync queues the whole batch at once and updates the outbox once the
+// delivery reports of the batch arrive. An event is kept in the outbox if an
+// earlier event with the same key failed, it is sent again after that one, so
+// the events of a wallet are not delivered for good ahead of an earlier one.
+func (s *WalletAction) relayAsync(events []*domain.OutboxEvent) (err error) {
+	// Every queued event gets its delivery report, the one failed to queue gets the error
+	reports := make([]chan error, 0, len(events))
+	for _, e := range events {
+		report := make(chan error, 1)
+		reports = append(reports, report)
+		if err = s.producer.Produce(relayContext(e), e.Topic, e.Key, e.Payload, func(err error) {
+			report <- err
+		}); err != nil {
+			report <- err
+			break
+		}
+	}
+
+	err = nil
+	failed := make(map[string]bool)
+	for i, report := range reports {
+		e, sendErr := events[i], <-report
+		if sendErr == nil && failed[e.Key] {
+			continue
+		}
+		if sendErr != nil {
+			failed[e.Key] = true
+		}
+		if relayErr := s.relayed(e, sendErr); err == nil {
+			err = relayErr
+		}
+	}
+	return err
+}
+
+// relayed removes the delivered event from the outbox or records the failed
+// delivery attempt. It returns the delivery or the outbox error.
+func (s *WalletAction) relayed(e *domain.OutboxEvent, sendErr error) error {
+	logger := domain.LoggerWithRequestID(s.logger, e.RequestID)
+	err := s.repo.Update(func(tx domain.WalletTx) error {
+		if sendErr == nil {
+			return tx.DeleteOutbox(e.ID)
+		}
+		e.Attempts++
+		e.LastError = sendErr.Error()
+		return tx.PutOutbox(e)
+	})
+	if sendErr != nil {
+		logger.Error("cannot publish event to topic "+e.Topic, zap.Error(sendErr))
+		return sendErr
+	}
+	if err != nil {
+		logger.Error("cannot update outbox", zap.Error(err))
+	}
+	return err
+}
+
+// relayContext returns the context the event was stored with, carrying its
+// trace context and request ID.
+func relayContext(e *domain.OutboxEvent) context.Context {
+	ctx := otel.GetTextMapPropagator().Extract(context.Background(), propagation.MapCarrier(e.TraceContext))
+	return domain.WithRequestID(ctx, e.RequestID)
+}
+
+// randomID returns a hex encoded random identifier of n bytes.
+func randomID(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("cannot generate ID: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
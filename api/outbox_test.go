@@ -0,0 +1,219 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	mockery "github.com/stretchr/testify/mock"
+	"go.uber.org/zap"
+
+	"github.com/Kale-Grabovski/impay/api/mock"
+	"github.com/Kale-Grabovski/impay/domain"
+	"github.com/Kale-Grabovski/impay/storage"
+)
+
+// eventMatching matches the event payload passed to the producer. Generated
+// envelope fields must be set, other fields are compared when set in expected.
+func eventMatching(expected domain.WalletEvent) any {
+	return mockery.MatchedBy(func(payload json.RawMessage) bool {
+		e, err := domain.ParseWalletEvent("", payload)
+		if err != nil || e.Version != domain.EventSchemaVersion || e.EventID == "" || e.OccurredAt.IsZero() {
+			return false
+		}
+		return (expected.WalletID == "" || expected.WalletID == e.WalletID) &&
+			expected.CounterpartyID == e.CounterpartyID &&
+			expected.Amount.Equal(e.Amount) &&
+			(expected.Currency == "" || expected.Currency == e.Currency) &&
+			(expected.Name == "" || expected.Name == e.Name) &&
+			(expected.Status == "" || expected.Status == e.Status) &&
+			(expected.Balance == nil || e.Balance != nil && expected.Balance.Equal(*e.Balance)) &&
+			(expected.CounterpartyAmount == nil ||
+				e.CounterpartyAmount != nil && expected.CounterpartyAmount.Equal(*e.CounterpartyAmount)) &&
+			(expected.CounterpartyBalance == nil ||
+				e.CounterpartyBalance != nil && expected.CounterpartyBalance.Equal(*e.CounterpartyBalance))
+	})
+}
+
+func decimalPtr(d decimal.Decimal) *decimal.Decimal {
+	return &d
+}
+
+// relayNotified runs the relay pass requested by a handler, as the background relay does.
+func relayNotified(s *WalletAction) {
+	select {
+	case <-s.relayWake:
+		_ = s.relayOutbox()
+	default:
+	}
+}
+
+func TestRelayOutbox(t *testing.T) {
+	repo := storage.NewMemoryRepository()
+	producerMock := &mock.ProducerMock{}
+	loggerMock := &mock.LoggerMock{}
+	walletAction := NewWalletAction(&domain.Config{}, repo, &mock.RateProviderMock{}, producerMock, loggerMock)
+
+	first := domain.WalletEvent{Type: domain.TopicWalletDeposited, WalletID: "a", Amount: decimal.NewFromInt(1)}
+	second := domain.WalletEvent{Type: domain.TopicWalletWithdrawn, WalletID: "a", Amount: decimal.NewFromInt(2)}
+	other := domain.WalletEvent{Type: domain.TopicWalletDeposited, WalletID: "b", Amount: decimal.NewFromInt(3)}
+	third := domain.WalletEvent{Type: domain.TopicWalletDeposited, WalletID: "a", Amount: decimal.NewFromInt(4)}
+	assert.NoError(t, repo.Update(func(tx domain.WalletTx) error {
+		for _, e := range []domain.WalletEvent{first, second, other, third} {
+			if err := walletAction.outboxAdd(context.Background(), tx, e); err != nil {
+				return err
+			}
+		}
+		return nil
+	}))
+
+	pending := func() (events []*domain.OutboxEvent) {
+		assert.NoError(t, repo.View(func(tx domain.WalletTx) (err error) {
+			events, err = tx.PendingOutbox(0)
+			return err
+		}))
+		return events
+	}
+
+	// The whole batch is produced at once. The failed event stays in the outbox and so
+	// does the later event of the same wallet, it must not be delivered ahead of it.
+	publishErr := errors.New("publish failed")
+	producerMock.On("Produce", domain.TopicWalletDeposited, "a", eventMatching(first)).Once().Return(nil)
+	producerMock.On("Produce", domain.TopicWalletWithdrawn, "a", eventMatching(second)).Once().Return(publishErr)
+	producerMock.On("Produce", domain.TopicWalletDeposited, "b", eventMatching(other)).Once().Return(nil)
+	producerMock.On("Produce", domain.TopicWalletDeposited, "a", eventMatching(third)).Once().Return(nil)
+	loggerMock.
+		On("Error", "cannot publish event to topic "+domain.TopicWalletWithdrawn, []zap.Field{zap.Error(publishErr)}).
+		Once().
+		Return(nil)
+	assert.ErrorIs(t, walletAction.relayOutbox(), publishErr)
+	mockery.AssertExpectationsForObjects(t, loggerMock, producerMock)
+
+	events := pending()
+	if assert.Len(t, events, 2) {
+		assert.Equal(t, domain.TopicWalletWithdrawn, events[0].Topic)
+		assert.Equal(t, 1, events[0].Attempts)
+		assert.Equal(t, publishErr.Error(), events[0].LastError)
+		assert.Equal(t, domain.TopicWalletDeposited, events[1].Topic)
+		assert.Equal(t, "a", events[1].Key)
+		assert.Zero(t, events[1].Attempts)
+	}
+
+	producerMock.On("Produce", domain.TopicWalletWithdrawn, "a", eventMatching(second)).Once().Return(nil)
+	producerMock.On("Produce", domain.TopicWalletDeposited, "a", eventMatching(third)).Once().Return(nil)
+	assert.NoError(t, walletAction.relayOutbox())
+	mockery.AssertExpectationsForObjects(t, loggerMock, producerMock)
+	assert.Empty(t, pending())
+}
+
+func TestSyncPublish(t *testing.T) {
+	e := echo.New()
+	repo := storage.NewMemoryRepository()
+	producerMock := &mock.ProducerMock{}
+	loggerMock := &mock.LoggerMock{}
+	cfg := &domain.Config{}
+	cfg.Kafka.SyncPublish = true
+	walletAction := NewWalletAction(cfg, repo, &mock.RateProviderMock{}, producerMock, loggerMock)
+
+	assert.NoError(t, repo.Update(func(tx domain.WalletTx) error {
+		return tx.Put(domain.NewWallet("a", "", "USD"))
+	}))
+
+	producerMock.
+		On(
+			"Send",
+			domain.TopicWalletDeposited,
+			"a",
+			eventMatching(domain.WalletEvent{WalletID: "a", Amount: decimal.NewFromInt(5), Currency: "USD"}),
+		).
+		Once().
+		Return(nil)
+
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"amount": 5}`))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+	c.SetPath("/wallets/:id/deposit")
+	c.SetParamNames("id")
+	c.SetParamValues("a")
+
+	// The event is delivered by the handler itself, the relay is not woken up
+	if assert.NoError(t, walletAction.Deposit(c)) {
+		assert.Equal(t, http.StatusOK, rec.Code)
+	}
+	mockery.AssertExpectationsForObjects(t, loggerMock, producerMock)
+	assert.Empty(t, walletAction.relayWake)
+	assert.NoError(t, repo.View(func(tx domain.WalletTx) error {
+		events, err := tx.PendingOutbox(0)
+		assert.NoError(t, err)
+		assert.Empty(t, events)
+		return nil
+	}))
+}
+
+func TestOutboxStateTopic(t *testing.T) {
+	cfg := &domain.Config{}
+	cfg.EventSourcing.Enabled = true
+	cfg.EventSourcing.Topic = "state"
+
+	// The transfer goes to its topic and the state of both wallets to the state topic
+	events := outboxTransfer(t, cfg)
+	if assert.Len(t, events, 3) {
+		assert.Equal(t, []string{domain.TopicWalletTransferred, "state", "state"},
+			[]string{events[0].Topic, events[1].Topic, events[2].Topic})
+		assert.Equal(t, []string{"a", "a", "b"}, []string{events[0].Key, events[1].Key, events[2].Key})
+
+		e, err := domain.ParseWalletEvent("state", events[2].Payload)
+		if assert.NoError(t, err) {
+			assert.Equal(t, domain.TopicWalletTransferred, e.Type)
+			assert.Equal(t, "a", e.CounterpartyID)
+		}
+	}
+}
+
+func TestOutboxStateTopicDisabled(t *testing.T) {
+	cfg := &domain.Config{}
+	cfg.EventSourcing.Topic = "state"
+
+	// Without event sourcing only the transfer itself is published
+	events := outboxTransfer(t, cfg)
+	if assert.Len(t, events, 1) {
+		assert.Equal(t, domain.TopicWalletTransferred, events[0].Topic)
+		assert.Equal(t, "a", events[0].Key)
+	}
+}
+
+// outboxTransfer adds a transfer event from wallet a to wallet b to the outbox
+// and returns the pending events.
+func outboxTransfer(t *testing.T, cfg *domain.Config) []*domain.OutboxEvent {
+	repo := storage.NewMemoryRepository()
+	walletAction := NewWalletAction(cfg, repo, &mock.RateProviderMock{}, &mock.ProducerMock{}, &mock.LoggerMock{})
+
+	assert.NoError(t, repo.Update(func(tx domain.WalletTx) error {
+		for _, w := range []*domain.Wallet{domain.NewWallet("a", "", "USD"), domain.NewWallet("b", "", "USD")} {
+			if err := tx.Put(w); err != nil {
+				return err
+			}
+		}
+		e, err := walletEvent(tx, domain.TopicWalletTransferred, "a")
+		if err != nil {
+			return err
+		}
+		e.CounterpartyID = "b"
+		return walletAction.outboxAdd(context.Background(), tx, e)
+	}))
+
+	var events []*domain.OutboxEvent
+	assert.NoError(t, repo.View(func(tx domain.WalletTx) (err error) {
+		events, err = tx.PendingOutbox(0)
+		return err
+	}))
+	return events
+}
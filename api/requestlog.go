@@ -0,0 +1,117 @@
+package api
+
+import (
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+
+	"github.com/Kale-Grabovski/impay/domain"
+)
+
+const (
+	requestIDBytes    = 16
+	requestIDMaxLen   = 128
+	walletIDKey       = "wallet_id"
+	walletRoutePrefix = "/wallets/:id"
+)
+
+// RequestIDMiddleware passes on the X-Request-ID of the caller or assigns a new
+// one, the ID is returned in the response and handlers get it from the request context.
+func RequestIDMiddleware() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			req := c.Request()
+			id := req.Header.Get(domain.HeaderRequestID)
+			if !validRequestID(id) {
+				var err error
+				if id, err = randomID(requestIDBytes); err != nil {
+					return err
+				}
+			}
+			c.Response().Header().Set(domain.HeaderRequestID, id)
+			c.SetRequest(req.WithContext(domain.WithRequestID(req.Context(), id)))
+			return next(c)
+		}
+	}
+}
+
+// AccessLogMiddleware logs every request with its route, status, latency and
+// the wallet it is about.
+func AccessLogMiddleware(logger domain.Logger) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			start := time.Now()
+			err := next(c)
+
+			route := c.Path()
+			if route == "" {
+				route = "unmatched"
+			}
+			fields := []zap.Field{
+				zap.String("method", c.Request().Method),
+				zap.String("route", route),
+				zap.Int("status", c.Response().Status),
+				zap.Duration("latency", time.Since(start)),
+			}
+			if id := requestWalletID(c); id != "" {
+				fields = append(fields, zap.String("wallet_id", id))
+			}
+			domain.RequestLogger(c.Request().Context(), logger).Info("request", fields...)
+			return err
+		}
+	}
+}
+
+// ErrorMiddleware writes the response of an error returned by the handler with
+// the echo error handler. It goes after the middlewares reading the response
+// status, like the access log, metrics and tracing ones, so they see the status
+// of the error response. The error is still returned for them, echo does not
+// write a response twice.
+func ErrorMiddleware() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			err := next(c)
+			if err != nil {
+				c.Error(err)
+			}
+			return err
+		}
+	}
+}
+
+// requestLogger returns the logger adding the request ID to every line.
+func requestLogger(c echo.Context, logger domain.Logger) domain.Logger {
+	return domain.RequestLogger(c.Request().Context(), logger)
+}
+
+// setWalletID records the wallet of a request which route does not name it.
+func setWalletID(c echo.Context, id string) {
+	c.Set(walletIDKey, id)
+}
+
+// requestWalletID returns the wallet the request is about, if known.
+func requestWalletID(c echo.Context) string {
+	if id, ok := c.Get(walletIDKey).(string); ok {
+		return id
+	}
+	if strings.Contains(c.Path(), walletRoutePrefix) {
+		return c.Param("id")
+	}
+	return ""
+}
+
+// validRequestID accepts IDs of printable ASCII up to requestIDMaxLen, others are
+// replaced not to let callers break the log lines.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > requestIDMaxLen {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] <= ' ' || id[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,103 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	mockery "github.com/stretchr/testify/mock"
+	"go.uber.org/zap"
+
+	"github.com/Kale-Grabovski/impay/api/mock"
+	"github.com/Kale-Grabovski/impay/domain"
+	"github.com/Kale-Grabovski/impay/storage"
+)
+
+// accessLog matches the access log fields of a request, latency aside.
+func accessLog(method, route string, status int, walletID, requestID string) any {
+	return mockery.MatchedBy(func(fields []zap.Field) bool {
+		logged := make(map[string]zap.Field, len(fields))
+		for _, f := range fields {
+			logged[f.Key] = f
+		}
+		_, latency := logged["latency"]
+		return latency && len(fields) == 6 &&
+			logged["method"].Equals(zap.String("method", method)) &&
+			logged["route"].Equals(zap.String("route", route)) &&
+			logged["status"].Equals(zap.Int("status", status)) &&
+			logged["wallet_id"].Equals(zap.String("wallet_id", walletID)) &&
+			logged["request_id"].Equals(zap.String("request_id", requestID))
+	})
+}
+
+func TestRequestLog(t *testing.T) {
+	repo := storage.NewMemoryRepository()
+	producerMock := &mock.ProducerMock{}
+	loggerMock := &mock.LoggerMock{}
+	walletAction := NewWalletAction(&domain.Config{}, repo, &mock.RateProviderMock{}, producerMock, loggerMock)
+	assert.NoError(t, repo.Update(func(tx domain.WalletTx) error {
+		return tx.Put(domain.NewWallet("a", "", "USD"))
+	}))
+
+	e := echo.New()
+	e.Use(RequestIDMiddleware(), AccessLogMiddleware(loggerMock), ErrorMiddleware())
+	e.POST("/wallets/:id/deposit", walletAction.Deposit)
+
+	// The ID of the caller is kept and logged with the request
+	loggerMock.
+		On("Info", "request", accessLog(http.MethodPost, "/wallets/:id/deposit", http.StatusOK, "a", "req-1")).
+		Once().
+		Return(nil)
+	req := httptest.NewRequest(http.MethodPost, "/wallets/a/deposit", strings.NewReader(`{"amount": 5}`))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	req.Header.Set(domain.HeaderRequestID, "req-1")
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "req-1", rec.Header().Get(domain.HeaderRequestID))
+	mockery.AssertExpectationsForObjects(t, loggerMock)
+
+	// The relay logs the failed delivery with the ID of the request the event comes from
+	publishErr := errors.New("publish failed")
+	producerMock.On("Produce", domain.TopicWalletDeposited, "a", mockery.Anything).Once().Return(publishErr)
+	loggerMock.
+		On("Error", "cannot publish event to topic "+domain.TopicWalletDeposited,
+			[]zap.Field{zap.Error(publishErr), zap.String("request_id", "req-1")}).
+		Once().
+		Return(nil)
+	assert.ErrorIs(t, walletAction.relayOutbox(), publishErr)
+	mockery.AssertExpectationsForObjects(t, loggerMock, producerMock)
+
+	// An invalid ID is replaced with a new one, the status of the error response is logged
+	var (
+		requestID string
+		status    int64
+	)
+	loggerMock.
+		On("Info", "request", mockery.MatchedBy(func(fields []zap.Field) bool {
+			for _, f := range fields {
+				switch f.Key {
+				case "request_id":
+					requestID = f.String
+				case "status":
+					status = f.Integer
+				}
+			}
+			return true
+		})).
+		Once().
+		Return(nil)
+	req = httptest.NewRequest(http.MethodGet, "/unknown", nil)
+	req.Header.Set(domain.HeaderRequestID, "bad id\n")
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+	assert.Equal(t, int64(http.StatusNotFound), status)
+	assert.Len(t, requestID, 2*requestIDBytes)
+	assert.Equal(t, requestID, rec.Header().Get(domain.HeaderRequestID))
+	mockery.AssertExpectationsForObjects(t, loggerMock)
+}
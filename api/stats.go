@@ -2,26 +2,30 @@ package api
 
 import (
 	"context"
-	"encoding/json"
+	"fmt"
 	"net/http"
+	"sort"
 	"sync"
 	"time"
 
 	"github.com/labstack/echo/v4"
 	"github.com/shopspring/decimal"
+	"go.uber.org/zap"
 
 	"github.com/Kale-Grabovski/impay/domain"
 )
 
-const doneChanLen = 50
-
+// statsWalletResp keeps the amount totals for backward compatibility only while
+// all amounts are in one currency, they are not summed over currencies. Per
+// currency amounts are in Currencies.
 type statsWalletResp struct {
-	Deposited   decimal.Decimal `json:"deposited"`
-	Withdrawn   decimal.Decimal `json:"withdrawn"`
-	Transferred decimal.Decimal `json:"transferred"`
-	Total       decimal.Decimal `json:"total"`
-	Active      decimal.Decimal `json:"active"`
-	Inactive    decimal.Decimal `json:"inactive"`
+	Deposited   *decimal.Decimal                `json:"deposited,omitempty"`
+	Withdrawn   *decimal.Decimal                `json:"withdrawn,omitempty"`
+	Transferred *decimal.Decimal                `json:"transferred,omitempty"`
+	Total       decimal.Decimal                 `json:"total"`
+	Active      decimal.Decimal                 `json:"active"`
+	Inactive    decimal.Decimal                 `json:"inactive"`
+	Currencies  map[string]domain.CurrencyStats `json:"currencies"`
 }
 
 type StatsAction struct {
@@ -32,121 +36,431 @@ type StatsAction struct {
 	Total       decimal.Decimal
 	Active      decimal.Decimal
 	Inactive    decimal.Decimal
+	Currencies  map[string]*domain.CurrencyStats
+	// buckets are keyed by granularity and the unix time of the bucket start
+	buckets   map[string]map[int64]*domain.StatsBucket
+	retention map[string]time.Duration
+	// wallets is the bounded per wallet projection
+	wallets      *walletLRU
+	walletWindow time.Duration
+	// distributions are keyed by currency and operation
+	distributions   map[string]map[string]*domain.AmountDistribution
+	histogramBounds []decimal.Decimal
+	now             func() time.Time
+	// updated is closed and replaced whenever an event changes the stats
+	updated        chan struct{}
+	streamInterval time.Duration
+	streams        context.Context
+	closeStreams   context.CancelFunc
 
 	logger      domain.Logger
 	consumerSvc Consumer
 	ctx         context.Context
 	cancel      context.CancelFunc
-	chans       map[string]chan []byte
+	wg          sync.WaitGroup
 }
 
+// Consumer dispatches consumed messages to the handlers registered by topic.
 type Consumer interface {
-	Subscribe(ctx context.Context, topic string, ch chan []byte) error
+	Handle(topic string, handler func([]byte) error)
+	// Resume starts partitions from offsets instead of the consumer group offsets.
+	Resume(offsets domain.Offsets)
+	// Checkpoint calls fn with the offsets following the processed messages.
+	Checkpoint(fn func(offsets domain.Offsets) error) error
+	Start(ctx context.Context) error
+	Wait()
 }
 
 func NewStatsAction(
+	cfg *domain.Config,
 	consumerSvc Consumer,
 	logger domain.Logger,
 ) *StatsAction {
+	retention := map[string]time.Duration{
+		domain.GranularityMinute: cfg.Stats.Retention.Minute,
+		domain.GranularityHour:   cfg.Stats.Retention.Hour,
+		domain.GranularityDay:    cfg.Stats.Retention.Day,
+	}
+	defaults := map[string]time.Duration{
+		domain.GranularityMinute: domain.DefaultMinuteRetention,
+		domain.GranularityHour:   domain.DefaultHourRetention,
+		domain.GranularityDay:    domain.DefaultDayRetention,
+	}
+	for granularity, ttl := range retention {
+		if ttl <= 0 {
+			retention[granularity] = defaults[granularity]
+		}
+	}
+
+	maxWallets := cfg.Stats.MaxWallets
+	if maxWallets <= 0 {
+		maxWallets = domain.DefaultStatsMaxWallets
+	}
+	walletWindow := cfg.Stats.WalletWindow
+	if walletWindow <= 0 {
+		walletWindow = domain.DefaultStatsWalletWindow
+	}
+
+	histogramBounds := cfg.Stats.HistogramBounds
+	if len(histogramBounds) == 0 {
+		histogramBounds = domain.DefaultHistogramBounds
+	}
+	bounds := make([]decimal.Decimal, 0, len(histogramBounds))
+	for _, b := range histogramBounds {
+		bounds = append(bounds, decimal.NewFromFloat(b))
+	}
+	sort.Slice(bounds, func(i, j int) bool {
+		return bounds[i].LessThan(bounds[j])
+	})
+
+	streamInterval := cfg.Stats.StreamInterval
+	if streamInterval <= 0 {
+		streamInterval = domain.DefaultStreamInterval
+	}
+
 	ctx, cancel := context.WithCancel(context.Background())
+	streams, closeStreams := context.WithCancel(context.Background())
 	return &StatsAction{
-		logger:      logger,
-		consumerSvc: consumerSvc,
-		ctx:         ctx,
-		cancel:      cancel,
-		chans: map[string]chan []byte{
-			domain.TopicWalletCreated:     make(chan []byte, doneChanLen),
-			domain.TopicWalletDeleted:     make(chan []byte, doneChanLen),
-			domain.TopicWalletTransferred: make(chan []byte, doneChanLen),
-			domain.TopicWalletDeposited:   make(chan []byte, doneChanLen),
-			domain.TopicWalletWithdrawn:   make(chan []byte, doneChanLen),
-		},
+		logger:          logger,
+		consumerSvc:     consumerSvc,
+		ctx:             ctx,
+		cancel:          cancel,
+		Currencies:      make(map[string]*domain.CurrencyStats),
+		buckets:         make(map[string]map[int64]*domain.StatsBucket),
+		retention:       retention,
+		wallets:         newWalletLRU(maxWallets),
+		walletWindow:    walletWindow,
+		distributions:   make(map[string]map[string]*domain.AmountDistribution),
+		histogramBounds: bounds,
+		now:             time.Now,
+		updated:         make(chan struct{}),
+		streamInterval:  streamInterval,
+		streams:         streams,
+		closeStreams:    closeStreams,
 	}
 }
 
+// Get returns the totals, or the stats bucketed by time if any of from, to
+// or granularity query params is passed.
 func (s *StatsAction) Get(c echo.Context) (err error) {
+	if c.QueryParam("from") != "" || c.QueryParam("to") != "" || c.QueryParam("granularity") != "" {
+		return s.getBuckets(c)
+	}
+
 	s.RLock()
 	defer s.RUnlock()
-	return c.JSON(http.StatusOK, statsWalletResp{
-		Total:       s.Total,
-		Active:      s.Active,
-		Inactive:    s.Inactive,
-		Deposited:   s.Deposited,
-		Withdrawn:   s.Withdrawn,
-		Transferred: s.Withdrawn,
-	})
+	return c.JSON(http.StatusOK, s.totals())
+}
+
+// totals returns the totals response. Must be called under lock.
+func (s *StatsAction) totals() statsWalletResp {
+	currencies := make(map[string]domain.CurrencyStats, len(s.Currencies))
+	for currency, cs := range s.Currencies {
+		currencies[currency] = *cs
+	}
+	resp := statsWalletResp{
+		Total:      s.Total,
+		Active:     s.Active,
+		Inactive:   s.Inactive,
+		Currencies: currencies,
+	}
+	if len(currencies) <= 1 {
+		deposited, withdrawn, transferred := s.Deposited, s.Withdrawn, s.Transferred
+		resp.Deposited, resp.Withdrawn, resp.Transferred = &deposited, &withdrawn, &transferred
+	}
+	return resp
 }
 
 func (s *StatsAction) CloseConsumers() {
 	s.cancel()
-	time.Sleep(domain.ConsumerTimeout + 10*time.Millisecond)
-	for _, ch := range s.chans {
-		close(ch)
-	}
+	s.consumerSvc.Wait()
+	s.wg.Wait()
 }
 
 func (s *StatsAction) InitConsumers() error {
-	subs := map[string]func([]byte){
-		domain.TopicWalletCreated: func(m []byte) {
+	for topic, callback := range s.handlers() {
+		s.handle(topic, callback)
+	}
+	return s.consumerSvc.Start(s.ctx)
+}
+
+// Restore loads the stats from the latest checkpoint and makes the consumer resume
+// from its offsets. Without a checkpoint all events are consumed from the beginning.
+// Must be called before InitConsumers.
+func (s *StatsAction) Restore(checkpoints domain.CheckpointStore) error {
+	checkpoint, err := checkpoints.Load()
+	if err != nil {
+		return err
+	}
+	offsets := make(domain.Offsets)
+	if checkpoint != nil {
+		s.Lock()
+		s.setStats(checkpoint.Stats)
+		s.Unlock()
+		offsets = checkpoint.Offsets
+	}
+	s.consumerSvc.Resume(offsets)
+	return nil
+}
+
+// StartCheckpoints saves the stats with the consumed offsets every interval
+// and once more after the consumer is closed.
+func (s *StatsAction) StartCheckpoints(checkpoints domain.CheckpointStore, interval time.Duration) {
+	if interval <= 0 {
+		interval = domain.DefaultCheckpointInterval
+	}
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+			case <-s.ctx.Done():
+				s.consumerSvc.Wait()
+				if err := s.checkpoint(checkpoints); err != nil {
+					s.logger.Error("cannot save stats checkpoint", zap.Error(err))
+				}
+				return
+			}
+			if err := s.checkpoint(checkpoints); err != nil {
+				s.logger.Error("cannot save stats checkpoint", zap.Error(err))
+			}
+		}
+	}()
+}
+
+func (s *StatsAction) checkpoint(checkpoints domain.CheckpointStore) error {
+	return s.consumerSvc.Checkpoint(func(offsets domain.Offsets) error {
+		s.RLock()
+		stats := s.stats()
+		s.RUnlock()
+		return checkpoints.Save(&domain.StatsCheckpoint{
+			Stats:     stats,
+			Offsets:   offsets,
+			CreatedAt: time.Now().UTC(),
+		})
+	})
+}
+
+// Rebuild recomputes the stats from the earliest offsets of the wallet topics and saves
+// them as the checkpoint to resume from. The stats service must not run meanwhile.
+// Events that cannot be parsed are skipped as they were moved to the dead letter topic.
+func (s *StatsAction) Rebuild(source EventSource, checkpoints domain.CheckpointStore) error {
+	s.Lock()
+	s.setStats(domain.WalletStats{})
+	s.Unlock()
+
+	handlers := s.handlers()
+	offsets := make(domain.Offsets)
+	events, skipped := 0, 0
+	err := source.Replay(offsets, func(topic string, payload []byte) error {
+		callback, ok := handlers[topic]
+		if !ok {
+			return nil
+		}
+		e, err := domain.ParseWalletEvent(topic, payload)
+		if err != nil {
+			skipped++
+			return nil
+		}
+		callback(e)
+		events++
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("cannot replay events: %w", err)
+	}
+
+	s.RLock()
+	stats := s.stats()
+	s.RUnlock()
+	err = checkpoints.Save(&domain.StatsCheckpoint{
+		Stats:     stats,
+		Offsets:   offsets,
+		CreatedAt: time.Now().UTC(),
+	})
+	if err != nil {
+		return err
+	}
+	s.logger.Info("stats rebuilt", zap.Int("events", events), zap.Int("skipped", skipped))
+	return nil
+}
+
+// stats returns a copy of the counters. Must be called under lock.
+func (s *StatsAction) stats() domain.WalletStats {
+	currencies := make(map[string]*domain.CurrencyStats, len(s.Currencies))
+	for currency, cs := range s.Currencies {
+		c := *cs
+		currencies[currency] = &c
+	}
+	wallets := make([]*domain.WalletActivity, 0, len(s.wallets.wallets))
+	for _, a := range s.wallets.all() {
+		wallets = append(wallets, copyWalletActivity(a))
+	}
+	buckets := make(map[string]map[int64]*domain.StatsBucket, len(s.buckets))
+	for granularity, bs := range s.buckets {
+		buckets[granularity] = make(map[int64]*domain.StatsBucket, len(bs))
+		for start, b := range bs {
+			c := *b
+			buckets[granularity][start] = &c
+		}
+	}
+	return domain.WalletStats{
+		Deposited:       s.Deposited,
+		Withdrawn:       s.Withdrawn,
+		Transferred:     s.Transferred,
+		Total:           s.Total,
+		Active:          s.Active,
+		Inactive:        s.Inactive,
+		Currencies:      currencies,
+		Buckets:         buckets,
+		Wallets:         wallets,
+		Distributions:   copyDistributions(s.distributions),
+		HistogramBounds: append([]decimal.Decimal(nil), s.histogramBounds...),
+	}
+}
+
+// setStats replaces the counters. Must be called under lock.
+func (s *StatsAction) setStats(stats domain.WalletStats) {
+	s.Deposited = stats.Deposited
+	s.Withdrawn = stats.Withdrawn
+	s.Transferred = stats.Transferred
+	s.Total = stats.Total
+	s.Active = stats.Active
+	s.Inactive = stats.Inactive
+	s.Currencies = make(map[string]*domain.CurrencyStats, len(stats.Currencies))
+	for currency, cs := range stats.Currencies {
+		c := *cs
+		s.Currencies[currency] = &c
+	}
+	s.buckets = make(map[string]map[int64]*domain.StatsBucket, len(stats.Buckets))
+	for granularity, bs := range stats.Buckets {
+		s.buckets[granularity] = make(map[int64]*domain.StatsBucket, len(bs))
+		for start, b := range bs {
+			c := *b
+			s.buckets[granularity][start] = &c
+		}
+	}
+	s.wallets = newWalletLRU(s.wallets.max)
+	for _, a := range stats.Wallets {
+		*s.wallets.touch(a.WalletID) = *copyWalletActivity(a)
+	}
+	s.setDistributions(stats)
+}
+
+// handlers returns the callbacks updating the stats by topic.
+func (s *StatsAction) handlers() map[string]func(*domain.WalletEvent) {
+	return map[string]func(*domain.WalletEvent){
+		domain.TopicWalletCreated: func(e *domain.WalletEvent) {
 			s.Lock()
 			s.Total = s.Total.Add(decimal.NewFromInt32(1))
 			s.Active = s.Active.Add(decimal.NewFromInt32(1))
+			s.record(e, func(b *domain.StatsBucket) {
+				b.Created++
+			})
+			s.recordWallet(e.WalletID, e.Currency, s.eventTime(e), nil)
+			s.notify()
 			s.Unlock()
 		},
-		domain.TopicWalletDeleted: func(m []byte) {
+		// Renames do not change stats, the topic is handled so the pattern subscription does not warn
+		domain.TopicWalletUpdated: func(e *domain.WalletEvent) {},
+		domain.TopicWalletDeleted: func(e *domain.WalletEvent) {
 			s.Lock()
-			s.Active = s.Active.Add(decimal.NewFromInt32(1))
-			s.Inactive = s.Inactive.Add(decimal.NewFromInt32(-1))
+			s.Active = s.Active.Add(decimal.NewFromInt32(-1))
+			s.Inactive = s.Inactive.Add(decimal.NewFromInt32(1))
+			s.record(e, func(b *domain.StatsBucket) {
+				b.Deleted++
+			})
+			s.recordWallet(e.WalletID, e.Currency, s.eventTime(e), nil)
+			s.notify()
 			s.Unlock()
 		},
-		domain.TopicWalletDeposited: func(m []byte) {
-			var msg domain.WalletMsg
-			_ = json.Unmarshal(m, &msg)
+		domain.TopicWalletDeposited: func(e *domain.WalletEvent) {
 			s.Lock()
-			s.Deposited = s.Deposited.Add(msg.Amount)
+			s.Deposited = s.Deposited.Add(e.Amount)
+			cs := s.currency(e.Currency)
+			cs.Deposited = cs.Deposited.Add(e.Amount)
+			s.record(e, func(b *domain.StatsBucket) {
+				b.Deposited = b.Deposited.Add(e.Amount)
+			})
+			s.recordAmount(e.Currency, domain.OperationDeposited, e.Amount)
+			s.recordWallet(e.WalletID, e.Currency, s.eventTime(e), func(o *domain.WalletOperations) {
+				o.Deposited = o.Deposited.Add(e.Amount)
+				o.Deposits++
+			})
+			s.notify()
 			s.Unlock()
 		},
-		domain.TopicWalletWithdrawn: func(m []byte) {
-			var msg domain.WalletMsg
-			_ = json.Unmarshal(m, &msg)
+		domain.TopicWalletWithdrawn: func(e *domain.WalletEvent) {
 			s.Lock()
-			s.Withdrawn = s.Withdrawn.Add(msg.Amount)
+			s.Withdrawn = s.Withdrawn.Add(e.Amount)
+			cs := s.currency(e.Currency)
+			cs.Withdrawn = cs.Withdrawn.Add(e.Amount)
+			s.record(e, func(b *domain.StatsBucket) {
+				b.Withdrawn = b.Withdrawn.Add(e.Amount)
+			})
+			s.recordAmount(e.Currency, domain.OperationWithdrawn, e.Amount)
+			s.recordWallet(e.WalletID, e.Currency, s.eventTime(e), func(o *domain.WalletOperations) {
+				o.Withdrawn = o.Withdrawn.Add(e.Amount)
+				o.Withdrawals++
+			})
+			s.notify()
 			s.Unlock()
 		},
-		domain.TopicWalletTransferred: func(m []byte) {
-			var msg domain.WalletMsg
-			_ = json.Unmarshal(m, &msg)
+		domain.TopicWalletTransferred: func(e *domain.WalletEvent) {
 			s.Lock()
-			s.Transferred = s.Transferred.Add(msg.Amount)
+			s.Transferred = s.Transferred.Add(e.Amount)
+			cs := s.currency(e.Currency)
+			cs.Transferred = cs.Transferred.Add(e.Amount)
+			s.record(e, func(b *domain.StatsBucket) {
+				b.Transferred = b.Transferred.Add(e.Amount)
+			})
+			s.recordAmount(e.Currency, domain.OperationTransferred, e.Amount)
+			s.recordWallet(e.WalletID, e.Currency, s.eventTime(e), func(o *domain.WalletOperations) {
+				o.TransferredOut = o.TransferredOut.Add(e.Amount)
+				o.TransfersOut++
+			})
+			// Events published before the converted amount was added are same currency transfers
+			received := e.Amount
+			if e.CounterpartyAmount != nil {
+				received = *e.CounterpartyAmount
+			}
+			s.recordWallet(e.CounterpartyID, "", s.eventTime(e), func(o *domain.WalletOperations) {
+				o.TransferredIn = o.TransferredIn.Add(received)
+				o.TransfersIn++
+			})
+			s.notify()
 			s.Unlock()
 		},
 	}
-
-	for topic := range s.chans {
-		err := s.subscribe(topic, subs[topic])
-		if err != nil {
-			return err
-		}
-	}
-	return nil
 }
 
-func (s *StatsAction) subscribe(topic string, callback func([]byte)) error {
-	err := s.consumerSvc.Subscribe(s.ctx, topic, s.chans[topic])
-	if err != nil {
-		return err
+// currency returns stats of the currency, events published before wallets had
+// currencies are counted in the default one. Must be called under lock.
+func (s *StatsAction) currency(currency string) *domain.CurrencyStats {
+	if currency == "" {
+		currency = domain.DefaultCurrency
+	}
+	cs, ok := s.Currencies[currency]
+	if !ok {
+		cs = &domain.CurrencyStats{}
+		s.Currencies[currency] = cs
 	}
+	return cs
+}
 
-	go func() {
-		for {
-			select {
-			case m := <-s.chans[topic]:
-				callback(m)
-			case <-s.ctx.Done():
-				s.logger.Debug("subscribe topic finished: " + topic)
-				return
-			}
+// handle registers callback for events of the topic, the consumer commits
+// the offset once callback returns. Events that cannot be parsed are moved
+// to the dead letter topic by the consumer.
+func (s *StatsAction) handle(topic string, callback func(*domain.WalletEvent)) {
+	s.consumerSvc.Handle(topic, func(m []byte) error {
+		e, err := domain.ParseWalletEvent(topic, m)
+		if err != nil {
+			return err
 		}
-	}()
-	return nil
+		callback(e)
+		return nil
+	})
 }
@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"path/filepath"
 	"testing"
 	"time"
 
@@ -16,6 +17,7 @@ import (
 	"github.com/Kale-Grabovski/impay/api/mock"
 	"github.com/Kale-Grabovski/impay/domain"
 	"github.com/Kale-Grabovski/impay/kafka"
+	"github.com/Kale-Grabovski/impay/storage"
 )
 
 func TestStatsWalletCreatedDeleted(t *testing.T) {
@@ -23,55 +25,197 @@ func TestStatsWalletCreatedDeleted(t *testing.T) {
 
 	loggerMock := &mock.LoggerMock{}
 	baseConsumerMock := &mock.BaseConsumerMock{}
+	deadLettersMock := &mock.DeadLetterProducerMock{}
+	consumer := kafka.NewConsumer(&domain.Config{}, baseConsumerMock, deadLettersMock, loggerMock)
+	statsAction := NewStatsAction(&domain.Config{}, consumer, loggerMock)
 
-	statsAction := NewStatsAction(kafka.NewConsumer(baseConsumerMock, loggerMock), loggerMock)
-	statsAction.chans = map[string]chan []byte{
-		domain.TopicWalletCreated: make(chan []byte, 50),
+	messages := []struct {
+		topic string
+		value string
+	}{
+		{topic: domain.TopicWalletCreated, value: `{"version":1,"type":"Wallet_Created","wallet_id":"a"}`},
+		{topic: domain.TopicWalletCreated, value: `{}`},
+		{topic: domain.TopicWalletDeleted, value: `{}`},
+		{topic: domain.TopicWalletDeposited, value: `{"amount":"5.55"}`},
+		{topic: domain.TopicWalletWithdrawn, value: `{"version":1,"wallet_id":"a","amount":"1","currency":"USD"}`},
+		{topic: domain.TopicWalletTransferred, value: `{"version":1,"wallet_id":"a","amount":"2","currency":"USD"}`},
+		{topic: domain.TopicWalletDeposited, value: `{"amount":`},
+		{topic: domain.TopicWalletDeposited, value: `{"version":1,"wallet_id":"b","amount":"2","currency":"EUR"}`},
+	}
+
+	// All wallet topics are read by one subscription
+	baseConsumerMock.
+		On("SubscribeTopics", mockery.MatchedBy(func(topics []string) bool {
+			return len(topics) == len(domain.WalletTopics)
+		}), nil).
+		Once().
+		Return(nil)
+	for _, m := range messages {
+		topic := m.topic
+		baseConsumerMock.
+			On("ReadMessage", domain.ConsumerTimeout).
+			Once().
+			Return(&baseKafka.Message{
+				TopicPartition: baseKafka.TopicPartition{Topic: &topic},
+				Value:          []byte(m.value),
+			}, nil)
 	}
 	baseConsumerMock.
 		On("ReadMessage", domain.ConsumerTimeout).
 		Maybe().
-		Return(&baseKafka.Message{}, nil)
-	baseConsumerMock.On("SubscribeTopics", []string{domain.TopicWalletCreated}, nil).Once().Return(nil)
-	err := statsAction.InitConsumers()
-	assert.NoError(t, err)
-	mockery.AssertExpectationsForObjects(t, loggerMock, baseConsumerMock)
+		Return((*baseKafka.Message)(nil), baseKafka.NewError(baseKafka.ErrTimedOut, "", false))
+	baseConsumerMock.On("StoreMessage", mockery.Anything).Times(len(messages)).Return(nil, nil)
+	baseConsumerMock.On("CommitMessage", mockery.Anything).Times(len(messages)).Return(nil, nil)
+	baseConsumerMock.On("Commit").Once().Return(nil, nil)
+	baseConsumerMock.On("Close").Once().Return(nil)
+	// The malformed deposit is moved to the dead letter topic
+	loggerMock.On("Error", "cannot process message from topic "+domain.TopicWalletDeposited, mockery.Anything).Once().Return(nil)
+	deadLettersMock.
+		On(
+			"SendRaw",
+			domain.DefaultDeadLetterTopic,
+			[]byte(nil),
+			[]byte(`{"amount":`),
+			mockery.MatchedBy(func(headers []baseKafka.Header) bool {
+				return len(headers) == 4 &&
+					headers[0].Key == kafka.HeaderDeadLetterTopic &&
+					string(headers[0].Value) == domain.TopicWalletDeposited
+			}),
+		).
+		Once().
+		Return(nil)
+	loggerMock.On("Debug", "closing consumer", mockery.Anything).Once().Return(nil)
 
-	testCases := []struct {
-		req         domain.WalletMsg
-		consume     func()
-		logCallback func()
-	}{
-		{
-			req: domain.WalletMsg{Amount: decimal.NewFromFloat(5.55)},
-			consume: func() {
-				baseConsumerMock.
-					On("ReadMessage", domain.ConsumerTimeout).
-					Maybe().
-					Return(&baseKafka.Message{Value: nil}, nil)
+	assert.NoError(t, statsAction.InitConsumers())
+	assert.Eventually(t, func() bool {
+		statsAction.RLock()
+		defer statsAction.RUnlock()
+		return statsAction.Deposited.Equal(decimal.NewFromFloat(7.55))
+	}, time.Second, time.Millisecond)
+	statsAction.CloseConsumers()
+	mockery.AssertExpectationsForObjects(t, loggerMock, baseConsumerMock, deadLettersMock)
+
+	req := httptest.NewRequest(http.MethodGet, "/stats", nil)
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+
+	if assert.NoError(t, statsAction.Get(c)) {
+		assert.Equal(t, http.StatusOK, rec.Code)
+		var resp statsWalletResp
+		assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+		assert.True(t, resp.Total.Equal(decimal.NewFromInt(2)))
+		assert.True(t, resp.Active.Equal(decimal.NewFromInt(1)))
+		assert.True(t, resp.Inactive.Equal(decimal.NewFromInt(1)))
+		// Amounts of different currencies are not summed
+		assert.Nil(t, resp.Deposited)
+		assert.Nil(t, resp.Withdrawn)
+		assert.Nil(t, resp.Transferred)
+		assert.True(t, resp.Currencies["USD"].Deposited.Equal(decimal.NewFromFloat(5.55)))
+		assert.True(t, resp.Currencies["EUR"].Deposited.Equal(decimal.NewFromInt(2)))
+	}
+}
+
+func TestStatsCheckpoint(t *testing.T) {
+	loggerMock := &mock.LoggerMock{}
+	baseConsumerMock := &mock.BaseConsumerMock{}
+	consumer := kafka.NewConsumer(&domain.Config{}, baseConsumerMock, &mock.DeadLetterProducerMock{}, loggerMock)
+	statsAction := NewStatsAction(&domain.Config{}, consumer, loggerMock)
+
+	checkpoints, err := storage.NewFileCheckpointStore(filepath.Join(t.TempDir(), "stats.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.NoError(t, checkpoints.Save(&domain.StatsCheckpoint{
+		Stats: domain.WalletStats{
+			Total:     decimal.NewFromInt(1),
+			Active:    decimal.NewFromInt(1),
+			Deposited: decimal.NewFromInt(5),
+			Currencies: map[string]*domain.CurrencyStats{
+				"USD": {Deposited: decimal.NewFromInt(5)},
 			},
 		},
+		Offsets: domain.Offsets{domain.TopicWalletDeposited: {0: 3}},
+	}))
+
+	topic := domain.TopicWalletDeposited
+	baseConsumerMock.On("SubscribeTopics", mockery.Anything, nil).Once().Return(nil)
+	baseConsumerMock.
+		On("ReadMessage", domain.ConsumerTimeout).
+		Once().
+		Return(&baseKafka.Message{
+			TopicPartition: baseKafka.TopicPartition{Topic: &topic, Partition: 0, Offset: 3},
+			Value:          []byte(`{"version":1,"wallet_id":"a","amount":"2","currency":"USD"}`),
+		}, nil)
+	baseConsumerMock.
+		On("ReadMessage", domain.ConsumerTimeout).
+		Maybe().
+		Return((*baseKafka.Message)(nil), baseKafka.NewError(baseKafka.ErrTimedOut, "", false))
+	baseConsumerMock.On("StoreMessage", mockery.Anything).Once().Return(nil, nil)
+	baseConsumerMock.On("CommitMessage", mockery.Anything).Once().Return(nil, nil)
+	baseConsumerMock.On("Commit").Once().Return(nil, nil)
+	baseConsumerMock.On("Close").Once().Return(nil)
+	loggerMock.On("Debug", "closing consumer", mockery.Anything).Once().Return(nil)
+
+	assert.NoError(t, statsAction.Restore(checkpoints))
+	assert.NoError(t, statsAction.InitConsumers())
+	statsAction.StartCheckpoints(checkpoints, time.Hour)
+	assert.Eventually(t, func() bool {
+		statsAction.RLock()
+		defer statsAction.RUnlock()
+		return statsAction.Deposited.Equal(decimal.NewFromInt(7))
+	}, time.Second, time.Millisecond)
+
+	// The checkpoint saved on close resumes after the consumed message
+	statsAction.CloseConsumers()
+	mockery.AssertExpectationsForObjects(t, loggerMock, baseConsumerMock)
+
+	checkpoint, err := checkpoints.Load()
+	if assert.NoError(t, err) && assert.NotNil(t, checkpoint) {
+		assert.Equal(t, domain.Offsets{domain.TopicWalletDeposited: {0: 4}}, checkpoint.Offsets)
+		assert.True(t, checkpoint.Stats.Total.Equal(decimal.NewFromInt(1)))
+		assert.True(t, checkpoint.Stats.Deposited.Equal(decimal.NewFromInt(7)))
+		assert.True(t, checkpoint.Stats.Currencies["USD"].Deposited.Equal(decimal.NewFromInt(7)))
+	}
+}
+
+func TestStatsRebuild(t *testing.T) {
+	loggerMock := &mock.LoggerMock{}
+	sourceMock := &mock.EventSourceMock{}
+	statsAction := NewStatsAction(&domain.Config{}, &mock.ConsumerMock{}, loggerMock)
+	statsAction.Total = decimal.NewFromInt(100)
+
+	checkpoints, err := storage.NewFileCheckpointStore(filepath.Join(t.TempDir(), "stats.json"))
+	if err != nil {
+		t.Fatal(err)
 	}
 
-	for _, tc := range testCases {
-		tc.consume()
-		time.Sleep(time.Millisecond * 10)
-		req := httptest.NewRequest(http.MethodGet, "/stats", nil)
-		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
-		rec := httptest.NewRecorder()
-		c := e.NewContext(req, rec)
-
-		if assert.NoError(t, statsAction.Get(c)) {
-			assert.Equal(t, http.StatusOK, rec.Code)
-			var resp statsWalletResp
-			assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
-			assert.True(t, resp.Total.GreaterThan(decimal.NewFromInt32(0)))
-			assert.Equal(t, resp.Total, resp.Active)
-			assert.True(t, resp.Inactive.Equals(decimal.NewFromInt32(0)))
-			assert.True(t, resp.Deposited.Equals(decimal.NewFromInt32(0)))
-			assert.True(t, resp.Withdrawn.Equals(decimal.NewFromInt32(0)))
-			assert.True(t, resp.Transferred.Equals(decimal.NewFromInt32(0)))
-		}
-		mockery.AssertExpectationsForObjects(t, loggerMock, baseConsumerMock)
+	sourceMock.
+		On("Replay", domain.Offsets{}, mockery.Anything).
+		Once().
+		Run(func(args mockery.Arguments) {
+			offsets := args.Get(0).(domain.Offsets)
+			fn := args.Get(1).(func(string, []byte) error)
+			assert.NoError(t, fn(domain.TopicWalletCreated, []byte(`{"version":1,"wallet_id":"a"}`)))
+			assert.NoError(t, fn(domain.TopicWalletDeposited, []byte(`{"version":1,"wallet_id":"a","amount":"3","currency":"EUR"}`)))
+			assert.NoError(t, fn(domain.TopicWalletDeposited, []byte(`{"amount":`)))
+			offsets.Set(domain.TopicWalletCreated, 0, 1)
+			offsets.Set(domain.TopicWalletDeposited, 0, 2)
+		}).
+		Return(nil)
+	loggerMock.On("Info", "stats rebuilt", mockery.Anything).Once().Return(nil)
+
+	assert.NoError(t, statsAction.Rebuild(sourceMock, checkpoints))
+	mockery.AssertExpectationsForObjects(t, loggerMock, sourceMock)
+
+	checkpoint, err := checkpoints.Load()
+	if assert.NoError(t, err) && assert.NotNil(t, checkpoint) {
+		assert.Equal(t, domain.Offsets{
+			domain.TopicWalletCreated:   {0: 1},
+			domain.TopicWalletDeposited: {0: 2},
+		}, checkpoint.Offsets)
+		assert.True(t, checkpoint.Stats.Total.Equal(decimal.NewFromInt(1)))
+		assert.True(t, checkpoint.Stats.Deposited.Equal(decimal.NewFromInt(3)))
+		assert.True(t, checkpoint.Stats.Currencies["EUR"].Deposited.Equal(decimal.NewFromInt(3)))
 	}
 }
@@ -0,0 +1,92 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// streamHeartbeat keeps idle streams open behind proxies closing silent connections.
+const streamHeartbeat = 15 * time.Second
+
+// Stream pushes the totals as server-sent "stats" events, once on connect and then
+// whenever events change them, at most every stats.streamInterval. On shutdown a
+// "shutdown" event is sent and the stream is closed.
+func (s *StatsAction) Stream(c echo.Context) error {
+	res := c.Response()
+	res.Header().Set(echo.HeaderContentType, "text/event-stream")
+	res.Header().Set(echo.HeaderCacheControl, "no-cache")
+	res.Header().Set(echo.HeaderConnection, "keep-alive")
+	res.WriteHeader(http.StatusOK)
+
+	heartbeat := time.NewTicker(streamHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		s.RLock()
+		updated := s.updated
+		totals := s.totals()
+		s.RUnlock()
+
+		if err := writeStreamEvent(res, "stats", totals); err != nil {
+			return nil
+		}
+		sent := time.Now()
+
+	wait:
+		for {
+			select {
+			case <-updated:
+				break wait
+			case <-heartbeat.C:
+				if _, err := fmt.Fprint(res, ": heartbeat\n\n"); err != nil {
+					return nil
+				}
+				res.Flush()
+			case <-c.Request().Context().Done():
+				return nil
+			case <-s.streams.Done():
+				_ = writeStreamEvent(res, "shutdown", struct{}{})
+				return nil
+			}
+		}
+
+		throttle := time.NewTimer(time.Until(sent.Add(s.streamInterval)))
+		select {
+		case <-throttle.C:
+		case <-c.Request().Context().Done():
+			throttle.Stop()
+			return nil
+		case <-s.streams.Done():
+			throttle.Stop()
+			_ = writeStreamEvent(res, "shutdown", struct{}{})
+			return nil
+		}
+	}
+}
+
+// CloseStreams disconnects the stream clients, so the server can shut down without
+// waiting for them.
+func (s *StatsAction) CloseStreams() {
+	s.closeStreams()
+}
+
+// notify wakes up the streams after the stats changed. Must be called under lock.
+func (s *StatsAction) notify() {
+	close(s.updated)
+	s.updated = make(chan struct{})
+}
+
+func writeStreamEvent(res *echo.Response, event string, data interface{}) error {
+	b, err := json.Marshal(data)
+	if err != nil {
+		return fmt.Errorf("cannot marshal %s event: %w", event, err)
+	}
+	if _, err = fmt.Fprintf(res, "event: %s\ndata: %s\n\n", event, b); err != nil {
+		return err
+	}
+	res.Flush()
+	return nil
+}
@@ -0,0 +1,73 @@
+package api
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/Kale-Grabovski/impay/api/mock"
+	"github.com/Kale-Grabovski/impay/domain"
+)
+
+func TestStatsStream(t *testing.T) {
+	cfg := &domain.Config{}
+	cfg.Stats.StreamInterval = 200 * time.Millisecond
+	statsAction := NewStatsAction(cfg, &mock.ConsumerMock{}, &mock.LoggerMock{})
+	e := echo.New()
+	e.GET("/stats/wallets/stream", statsAction.Stream)
+	srv := httptest.NewServer(e)
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/stats/wallets/stream")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer resp.Body.Close()
+	assert.Equal(t, "text/event-stream", resp.Header.Get(echo.HeaderContentType))
+
+	r := bufio.NewReader(resp.Body)
+	next := func() (string, statsWalletResp) {
+		var event string
+		var stats statsWalletResp
+		for {
+			line, err := r.ReadString('\n')
+			if !assert.NoError(t, err) {
+				return "", stats
+			}
+			switch {
+			case strings.HasPrefix(line, "event: "):
+				event = strings.TrimSpace(strings.TrimPrefix(line, "event: "))
+			case strings.HasPrefix(line, "data: "):
+				assert.NoError(t, json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &stats))
+			case line == "\n" && event != "":
+				return event, stats
+			}
+		}
+	}
+
+	event, stats := next()
+	assert.Equal(t, "stats", event)
+	assert.True(t, stats.Deposited.IsZero())
+
+	// Events applied within the interval are pushed at once
+	handlers := statsAction.handlers()
+	handlers[domain.TopicWalletDeposited](&domain.WalletEvent{Currency: "USD", Amount: decimal.NewFromInt(5)})
+	handlers[domain.TopicWalletDeposited](&domain.WalletEvent{Currency: "USD", Amount: decimal.NewFromInt(7)})
+	event, stats = next()
+	assert.Equal(t, "stats", event)
+	assert.True(t, stats.Deposited.Equal(decimal.NewFromInt(12)))
+
+	statsAction.CloseStreams()
+	event, _ = next()
+	assert.Equal(t, "shutdown", event)
+	_, err = r.ReadString('\n')
+	assert.Error(t, err)
+}
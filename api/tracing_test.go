@@ -0,0 +1,87 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+
+	"github.com/Kale-Grabovski/impay/api/mock"
+	"github.com/Kale-Grabovski/impay/domain"
+	"github.com/Kale-Grabovski/impay/storage"
+)
+
+// contextProducer keeps the contexts messages are sent with.
+type contextProducer struct {
+	contexts []context.Context
+}
+
+func (p *contextProducer) Send(ctx context.Context, _ string, _ string, _ any) error {
+	p.contexts = append(p.contexts, ctx)
+	return nil
+}
+
+func (p *contextProducer) Produce(ctx context.Context, _ string, _ string, _ any, done func(error)) error {
+	p.contexts = append(p.contexts, ctx)
+	done(nil)
+	return nil
+}
+
+func TestTracing(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	defer func() {
+		otel.SetTracerProvider(noop.NewTracerProvider())
+		otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator())
+	}()
+
+	repo := storage.NewMemoryRepository()
+	producer := &contextProducer{}
+	walletAction := NewWalletAction(&domain.Config{}, repo, &mock.RateProviderMock{}, producer, &mock.LoggerMock{})
+	assert.NoError(t, repo.Update(func(tx domain.WalletTx) error {
+		return tx.Put(domain.NewWallet("a", "", "USD"))
+	}))
+
+	e := echo.New()
+	e.Use(TracingMiddleware(), ErrorMiddleware())
+	e.POST("/wallets/:id/deposit", walletAction.Deposit)
+
+	req := httptest.NewRequest(http.MethodPost, "/wallets/a/deposit", strings.NewReader(`{"amount": 5}`))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	// The request span continues the trace of the caller
+	spans := recorder.Ended()
+	if !assert.Len(t, spans, 1) {
+		return
+	}
+	span := spans[0]
+	assert.Equal(t, "POST /wallets/:id/deposit", span.Name())
+	assert.Equal(t, trace.SpanKindServer, span.SpanKind())
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", span.SpanContext().TraceID().String())
+	assert.Equal(t, "00f067aa0ba902b7", span.Parent().SpanID().String())
+	assert.Contains(t, span.Attributes(), semconv.HTTPResponseStatusCode(http.StatusOK))
+
+	// The relay sends the event within the trace of the request that caused it
+	assert.NoError(t, walletAction.relayOutbox())
+	if assert.Len(t, producer.contexts, 1) {
+		sent := trace.SpanContextFromContext(producer.contexts[0])
+		assert.Equal(t, span.SpanContext().TraceID(), sent.TraceID())
+		assert.Equal(t, span.SpanContext().SpanID(), sent.SpanID())
+	}
+}
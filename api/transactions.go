@@ -0,0 +1,182 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/shopspring/decimal"
+	"go.uber.org/zap"
+
+	"github.com/Kale-Grabovski/impay/domain"
+)
+
+const (
+	historyDefaultLimit = 50
+	historyMaxLimit     = 500
+)
+
+var historyTypes = map[string]bool{
+	domain.TxDeposit:     true,
+	domain.TxWithdrawal:  true,
+	domain.TxCapture:     true,
+	domain.TxTransferIn:  true,
+	domain.TxTransferOut: true,
+	domain.TxReplay:      true,
+}
+
+type transactionResp struct {
+	ID           uint64           `json:"id"`
+	Type         string           `json:"type"`
+	Amount       decimal.Decimal  `json:"amount"`
+	Currency     string           `json:"currency"`
+	Rate         *decimal.Decimal `json:"rate,omitempty"`
+	Counterparty string           `json:"counterparty"`
+	Balance      decimal.Decimal  `json:"balance"`
+	CreatedAt    time.Time        `json:"created_at"`
+}
+
+type transactionsResp struct {
+	Transactions []transactionResp `json:"transactions"`
+	NextCursor   string            `json:"next_cursor,omitempty"`
+	Err          string            `json:"err_code,omitempty"`
+	Success      bool              `json:"success"`
+}
+
+type historyFilter struct {
+	types    map[string]bool
+	from, to time.Time
+}
+
+// Transactions returns the wallet history newest first. Supported query params:
+// type (comma separated), from and to (RFC3339), cursor and limit.
+func (s *WalletAction) Transactions(c echo.Context) (err error) {
+	q, filter, err := parseHistoryQuery(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, transactionsResp{
+			Err: err.Error(),
+		})
+	}
+
+	id := c.Param("id")
+	account := domain.WalletAccount(id)
+	q.Filter = func(t *domain.Transaction) bool {
+		if !filter.from.IsZero() && t.CreatedAt.Before(filter.from) {
+			return false
+		}
+		if !filter.to.IsZero() && !t.CreatedAt.Before(filter.to) {
+			return false
+		}
+		return len(filter.types) == 0 || filter.types[walletTxType(t, account)]
+	}
+
+	var transactions []*domain.Transaction
+	err = s.repo.View(func(tx domain.WalletTx) error {
+		if _, err := usableWallet(c, tx, id); err != nil {
+			return err
+		}
+		transactions, err = tx.Transactions(account, q)
+		return err
+	})
+	if errors.Is(err, domain.ErrWalletNotFound) {
+		return c.JSON(http.StatusNotFound, transactionsResp{
+			Err: "wallet not found",
+		})
+	}
+	if err != nil {
+		requestLogger(c, s.logger).Error("cannot get wallet transactions", zap.Error(err))
+		return c.JSON(http.StatusInternalServerError, transactionsResp{
+			Err: errInternal,
+		})
+	}
+
+	resp := transactionsResp{
+		Transactions: make([]transactionResp, 0, len(transactions)),
+		Success:      true,
+	}
+	for _, t := range transactions {
+		resp.Transactions = append(resp.Transactions, newTransactionResp(t, account))
+	}
+	if len(transactions) == q.Limit {
+		resp.NextCursor = strconv.FormatUint(transactions[len(transactions)-1].ID, 10)
+	}
+	return c.JSON(http.StatusOK, resp)
+}
+
+func parseHistoryQuery(c echo.Context) (q domain.TransactionQuery, filter historyFilter, err error) {
+	q.Limit = historyDefaultLimit
+	if v := c.QueryParam("limit"); v != "" {
+		q.Limit, err = strconv.Atoi(v)
+		if err != nil || q.Limit <= 0 || q.Limit > historyMaxLimit {
+			return q, filter, errors.New("wrong limit")
+		}
+	}
+	if v := c.QueryParam("cursor"); v != "" {
+		q.Before, err = strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			return q, filter, errors.New("wrong cursor")
+		}
+	}
+	if v := c.QueryParam("from"); v != "" {
+		filter.from, err = time.Parse(time.RFC3339, v)
+		if err != nil {
+			return q, filter, errors.New("wrong from")
+		}
+	}
+	if v := c.QueryParam("to"); v != "" {
+		filter.to, err = time.Parse(time.RFC3339, v)
+		if err != nil {
+			return q, filter, errors.New("wrong to")
+		}
+	}
+	if v := c.QueryParam("type"); v != "" {
+		filter.types = make(map[string]bool)
+		for _, t := range strings.Split(v, ",") {
+			if !historyTypes[t] {
+				return q, filter, errors.New("wrong type")
+			}
+			filter.types[t] = true
+		}
+	}
+	return q, filter, nil
+}
+
+// walletTxType tells apart incoming and outgoing transfers of the wallet account.
+func walletTxType(t *domain.Transaction, account string) string {
+	if t.Type != domain.TxTransfer {
+		return t.Type
+	}
+	if e, ok := t.Entry(account); ok && e.Direction == domain.Credit {
+		return domain.TxTransferIn
+	}
+	return domain.TxTransferOut
+}
+
+func newTransactionResp(t *domain.Transaction, account string) transactionResp {
+	resp := transactionResp{
+		ID:        t.ID,
+		Type:      walletTxType(t, account),
+		Rate:      t.Rate,
+		CreatedAt: t.CreatedAt,
+	}
+	for _, e := range t.Entries {
+		if e.Account != account {
+			// FX legs of converted transfers are internal, the counterparty is the other wallet
+			if walletID, ok := domain.WalletFromAccount(e.Account); ok {
+				resp.Counterparty = walletID
+			} else if resp.Counterparty == "" && t.Rate == nil {
+				resp.Counterparty = e.Account
+			}
+			continue
+		}
+		resp.Amount = e.Amount
+		resp.Currency = e.Currency
+		if e.Balance != nil {
+			resp.Balance = *e.Balance
+		}
+	}
+	return resp
+}
@@ -0,0 +1,125 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/Kale-Grabovski/impay/api/mock"
+	"github.com/Kale-Grabovski/impay/domain"
+	"github.com/Kale-Grabovski/impay/ledger"
+	"github.com/Kale-Grabovski/impay/storage"
+)
+
+func TestTransactions(t *testing.T) {
+	repo := storage.NewMemoryRepository()
+	walletAction := NewWalletAction(&domain.Config{}, repo, &mock.RateProviderMock{}, &mock.ProducerMock{}, &mock.LoggerMock{})
+
+	assert.NoError(t, repo.Update(func(tx domain.WalletTx) error {
+		for _, id := range []string{"a", "b"} {
+			if err := tx.Put(domain.NewWallet(id, "", "USD")); err != nil {
+				return err
+			}
+		}
+		for _, tr := range []*domain.Transaction{
+			ledger.Deposit("a", "USD", decimal.NewFromInt(10)),
+			ledger.Transfer("a", "b", "USD", decimal.NewFromInt(3)),
+			ledger.Withdraw("a", "USD", decimal.NewFromInt(2)),
+			ledger.Transfer("b", "a", "USD", decimal.NewFromInt(1)),
+		} {
+			if err := ledger.Post(tx, tr); err != nil {
+				return err
+			}
+		}
+		return nil
+	}))
+
+	testCases := []struct {
+		id       string
+		query    string
+		respCode int
+		err      string
+		types    []string
+		balances []int64
+		cursor   string
+	}{
+		{
+			id:       "a",
+			respCode: http.StatusOK,
+			types:    []string{domain.TxTransferIn, domain.TxWithdrawal, domain.TxTransferOut, domain.TxDeposit},
+			balances: []int64{6, 5, 7, 10},
+		},
+		{
+			id:       "a",
+			query:    "?limit=2",
+			respCode: http.StatusOK,
+			types:    []string{domain.TxTransferIn, domain.TxWithdrawal},
+			balances: []int64{6, 5},
+			cursor:   "3",
+		},
+		{
+			id:       "a",
+			query:    "?limit=2&cursor=3",
+			respCode: http.StatusOK,
+			types:    []string{domain.TxTransferOut, domain.TxDeposit},
+			balances: []int64{7, 10},
+			cursor:   "1",
+		},
+		{
+			id:       "a",
+			query:    "?type=deposit,transfer_out",
+			respCode: http.StatusOK,
+			types:    []string{domain.TxTransferOut, domain.TxDeposit},
+			balances: []int64{7, 10},
+		},
+		{
+			id:       "b",
+			query:    "?from=2000-01-01T00:00:00Z&to=2000-01-02T00:00:00Z",
+			respCode: http.StatusOK,
+		},
+		{
+			id:       "a",
+			query:    "?type=bonus",
+			respCode: http.StatusBadRequest,
+			err:      "wrong type",
+		},
+		{
+			id:       "666",
+			respCode: http.StatusNotFound,
+			err:      "wallet not found",
+		},
+	}
+
+	e := echo.New()
+	for _, tc := range testCases {
+		req := httptest.NewRequest(http.MethodGet, "/"+tc.query, nil)
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		c.SetPath("/wallets/:id/transactions")
+		c.SetParamNames("id")
+		c.SetParamValues(tc.id)
+
+		if assert.NoError(t, walletAction.Transactions(c)) {
+			assert.Equal(t, tc.respCode, rec.Code)
+			var resp transactionsResp
+			assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+			if tc.err != "" {
+				assert.Equal(t, tc.err, resp.Err)
+				continue
+			}
+			assert.True(t, resp.Success)
+			assert.Equal(t, tc.cursor, resp.NextCursor)
+			if assert.Len(t, resp.Transactions, len(tc.types)) {
+				for i, tr := range resp.Transactions {
+					assert.Equal(t, tc.types[i], tr.Type)
+					assert.True(t, decimal.NewFromInt(tc.balances[i]).Equal(tr.Balance))
+				}
+			}
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package api
 
 import (
+	"context"
 	"errors"
 	"math/rand"
 	"net/http"
@@ -13,20 +14,29 @@ import (
 	"go.uber.org/zap"
 
 	"github.com/Kale-Grabovski/impay/domain"
+	"github.com/Kale-Grabovski/impay/ledger"
 )
 
-const walletLen = 8
+const (
+	walletLen       = 8
+	errInternal     = "internal error"
+	janitorInterval = 10 * time.Second
+)
+
+var errWalletDeleted = errors.New("wallet already deleted")
 
 type walletReq struct {
-	Name string `json:"name"`
+	Name     string `json:"name"`
+	Currency string `json:"currency"`
 }
 
 type createWalletResp struct {
-	ID      string `json:"id"`
-	Name    string `json:"name"`
-	Status  string `json:"status"`
-	Err     string `json:"err_code,omitempty"`
-	Success bool   `json:"success"`
+	ID       string `json:"id"`
+	Name     string `json:"name"`
+	Currency string `json:"currency"`
+	Status   string `json:"status"`
+	Err      string `json:"err_code,omitempty"`
+	Success  bool   `json:"success"`
 }
 
 type updateDeleteWalletResp struct {
@@ -36,12 +46,15 @@ type updateDeleteWalletResp struct {
 }
 
 type getWalletResp struct {
-	Balance decimal.Decimal `json:"balance"`
-	ID      string          `json:"id"`
-	Name    string          `json:"name"`
-	Status  string          `json:"status"`
-	Err     string          `json:"err_code,omitempty"`
-	Success bool            `json:"success"`
+	Balance   decimal.Decimal `json:"balance"`
+	Held      decimal.Decimal `json:"held"`
+	Available decimal.Decimal `json:"available"`
+	Currency  string          `json:"currency"`
+	ID        string          `json:"id"`
+	Name      string          `json:"name"`
+	Status    string          `json:"status"`
+	Err       string          `json:"err_code,omitempty"`
+	Success   bool            `json:"success"`
 }
 
 type financeReq struct {
@@ -50,93 +63,241 @@ type financeReq struct {
 }
 
 type financeResp struct {
-	Amount  decimal.Decimal `json:"amount"`
-	Err     string          `json:"err_code,omitempty"`
-	Success bool            `json:"success"`
+	Amount          decimal.Decimal  `json:"amount"`
+	Currency        string           `json:"currency,omitempty"`
+	ConvertedAmount *decimal.Decimal `json:"converted_amount,omitempty"`
+	Rate            *decimal.Decimal `json:"rate,omitempty"`
+	TransactionID   uint64           `json:"transaction_id,omitempty"`
+	Err             string           `json:"err_code,omitempty"`
+	Success         bool             `json:"success"`
 }
 
 type Producer interface {
-	Send(topic string, partition int32, msg any) error
+	// Send waits for the delivery of the message.
+	Send(ctx context.Context, topic string, key string, msg any) error
+	// Produce queues the message and reports its delivery to done later.
+	Produce(ctx context.Context, topic string, key string, msg any, done func(error)) error
 }
 
 type WalletAction struct {
-	sync.RWMutex
-	wallets  map[string]*domain.Wallet
-	producer Producer
-	logger   domain.Logger
+	repo            domain.WalletRepository
+	rates           domain.RateProvider
+	producer        Producer
+	logger          domain.Logger
+	idempotencyTTL  time.Duration
+	defaultCurrency string
+	holdTTL         time.Duration
+	syncPublish     bool
+	stateTopic      string
+	relayWake       chan struct{}
+	relayMu         sync.Mutex
+	ctx             context.Context
+	cancel          context.CancelFunc
+	wg              sync.WaitGroup
 }
 
 func NewWalletAction(
+	cfg *domain.Config,
+	repo domain.WalletRepository,
+	rates domain.RateProvider,
 	producer Producer,
 	logger domain.Logger,
 ) *WalletAction {
+	ttl := cfg.Idempotency.TTL
+	if ttl <= 0 {
+		ttl = domain.DefaultIdempotencyTTL
+	}
+	currency := cfg.DefaultCurrency
+	if currency == "" {
+		currency = domain.DefaultCurrency
+	}
+	holdTTL := cfg.Holds.TTL
+	if holdTTL <= 0 {
+		holdTTL = domain.DefaultHoldTTL
+	}
+	// The state topic is written only when it is replayed on startup
+	var stateTopic string
+	if cfg.EventSourcing.Enabled {
+		stateTopic = cfg.EventSourcing.Topic
+	}
+	ctx, cancel := context.WithCancel(context.Background())
 	return &WalletAction{
-		wallets:  make(map[string]*domain.Wallet),
-		producer: producer,
-		logger:   logger,
+		repo:            repo,
+		rates:           rates,
+		producer:        producer,
+		logger:          logger,
+		idempotencyTTL:  ttl,
+		defaultCurrency: currency,
+		holdTTL:         holdTTL,
+		syncPublish:     cfg.Kafka.SyncPublish,
+		stateTopic:      stateTopic,
+		relayWake:       make(chan struct{}, 1),
+		ctx:             ctx,
+		cancel:          cancel,
 	}
 }
 
+// StartJanitor runs periodic cleanup of expired records in background.
+func (s *WalletAction) StartJanitor() {
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		ticker := time.NewTicker(janitorInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := s.purgeIdempotency(); err != nil {
+					s.logger.Error("cannot purge idempotency records", zap.Error(err))
+				}
+				if err := s.expireHolds(); err != nil {
+					s.logger.Error("cannot expire holds", zap.Error(err))
+				}
+			case <-s.ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// Stop waits for the janitor and the relay to finish.
+func (s *WalletAction) Stop() {
+	s.cancel()
+	s.wg.Wait()
+}
+
 func (s *WalletAction) GetAll(c echo.Context) (err error) {
-	s.RLock()
-	defer s.RUnlock()
-	wallets := make([]*domain.Wallet, 0, len(s.wallets))
-	for _, w := range s.wallets {
-		wallets = append(wallets, w)
+	var wallets []*domain.Wallet
+	err = s.repo.View(func(tx domain.WalletTx) (err error) {
+		wallets, err = tx.GetAll()
+		return err
+	})
+	if err != nil {
+		requestLogger(c, s.logger).Error("cannot get wallets", zap.Error(err))
+		return c.JSON(http.StatusInternalServerError, getWalletResp{
+			Err: errInternal,
+		})
+	}
+
+	if p := principal(c); p != nil && !p.Admin {
+		owned := wallets[:0]
+		for _, w := range wallets {
+			if p.CanUse(w) {
+				owned = append(owned, w)
+			}
+		}
+		wallets = owned
 	}
 	return c.JSON(http.StatusOK, wallets)
 }
 
 func (s *WalletAction) GetById(c echo.Context) (err error) {
-	s.RLock()
-	defer s.RUnlock()
-
-	if wallet, ok := s.wallets[c.Param("id")]; ok {
-		return c.JSON(http.StatusOK, getWalletResp{
-			Balance: wallet.Balance,
-			ID:      wallet.ID,
-			Name:    wallet.Name,
-			Status:  wallet.Status,
-			Success: true,
+	var wallet *domain.Wallet
+	err = s.repo.View(func(tx domain.WalletTx) (err error) {
+		wallet, err = usableWallet(c, tx, c.Param("id"))
+		return err
+	})
+	if errors.Is(err, domain.ErrWalletNotFound) {
+		return c.JSON(http.StatusNotFound, getWalletResp{
+			Err: "wallet not found",
+		})
+	}
+	if err != nil {
+		requestLogger(c, s.logger).Error("cannot get wallet", zap.Error(err))
+		return c.JSON(http.StatusInternalServerError, getWalletResp{
+			Err: errInternal,
 		})
 	}
 
-	return c.JSON(http.StatusNotFound, getWalletResp{
-		Err: "wallet not found",
+	return c.JSON(http.StatusOK, getWalletResp{
+		Balance:   wallet.Balance,
+		Held:      wallet.Held,
+		Available: wallet.Available(),
+		Currency:  wallet.Currency,
+		ID:        wallet.ID,
+		Name:      wallet.Name,
+		Status:    wallet.Status,
+		Success:   true,
 	})
 }
 
 func (s *WalletAction) Create(c echo.Context) (err error) {
+	ireq, err := readIdempotency(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, createWalletResp{
+			Err: err.Error(),
+		})
+	}
 	req := &walletReq{}
 	if err = c.Bind(req); err != nil {
 		return c.JSON(http.StatusBadRequest, createWalletResp{
 			Err: "wrong name passed" + err.Error(),
 		})
 	}
+	if req.Currency == "" {
+		req.Currency = s.defaultCurrency
+	}
+	if _, ok := domain.CurrencyScale(req.Currency); !ok {
+		return c.JSON(http.StatusBadRequest, createWalletResp{
+			Err: "unknown currency",
+		})
+	}
 
-	wallet, err := s.genWallet(req.Name)
+	var (
+		wallet *domain.Wallet
+		replay *domain.IdempotencyRecord
+	)
+	err = s.repo.Update(func(tx domain.WalletTx) (err error) {
+		replay, err = s.idempotencyLookup(tx, ireq)
+		if err != nil || replay != nil {
+			return err
+		}
+		wallet, err = s.genWallet(tx, req.Name, req.Currency)
+		if err != nil {
+			return err
+		}
+		if p := principal(c); p != nil {
+			wallet.Owner = p.Subject
+		}
+		if err = tx.Put(wallet); err != nil {
+			return err
+		}
+		e, err := walletEvent(tx, domain.TopicWalletCreated, wallet.ID)
+		if err != nil {
+			return err
+		}
+		if err = s.outboxAdd(c.Request().Context(), tx, e); err != nil {
+			return err
+		}
+		return s.idempotencySave(tx, ireq, http.StatusOK, newCreateWalletResp(wallet))
+	})
+	if errors.Is(err, domain.ErrIdempotencyConflict) {
+		return c.JSON(http.StatusConflict, createWalletResp{
+			Err: err.Error(),
+		})
+	}
 	if err != nil {
 		return c.JSON(http.StatusInternalServerError, createWalletResp{
 			Err: err.Error(),
 		})
 	}
+	if replay != nil {
+		return replayResponse(c, replay)
+	}
 
-	s.Lock()
-	s.wallets[wallet.ID] = wallet
-	s.Unlock()
+	setWalletID(c, wallet.ID)
+	s.publish()
+	return c.JSON(http.StatusOK, newCreateWalletResp(wallet))
+}
 
-	msg := domain.WalletMsg{}
-	err = s.producer.Send(domain.TopicWalletCreated, 0, msg)
-	if err != nil {
-		s.logger.Error("cannot publish create event to kafka", zap.Error(err))
+func newCreateWalletResp(wallet *domain.Wallet) createWalletResp {
+	return createWalletResp{
+		ID:       wallet.ID,
+		Name:     wallet.Name,
+		Currency: wallet.Currency,
+		Status:   wallet.Status,
+		Success:  true,
 	}
-
-	return c.JSON(http.StatusOK, createWalletResp{
-		ID:      wallet.ID,
-		Name:    wallet.Name,
-		Status:  wallet.Status,
-		Success: true,
-	})
 }
 
 func (s *WalletAction) Update(c echo.Context) (err error) {
@@ -152,161 +313,294 @@ func (s *WalletAction) Update(c echo.Context) (err error) {
 		})
 	}
 
-	s.Lock()
-	defer s.Unlock()
-
-	if wallet, ok := s.wallets[c.Param("id")]; ok {
+	id := c.Param("id")
+	err = s.repo.Update(func(tx domain.WalletTx) error {
+		wallet, err := usableWallet(c, tx, id)
+		if err != nil {
+			return err
+		}
 		wallet.Name = req.Name
-		return c.JSON(http.StatusOK, updateDeleteWalletResp{
-			ID:      wallet.ID,
-			Success: true,
+		if err = tx.Put(wallet); err != nil {
+			return err
+		}
+		e, err := walletEvent(tx, domain.TopicWalletUpdated, id)
+		if err != nil {
+			return err
+		}
+		return s.outboxAdd(c.Request().Context(), tx, e)
+	})
+	if errors.Is(err, domain.ErrWalletNotFound) {
+		return c.JSON(http.StatusNotFound, updateDeleteWalletResp{
+			Err: "wallet not found",
+		})
+	}
+	if err != nil {
+		requestLogger(c, s.logger).Error("cannot update wallet", zap.Error(err))
+		return c.JSON(http.StatusInternalServerError, updateDeleteWalletResp{
+			Err: errInternal,
 		})
 	}
 
-	return c.JSON(http.StatusNotFound, updateDeleteWalletResp{
-		Err: "wallet not found",
+	s.publish()
+	return c.JSON(http.StatusOK, updateDeleteWalletResp{
+		ID:      id,
+		Success: true,
 	})
 }
 
 func (s *WalletAction) Delete(c echo.Context) (err error) {
-	s.Lock()
-	if wallet, ok := s.wallets[c.Param("id")]; ok {
+	id := c.Param("id")
+	err = s.repo.Update(func(tx domain.WalletTx) error {
+		wallet, err := usableWallet(c, tx, id)
+		if err != nil {
+			return err
+		}
 		if wallet.Status == domain.StatusInactive {
-			s.Unlock()
-			return c.JSON(http.StatusBadRequest, updateDeleteWalletResp{
-				Err: "wallet already deleted",
-			})
+			return errWalletDeleted
 		}
 		wallet.Status = domain.StatusInactive
-		s.Unlock()
-
-		msg := domain.WalletMsg{}
-		err = s.producer.Send(domain.TopicWalletDeleted, 0, msg)
+		if err = tx.Put(wallet); err != nil {
+			return err
+		}
+		e, err := walletEvent(tx, domain.TopicWalletDeleted, id)
 		if err != nil {
-			s.logger.Error("cannot publish delete event to kafka", zap.Error(err))
+			return err
 		}
-
-		return c.JSON(http.StatusOK, updateDeleteWalletResp{
-			ID:      wallet.ID,
-			Success: true,
+		return s.outboxAdd(c.Request().Context(), tx, e)
+	})
+	switch {
+	case errors.Is(err, domain.ErrWalletNotFound):
+		return c.JSON(http.StatusNotFound, updateDeleteWalletResp{
+			Err: "wallet not found",
+		})
+	case errors.Is(err, errWalletDeleted):
+		return c.JSON(http.StatusBadRequest, updateDeleteWalletResp{
+			Err: err.Error(),
+		})
+	case err != nil:
+		requestLogger(c, s.logger).Error("cannot delete wallet", zap.Error(err))
+		return c.JSON(http.StatusInternalServerError, updateDeleteWalletResp{
+			Err: errInternal,
 		})
 	}
-	s.Unlock()
 
-	return c.JSON(http.StatusNotFound, updateDeleteWalletResp{
-		Err: "wallet not found",
+	s.publish()
+	return c.JSON(http.StatusOK, updateDeleteWalletResp{
+		ID:      id,
+		Success: true,
 	})
 }
 
 func (s *WalletAction) Deposit(c echo.Context) (err error) {
-	return s.financeProcess(c, func(req *financeReq, wallet *domain.Wallet) error {
-		s.Lock()
-		wallet.Balance = wallet.Balance.Add(req.Amount)
-		s.Unlock()
-
-		s.financePublish(domain.TopicWalletDeposited, req.Amount)
-		return c.JSON(http.StatusOK, financeResp{
-			Success: true,
-			Amount:  req.Amount,
-		})
+	return s.financeProcess(c, domain.TopicWalletDeposited, func(
+		tx domain.WalletTx,
+		req *financeReq,
+		wallet *domain.Wallet,
+	) (int, financeResp, error) {
+		t := ledger.Deposit(wallet.ID, wallet.Currency, req.Amount)
+		if err := ledger.Post(tx, t); err != nil {
+			return 0, financeResp{}, err
+		}
+		return http.StatusOK, financeResp{
+			Success:       true,
+			Amount:        req.Amount,
+			Currency:      wallet.Currency,
+			TransactionID: t.ID,
+		}, nil
 	})
 }
 
 func (s *WalletAction) Withdraw(c echo.Context) (err error) {
-	return s.financeProcess(c, func(req *financeReq, wallet *domain.Wallet) error {
-		s.Lock()
-		if wallet.Balance.LessThan(req.Amount) {
-			s.Unlock()
-			return c.JSON(http.StatusBadRequest, financeResp{
+	return s.financeProcess(c, domain.TopicWalletWithdrawn, func(
+		tx domain.WalletTx,
+		req *financeReq,
+		wallet *domain.Wallet,
+	) (int, financeResp, error) {
+		if wallet.Available().LessThan(req.Amount) {
+			return http.StatusBadRequest, financeResp{
 				Err: "not enough money to withdraw",
-			})
+			}, nil
 		}
-		wallet.Balance = wallet.Balance.Add(req.Amount.Neg())
-		s.Unlock()
-
-		s.financePublish(domain.TopicWalletWithdrawn, req.Amount)
-		return c.JSON(http.StatusOK, financeResp{
-			Success: true,
-			Amount:  req.Amount,
-		})
+		t := ledger.Withdraw(wallet.ID, wallet.Currency, req.Amount)
+		if err := ledger.Post(tx, t); err != nil {
+			return 0, financeResp{}, err
+		}
+		return http.StatusOK, financeResp{
+			Success:       true,
+			Amount:        req.Amount,
+			Currency:      wallet.Currency,
+			TransactionID: t.ID,
+		}, nil
 	})
 }
 
 func (s *WalletAction) Transfer(c echo.Context) (err error) {
-	return s.financeProcess(c, func(req *financeReq, wallet *domain.Wallet) error {
-		s.Lock()
-		if wallet.Balance.LessThan(req.Amount) {
-			s.Unlock()
-			return c.JSON(http.StatusBadRequest, financeResp{
+	return s.financeProcess(c, domain.TopicWalletTransferred, func(
+		tx domain.WalletTx,
+		req *financeReq,
+		wallet *domain.Wallet,
+	) (int, financeResp, error) {
+		if wallet.Available().LessThan(req.Amount) {
+			return http.StatusBadRequest, financeResp{
 				Err: "not enough money to transfer",
-			})
+			}, nil
 		}
-		walletTo, ok2 := s.wallets[req.TransferTo]
-		if !ok2 {
-			s.Unlock()
-			return c.JSON(http.StatusNotFound, financeResp{
+		if req.TransferTo == wallet.ID {
+			return http.StatusBadRequest, financeResp{
+				Err: "cannot transfer to the same wallet",
+			}, nil
+		}
+		walletTo, err := tx.Get(req.TransferTo)
+		if errors.Is(err, domain.ErrWalletNotFound) {
+			return http.StatusNotFound, financeResp{
 				Err: "target wallet not found",
-			})
+			}, nil
+		}
+		if err != nil {
+			return 0, financeResp{}, err
 		}
-		wallet.Balance = wallet.Balance.Add(req.Amount.Neg())
-		walletTo.Balance = walletTo.Balance.Add(req.Amount)
-		s.Unlock()
-
-		s.financePublish(domain.TopicWalletTransferred, req.Amount)
-		return c.JSON(http.StatusOK, financeResp{
-			Success: true,
-			Amount:  req.Amount,
-		})
-	})
-}
 
-func (s *WalletAction) financePublish(topic string, amount decimal.Decimal) {
-	msg := domain.WalletMsg{
-		Amount: amount,
-	}
-	err := s.producer.Send(topic, 0, msg)
-	if err != nil {
-		s.logger.Error("cannot publish event to topic "+topic, zap.Error(err))
-	}
+		resp := financeResp{
+			Success:  true,
+			Amount:   req.Amount,
+			Currency: wallet.Currency,
+		}
+		t := ledger.Transfer(wallet.ID, walletTo.ID, wallet.Currency, req.Amount)
+		if walletTo.Currency != wallet.Currency {
+			rate, err := s.rates.Rate(wallet.Currency, walletTo.Currency)
+			if errors.Is(err, domain.ErrRateNotFound) {
+				return http.StatusBadRequest, financeResp{
+					Err: "exchange rate not found",
+				}, nil
+			}
+			if err != nil {
+				return 0, financeResp{}, err
+			}
+			t, err = ledger.Exchange(wallet.ID, wallet.Currency, walletTo.ID, walletTo.Currency, req.Amount, rate)
+			if errors.Is(err, domain.ErrInvalidEntry) {
+				return http.StatusBadRequest, financeResp{
+					Err: "amount too small to convert",
+				}, nil
+			}
+			if err != nil {
+				return 0, financeResp{}, err
+			}
+			converted, _ := t.Entry(domain.WalletAccount(walletTo.ID))
+			resp.ConvertedAmount = &converted.Amount
+			resp.Rate = t.Rate
+		}
+		if err = ledger.Post(tx, t); err != nil {
+			return 0, financeResp{}, err
+		}
+		resp.TransactionID = t.ID
+		return http.StatusOK, resp, nil
+	})
 }
 
+// financeProcess runs callback against the wallet from the request path inside
+// a single storage transaction and stores the event to topic on success.
 func (s *WalletAction) financeProcess(
 	c echo.Context,
-	callback func(*financeReq, *domain.Wallet) error,
+	topic string,
+	callback func(domain.WalletTx, *financeReq, *domain.Wallet) (int, financeResp, error),
 ) error {
-	req := &financeReq{}
-	if err := c.Bind(req); err != nil {
+	ireq, err := readIdempotency(c)
+	if err != nil {
 		return c.JSON(http.StatusBadRequest, financeResp{
 			Err: "wrong input params",
 		})
 	}
-	if req.Amount.LessThan(decimal.NewFromInt32(0)) {
+	req := &financeReq{}
+	if err = c.Bind(req); err != nil {
 		return c.JSON(http.StatusBadRequest, financeResp{
-			Err: "wrong amount",
+			Err: "wrong input params",
 		})
 	}
 
-	s.RLock()
-	wallet, ok := s.wallets[c.Param("id")]
-	s.RUnlock()
+	var (
+		code   int
+		resp   financeResp
+		replay *domain.IdempotencyRecord
+	)
+	err = s.repo.Update(func(tx domain.WalletTx) (err error) {
+		replay, err = s.idempotencyLookup(tx, ireq)
+		if err != nil || replay != nil {
+			return err
+		}
+		wallet, err := usableWallet(c, tx, c.Param("id"))
+		if err != nil {
+			return err
+		}
+		// Zero amounts are rejected as well: the ledger does not accept empty postings
+		switch {
+		case !req.Amount.IsPositive():
+			code, resp = http.StatusBadRequest, financeResp{Err: "wrong amount"}
+		case domain.ValidateAmount(req.Amount, wallet.Currency) != nil:
+			code, resp = http.StatusBadRequest, financeResp{Err: "wrong amount scale"}
+		default:
+			code, resp, err = callback(tx, req, wallet)
+			if err != nil {
+				return err
+			}
+		}
+		if code == http.StatusOK {
+			e, err := walletEvent(tx, topic, wallet.ID)
+			if err != nil {
+				return err
+			}
+			e.Amount = req.Amount
+			e.TransactionID = resp.TransactionID
+			if topic == domain.TopicWalletTransferred {
+				target, err := tx.Get(req.TransferTo)
+				if err != nil {
+					return err
+				}
+				e.CounterpartyID = target.ID
+				e.CounterpartyAmount = &req.Amount
+				if resp.ConvertedAmount != nil {
+					e.CounterpartyAmount = resp.ConvertedAmount
+				}
+				e.CounterpartyBalance = &target.Balance
+			}
+			if err = s.outboxAdd(c.Request().Context(), tx, e); err != nil {
+				return err
+			}
+		}
+		return s.idempotencySave(tx, ireq, code, resp)
+	})
+	if errors.Is(err, domain.ErrWalletNotFound) {
+		return c.JSON(http.StatusNotFound, financeResp{
+			Err: "wallet not found",
+		})
+	}
+	if errors.Is(err, domain.ErrIdempotencyConflict) {
+		return c.JSON(http.StatusConflict, financeResp{
+			Err: err.Error(),
+		})
+	}
+	if err != nil {
+		requestLogger(c, s.logger).Error("cannot process finance operation", zap.Error(err))
+		return c.JSON(http.StatusInternalServerError, financeResp{
+			Err: errInternal,
+		})
+	}
 
-	if ok {
-		return callback(req, wallet)
+	if replay != nil {
+		return replayResponse(c, replay)
 	}
-	return c.JSON(http.StatusNotFound, financeResp{
-		Err: "wallet not found",
-	})
+
+	if code == http.StatusOK {
+		s.publish()
+	}
+	return c.JSON(code, resp)
 }
 
-func (s *WalletAction) genWallet(name string) (wallet *domain.Wallet, err error) {
+func (s *WalletAction) genWallet(tx domain.WalletTx, name, currency string) (wallet *domain.Wallet, err error) {
 	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
 	letterRunes := []rune("123456789abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ")
 
 	walletID := make([]rune, walletLen)
 
-	s.RLock()
-	defer s.RUnlock()
-
 	timeout := time.NewTimer(20 * time.Millisecond)
 	for {
 		select {
@@ -317,9 +611,14 @@ func (s *WalletAction) genWallet(name string) (wallet *domain.Wallet, err error)
 				walletID[i] = letterRunes[rnd.Intn(len(letterRunes))]
 			}
 			id := string(walletID)
-			if _, ok := s.wallets[id]; !ok {
+			_, err = tx.Get(id)
+			if errors.Is(err, domain.ErrWalletNotFound) {
+				timeout.Stop()
+				return domain.NewWallet(id, name, currency), nil
+			}
+			if err != nil {
 				timeout.Stop()
-				return domain.NewWallet(id, name), nil
+				return nil, err
 			}
 		}
 	}
@@ -16,6 +16,7 @@ import (
 
 	"github.com/Kale-Grabovski/impay/api/mock"
 	"github.com/Kale-Grabovski/impay/domain"
+	"github.com/Kale-Grabovski/impay/storage"
 )
 
 func TestDeposit(t *testing.T) {
@@ -24,7 +25,7 @@ func TestDeposit(t *testing.T) {
 	// Create one wallet
 	producerMock := &mock.ProducerMock{}
 	loggerMock := &mock.LoggerMock{}
-	walletAction := NewWalletAction(producerMock, loggerMock)
+	walletAction := NewWalletAction(&domain.Config{}, storage.NewMemoryRepository(), &mock.RateProviderMock{}, producerMock, loggerMock)
 
 	req := httptest.NewRequest(http.MethodPost, "/wallets", strings.NewReader(`{"name": "xxx"}`))
 	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
@@ -32,7 +33,7 @@ func TestDeposit(t *testing.T) {
 	c := e.NewContext(req, rec)
 
 	producerMock.
-		On("Send", domain.TopicWalletCreated, int32(0), domain.WalletMsg{}).
+		On("Produce", domain.TopicWalletCreated, mockery.Anything, eventMatching(domain.WalletEvent{})).
 		Once().
 		Return(nil)
 
@@ -42,6 +43,7 @@ func TestDeposit(t *testing.T) {
 	var walletResp *createWalletResp
 	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &walletResp))
 
+	relayNotified(walletAction)
 	mockery.AssertExpectationsForObjects(t, loggerMock, producerMock)
 
 	testCases := []struct {
@@ -59,10 +61,10 @@ func TestDeposit(t *testing.T) {
 			producerCallback: func() {
 				producerMock.
 					On(
-						"Send",
+						"Produce",
 						domain.TopicWalletDeposited,
-						int32(0),
-						domain.WalletMsg{Amount: decimal.NewFromFloat(5.55)},
+						walletResp.ID,
+						eventMatching(domain.WalletEvent{Amount: decimal.NewFromFloat(5.55), Currency: "USD"}),
 					).
 					Once().
 					Return(nil)
@@ -88,10 +90,10 @@ func TestDeposit(t *testing.T) {
 			producerCallback: func() {
 				producerMock.
 					On(
-						"Send",
+						"Produce",
 						domain.TopicWalletDeposited,
-						int32(0),
-						domain.WalletMsg{Amount: decimal.NewFromFloat(5.55)},
+						walletResp.ID,
+						eventMatching(domain.WalletEvent{Amount: decimal.NewFromFloat(5.55), Currency: "USD"}),
 					).
 					Once().
 					Return(errors.New("publish failed"))
@@ -134,6 +136,7 @@ func TestDeposit(t *testing.T) {
 				assert.Equal(t, resp.Amount, decimal.NewFromFloat(5.55))
 			}
 		}
+		relayNotified(walletAction)
 		mockery.AssertExpectationsForObjects(t, loggerMock, producerMock)
 	}
 
@@ -158,12 +161,22 @@ func TestDeposit(t *testing.T) {
 			req:      `{"amount": 5.55}`,
 			respCode: http.StatusOK,
 			producerCallback: func() {
+				// The deposit event that failed to publish is redelivered first
 				producerMock.
 					On(
-						"Send",
+						"Produce",
+						domain.TopicWalletDeposited,
+						walletResp.ID,
+						eventMatching(domain.WalletEvent{Amount: decimal.NewFromFloat(5.55), Currency: "USD"}),
+					).
+					Once().
+					Return(nil)
+				producerMock.
+					On(
+						"Produce",
 						domain.TopicWalletWithdrawn,
-						int32(0),
-						domain.WalletMsg{Amount: decimal.NewFromFloat(5.55)},
+						walletResp.ID,
+						eventMatching(domain.WalletEvent{Amount: decimal.NewFromFloat(5.55), Currency: "USD"}),
 					).
 					Once().
 					Return(nil)
@@ -196,6 +209,7 @@ func TestDeposit(t *testing.T) {
 				assert.Equal(t, resp.Amount, decimal.NewFromFloat(5.55))
 			}
 		}
+		relayNotified(walletAction)
 		mockery.AssertExpectationsForObjects(t, loggerMock, producerMock)
 	}
 }
@@ -206,7 +220,7 @@ func TestDelete(t *testing.T) {
 	// Create one wallet
 	producerMock := &mock.ProducerMock{}
 	loggerMock := &mock.LoggerMock{}
-	walletAction := NewWalletAction(producerMock, loggerMock)
+	walletAction := NewWalletAction(&domain.Config{}, storage.NewMemoryRepository(), &mock.RateProviderMock{}, producerMock, loggerMock)
 
 	req := httptest.NewRequest(http.MethodPost, "/wallets", strings.NewReader(`{"name": "xxx"}`))
 	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
@@ -214,7 +228,7 @@ func TestDelete(t *testing.T) {
 	c := e.NewContext(req, rec)
 
 	producerMock.
-		On("Send", domain.TopicWalletCreated, int32(0), domain.WalletMsg{}).
+		On("Produce", domain.TopicWalletCreated, mockery.Anything, eventMatching(domain.WalletEvent{})).
 		Twice().
 		Return(nil)
 
@@ -248,7 +262,7 @@ func TestDelete(t *testing.T) {
 			respCode: http.StatusOK,
 			producerCallback: func() {
 				producerMock.
-					On("Send", domain.TopicWalletDeleted, int32(0), domain.WalletMsg{}).
+					On("Produce", domain.TopicWalletDeleted, walletResp.ID, eventMatching(domain.WalletEvent{})).
 					Once().
 					Return(nil)
 			},
@@ -264,13 +278,13 @@ func TestDelete(t *testing.T) {
 			respCode: http.StatusOK,
 			producerCallback: func() {
 				producerMock.
-					On("Send", domain.TopicWalletDeleted, int32(0), domain.WalletMsg{}).
+					On("Produce", domain.TopicWalletDeleted, walletResp2.ID, eventMatching(domain.WalletEvent{})).
 					Once().
 					Return(errors.New("publish failed"))
 			},
 			logCallback: func() {
 				loggerMock.
-					On("Error", "cannot publish delete event to kafka", []zap.Field{zap.Error(errors.New("publish failed"))}).
+					On("Error", "cannot publish event to topic "+domain.TopicWalletDeleted, []zap.Field{zap.Error(errors.New("publish failed"))}).
 					Once().
 					Return(nil)
 			},
@@ -307,6 +321,7 @@ func TestDelete(t *testing.T) {
 				assert.Equal(t, tc.id, resp.ID)
 			}
 		}
+		relayNotified(walletAction)
 		mockery.AssertExpectationsForObjects(t, loggerMock, producerMock)
 	}
 }
@@ -317,7 +332,7 @@ func TestGetAll(t *testing.T) {
 	// Create one wallet
 	producerMock := &mock.ProducerMock{}
 	loggerMock := &mock.LoggerMock{}
-	walletAction := NewWalletAction(producerMock, loggerMock)
+	walletAction := NewWalletAction(&domain.Config{}, storage.NewMemoryRepository(), &mock.RateProviderMock{}, producerMock, loggerMock)
 
 	req := httptest.NewRequest(http.MethodPost, "/wallets", strings.NewReader(`{"name": "xxx"}`))
 	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
@@ -325,7 +340,7 @@ func TestGetAll(t *testing.T) {
 	c := e.NewContext(req, rec)
 
 	producerMock.
-		On("Send", domain.TopicWalletCreated, int32(0), domain.WalletMsg{}).
+		On("Produce", domain.TopicWalletCreated, mockery.Anything, eventMatching(domain.WalletEvent{})).
 		Once().
 		Return(nil)
 
@@ -406,6 +421,20 @@ func TestUpdate(t *testing.T) {
 	if walletResp == nil {
 		t.Fatal("cannot create wallet")
 	}
+	producerMock := walletAction.producer.(*mock.ProducerMock)
+	// The event create failed to publish is still in the outbox and goes first
+	producerMock.
+		On("Produce", domain.TopicWalletCreated, mockery.Anything, eventMatching(domain.WalletEvent{})).
+		Once().
+		Return(nil)
+	producerMock.
+		On("Produce", domain.TopicWalletUpdated, walletResp.ID, eventMatching(domain.WalletEvent{
+			WalletID: walletResp.ID,
+			Name:     "sss",
+			Status:   domain.StatusActive,
+		})).
+		Once().
+		Return(nil)
 
 	testCases := []struct {
 		id       string
@@ -459,17 +488,19 @@ func TestUpdate(t *testing.T) {
 			}
 		}
 	}
+	relayNotified(walletAction)
+	mockery.AssertExpectationsForObjects(t, producerMock)
 }
 
 func create(t *testing.T) (*WalletAction, *createWalletResp) {
 	e := echo.New()
 	producerMock := &mock.ProducerMock{}
 	loggerMock := &mock.LoggerMock{}
-	walletAction := NewWalletAction(producerMock, loggerMock)
+	walletAction := NewWalletAction(&domain.Config{}, storage.NewMemoryRepository(), &mock.RateProviderMock{}, producerMock, loggerMock)
 
 	producerOk := func() {
 		producerMock.
-			On("Send", domain.TopicWalletCreated, int32(0), domain.WalletMsg{}).
+			On("Produce", domain.TopicWalletCreated, mockery.Anything, eventMatching(domain.WalletEvent{})).
 			Once().
 			Return(nil)
 	}
@@ -500,13 +531,13 @@ func create(t *testing.T) (*WalletAction, *createWalletResp) {
 			respCode: http.StatusOK,
 			producerCallback: func() {
 				producerMock.
-					On("Send", domain.TopicWalletCreated, int32(0), domain.WalletMsg{}).
+					On("Produce", domain.TopicWalletCreated, mockery.Anything, eventMatching(domain.WalletEvent{})).
 					Once().
 					Return(errors.New("publish failed"))
 			},
 			logCallback: func() {
 				loggerMock.
-					On("Error", "cannot publish create event to kafka", []zap.Field{zap.Error(errors.New("publish failed"))}).
+					On("Error", "cannot publish event to topic "+domain.TopicWalletCreated, []zap.Field{zap.Error(errors.New("publish failed"))}).
 					Once().
 					Return(nil)
 			},
@@ -540,7 +571,151 @@ func create(t *testing.T) (*WalletAction, *createWalletResp) {
 				wallet = resp
 			}
 		}
+		relayNotified(walletAction)
 		mockery.AssertExpectationsForObjects(t, producerMock, loggerMock)
 	}
 	return walletAction, wallet
 }
+
+func TestTransfer(t *testing.T) {
+	e := echo.New()
+	repo := storage.NewMemoryRepository()
+	producerMock := &mock.ProducerMock{}
+	loggerMock := &mock.LoggerMock{}
+	ratesMock := &mock.RateProviderMock{}
+	walletAction := NewWalletAction(&domain.Config{}, repo, ratesMock, producerMock, loggerMock)
+
+	assert.NoError(t, repo.Update(func(tx domain.WalletTx) error {
+		w := domain.NewWallet("usd", "", "USD")
+		w.Balance = decimal.NewFromInt(100)
+		if err := tx.Put(w); err != nil {
+			return err
+		}
+		for id, currency := range map[string]string{"usd2": "USD", "eur": "EUR", "gbp": "GBP"} {
+			if err := tx.Put(domain.NewWallet(id, "", currency)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}))
+
+	ratesMock.On("Rate", "USD", "EUR").Return(decimal.NewFromFloat(0.8), nil)
+	ratesMock.On("Rate", "USD", "GBP").Return(decimal.Zero, domain.ErrRateNotFound)
+
+	testCases := []struct {
+		req              string
+		err              string
+		respCode         int
+		converted        string
+		producerCallback func()
+	}{
+		{
+			req:      `{"amount": 10, "transfer_to": "usd2"}`,
+			respCode: http.StatusOK,
+			producerCallback: func() {
+				producerMock.
+					On(
+						"Produce",
+						domain.TopicWalletTransferred,
+						"usd",
+						eventMatching(domain.WalletEvent{
+							WalletID:       "usd",
+							CounterpartyID: "usd2",
+							Amount:         decimal.NewFromInt(10),
+							Currency:       "USD",
+							Balance:        decimalPtr(decimal.NewFromInt(90)),
+
+							CounterpartyAmount:  decimalPtr(decimal.NewFromInt(10)),
+							CounterpartyBalance: decimalPtr(decimal.NewFromInt(10)),
+						}),
+					).
+					Once().
+					Return(nil)
+			},
+		},
+		{
+			req:       `{"amount": 12.34, "transfer_to": "eur"}`,
+			respCode:  http.StatusOK,
+			converted: "9.87",
+			producerCallback: func() {
+				producerMock.
+					On(
+						"Produce",
+						domain.TopicWalletTransferred,
+						"usd",
+						eventMatching(domain.WalletEvent{
+							WalletID:       "usd",
+							CounterpartyID: "eur",
+							Amount:         decimal.NewFromFloat(12.34),
+							Currency:       "USD",
+							Balance:        decimalPtr(decimal.NewFromFloat(77.66)),
+
+							CounterpartyAmount:  decimalPtr(decimal.NewFromFloat(9.87)),
+							CounterpartyBalance: decimalPtr(decimal.NewFromFloat(9.87)),
+						}),
+					).
+					Once().
+					Return(nil)
+			},
+		},
+		{
+			req:              `{"amount": 1, "transfer_to": "gbp"}`,
+			respCode:         http.StatusBadRequest,
+			err:              "exchange rate not found",
+			producerCallback: func() {},
+		},
+		{
+			req:              `{"amount": 1.001, "transfer_to": "eur"}`,
+			respCode:         http.StatusBadRequest,
+			err:              "wrong amount scale",
+			producerCallback: func() {},
+		},
+		{
+			req:              `{"amount": 1, "transfer_to": "usd"}`,
+			respCode:         http.StatusBadRequest,
+			err:              "cannot transfer to the same wallet",
+			producerCallback: func() {},
+		},
+		{
+			req:              `{"amount": 1, "transfer_to": "666"}`,
+			respCode:         http.StatusNotFound,
+			err:              "target wallet not found",
+			producerCallback: func() {},
+		},
+	}
+
+	for _, tc := range testCases {
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(tc.req))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		c.SetPath("/wallets/:id/transfer")
+		c.SetParamNames("id")
+		c.SetParamValues("usd")
+
+		tc.producerCallback()
+
+		if assert.NoError(t, walletAction.Transfer(c)) {
+			assert.Equal(t, tc.respCode, rec.Code)
+			var resp financeResp
+			assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+			assert.Equal(t, tc.err, resp.Err)
+			if tc.converted != "" && assert.NotNil(t, resp.ConvertedAmount) {
+				assert.Equal(t, tc.converted, resp.ConvertedAmount.String())
+				assert.Equal(t, "0.8", resp.Rate.String())
+			}
+		}
+		relayNotified(walletAction)
+		mockery.AssertExpectationsForObjects(t, loggerMock, producerMock)
+	}
+
+	assert.NoError(t, repo.View(func(tx domain.WalletTx) error {
+		expected := map[string]string{"usd": "77.66", "usd2": "10", "eur": "9.87", "gbp": "0"}
+		for id, balance := range expected {
+			w, err := tx.Get(id)
+			assert.NoError(t, err)
+			assert.Equal(t, balance, w.Balance.String(), id)
+		}
+		return nil
+	}))
+}
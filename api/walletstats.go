@@ -0,0 +1,165 @@
+package api
+
+import (
+	"container/list"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/Kale-Grabovski/impay/domain"
+)
+
+type walletStatsResp struct {
+	ID             string                  `json:"id"`
+	Currency       string                  `json:"currency,omitempty"`
+	Lifetime       domain.WalletOperations `json:"lifetime"`
+	Window         domain.WalletOperations `json:"window"`
+	WindowSize     string                  `json:"window_size"`
+	LastActivityAt time.Time               `json:"last_activity_at"`
+	Err            string                  `json:"err_code,omitempty"`
+	Success        bool                    `json:"success"`
+}
+
+// GetWallet returns the lifetime stats of the wallet and the stats within the window
+// query param, a duration up to stats.walletWindow counted with an hour precision.
+// Wallets evicted from the projection are not found.
+func (s *StatsAction) GetWallet(c echo.Context) (err error) {
+	window := s.walletWindow
+	if v := c.QueryParam("window"); v != "" {
+		window, err = time.ParseDuration(v)
+		if err != nil || window <= 0 || window > s.walletWindow {
+			return c.JSON(http.StatusBadRequest, walletStatsResp{
+				Err: "wrong window",
+			})
+		}
+	}
+
+	s.RLock()
+	defer s.RUnlock()
+	a := s.wallets.get(c.Param("id"))
+	if a == nil {
+		return c.JSON(http.StatusNotFound, walletStatsResp{
+			Err: "wallet not found",
+		})
+	}
+
+	resp := walletStatsResp{
+		ID:             a.WalletID,
+		Currency:       a.Currency,
+		Lifetime:       a.Lifetime,
+		WindowSize:     window.String(),
+		LastActivityAt: a.LastActivityAt,
+		Success:        true,
+	}
+	cutoff := s.now().Add(-window)
+	for start, o := range a.Hours {
+		if time.Unix(start, 0).Add(time.Hour).After(cutoff) {
+			resp.Window.Add(o)
+		}
+	}
+	return c.JSON(http.StatusOK, resp)
+}
+
+// recordWallet applies fn to the lifetime and the hourly operations of the wallet
+// and marks it as the most recently active. A nil fn only updates the activity time.
+// Must be called under lock.
+func (s *StatsAction) recordWallet(id, currency string, at time.Time, fn func(o *domain.WalletOperations)) {
+	if id == "" {
+		return
+	}
+	a := s.wallets.touch(id)
+	if currency != "" {
+		a.Currency = currency
+	}
+	if at.After(a.LastActivityAt) {
+		a.LastActivityAt = at
+	}
+	if fn == nil {
+		return
+	}
+	fn(&a.Lifetime)
+
+	cutoff := s.now().Add(-s.walletWindow)
+	hour := at.Truncate(time.Hour)
+	if !hour.Add(time.Hour).After(cutoff) {
+		return
+	}
+	if a.Hours == nil {
+		a.Hours = make(map[int64]*domain.WalletOperations)
+	}
+	o, ok := a.Hours[hour.Unix()]
+	if !ok {
+		for start := range a.Hours {
+			if !time.Unix(start, 0).Add(time.Hour).After(cutoff) {
+				delete(a.Hours, start)
+			}
+		}
+		o = &domain.WalletOperations{}
+		a.Hours[hour.Unix()] = o
+	}
+	fn(o)
+}
+
+// walletLRU keeps the activity of at most max wallets, the least recently
+// active wallet is evicted first.
+type walletLRU struct {
+	max int
+	// order holds *domain.WalletActivity, the most recently active at the back
+	order   *list.List
+	wallets map[string]*list.Element
+}
+
+func newWalletLRU(max int) *walletLRU {
+	return &walletLRU{
+		max:     max,
+		order:   list.New(),
+		wallets: make(map[string]*list.Element),
+	}
+}
+
+func (l *walletLRU) get(id string) *domain.WalletActivity {
+	el, ok := l.wallets[id]
+	if !ok {
+		return nil
+	}
+	return el.Value.(*domain.WalletActivity)
+}
+
+// touch returns the activity of the wallet as the most recent one, a missing wallet is added.
+func (l *walletLRU) touch(id string) *domain.WalletActivity {
+	if el, ok := l.wallets[id]; ok {
+		l.order.MoveToBack(el)
+		return el.Value.(*domain.WalletActivity)
+	}
+
+	a := &domain.WalletActivity{WalletID: id}
+	l.wallets[id] = l.order.PushBack(a)
+	if l.order.Len() > l.max {
+		oldest := l.order.Front()
+		l.order.Remove(oldest)
+		delete(l.wallets, oldest.Value.(*domain.WalletActivity).WalletID)
+	}
+	return a
+}
+
+// all returns the wallets from the least recently active.
+func (l *walletLRU) all() []*domain.WalletActivity {
+	wallets := make([]*domain.WalletActivity, 0, l.order.Len())
+	for el := l.order.Front(); el != nil; el = el.Next() {
+		wallets = append(wallets, el.Value.(*domain.WalletActivity))
+	}
+	return wallets
+}
+
+func copyWalletActivity(a *domain.WalletActivity) *domain.WalletActivity {
+	c := *a
+	if a.Hours != nil {
+		c.Hours = make(map[int64]*domain.WalletOperations, len(a.Hours))
+		for start, o := range a.Hours {
+			ops := *o
+			c.Hours[start] = &ops
+		}
+	}
+	return &c
+}
@@ -0,0 +1,108 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/Kale-Grabovski/impay/api/mock"
+	"github.com/Kale-Grabovski/impay/domain"
+)
+
+func TestStatsWallet(t *testing.T) {
+	e := echo.New()
+	cfg := &domain.Config{}
+	cfg.Stats.MaxWallets = 2
+	cfg.Stats.WalletWindow = 3 * time.Hour
+	statsAction := NewStatsAction(cfg, &mock.ConsumerMock{}, &mock.LoggerMock{})
+	hour := time.Date(2026, 10, 17, 10, 0, 0, 0, time.UTC)
+	statsAction.now = func() time.Time {
+		return hour.Add(30 * time.Minute)
+	}
+
+	converted := decimal.NewFromInt(4)
+	handlers := statsAction.handlers()
+	events := []struct {
+		topic string
+		event domain.WalletEvent
+	}{
+		{domain.TopicWalletCreated, domain.WalletEvent{WalletID: "evicted", Currency: "USD", OccurredAt: hour.Add(-5 * time.Hour)}},
+		{domain.TopicWalletCreated, domain.WalletEvent{WalletID: "a", Currency: "USD", OccurredAt: hour.Add(-5 * time.Hour)}},
+		// Beyond the window, counted in lifetime only
+		{domain.TopicWalletDeposited, domain.WalletEvent{WalletID: "a", Currency: "USD", Amount: decimal.NewFromInt(10), OccurredAt: hour.Add(-4 * time.Hour)}},
+		{domain.TopicWalletDeposited, domain.WalletEvent{WalletID: "a", Currency: "USD", Amount: decimal.NewFromInt(5), OccurredAt: hour.Add(-90 * time.Minute)}},
+		{domain.TopicWalletWithdrawn, domain.WalletEvent{WalletID: "a", Currency: "USD", Amount: decimal.NewFromInt(1), OccurredAt: hour.Add(5 * time.Minute)}},
+		{domain.TopicWalletTransferred, domain.WalletEvent{WalletID: "a", CounterpartyID: "b", Currency: "USD", Amount: decimal.NewFromInt(2), CounterpartyAmount: &converted, OccurredAt: hour.Add(10 * time.Minute)}},
+		{domain.TopicWalletTransferred, domain.WalletEvent{WalletID: "b", CounterpartyID: "a", Currency: "USD", Amount: decimal.NewFromInt(3), OccurredAt: hour.Add(20 * time.Minute)}},
+	}
+	for _, ev := range events {
+		e := ev.event
+		handlers[ev.topic](&e)
+	}
+
+	get := func(id, query string) (int, walletStatsResp) {
+		req := httptest.NewRequest(http.MethodGet, "/stats/wallets/"+id+"?"+query, nil)
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		c.SetParamNames("id")
+		c.SetParamValues(id)
+
+		var resp walletStatsResp
+		assert.NoError(t, statsAction.GetWallet(c))
+		assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+		return rec.Code, resp
+	}
+
+	code, resp := get("a", "")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "USD", resp.Currency)
+	assert.Equal(t, "3h0m0s", resp.WindowSize)
+	assert.True(t, resp.LastActivityAt.Equal(hour.Add(20*time.Minute)))
+	assert.True(t, resp.Lifetime.Deposited.Equal(decimal.NewFromInt(15)))
+	assert.Equal(t, int64(2), resp.Lifetime.Deposits)
+	assert.True(t, resp.Window.Deposited.Equal(decimal.NewFromInt(5)))
+	assert.Equal(t, int64(1), resp.Window.Deposits)
+	assert.True(t, resp.Window.Withdrawn.Equal(decimal.NewFromInt(1)))
+	assert.True(t, resp.Window.TransferredOut.Equal(decimal.NewFromInt(2)))
+	assert.True(t, resp.Window.TransferredIn.Equal(decimal.NewFromInt(3)))
+	assert.Equal(t, int64(1), resp.Window.TransfersIn)
+
+	// The current hour only
+	code, resp = get("a", "window=30m")
+	assert.Equal(t, http.StatusOK, code)
+	assert.True(t, resp.Window.Deposited.IsZero())
+	assert.True(t, resp.Window.Withdrawn.Equal(decimal.NewFromInt(1)))
+
+	// The receiver is credited with the converted amount
+	code, resp = get("b", "")
+	assert.Equal(t, http.StatusOK, code)
+	assert.True(t, resp.Lifetime.TransferredIn.Equal(converted))
+	assert.True(t, resp.Lifetime.TransferredOut.Equal(decimal.NewFromInt(3)))
+
+	code, resp = get("evicted", "")
+	assert.Equal(t, http.StatusNotFound, code)
+	assert.Equal(t, "wallet not found", resp.Err)
+
+	for _, query := range []string{"window=1d", "window=4h", "window=-1h"} {
+		code, resp = get("a", query)
+		assert.Equal(t, http.StatusBadRequest, code, query)
+		assert.Equal(t, "wrong window", resp.Err, query)
+	}
+
+	// Wallets survive a checkpoint in the same order, the receiver of the last transfer is the most recent
+	stats := statsAction.stats()
+	if assert.Len(t, stats.Wallets, 2) {
+		assert.Equal(t, "b", stats.Wallets[0].WalletID)
+	}
+	restored := NewStatsAction(cfg, &mock.ConsumerMock{}, &mock.LoggerMock{})
+	restored.setStats(stats)
+	restored.wallets.touch("c")
+	assert.Nil(t, restored.wallets.get("b"))
+	assert.NotNil(t, restored.wallets.get("a"))
+}
@@ -0,0 +1,169 @@
+// Package auth authenticates API callers with static API keys and JWTs.
+package auth
+
+import (
+	"bytes"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+	"gopkg.in/yaml.v3"
+
+	"github.com/Kale-Grabovski/impay/domain"
+)
+
+// minHMACSecret is the shortest HS256 secret accepted, as long as the hash output.
+const minHMACSecret = 32
+
+var (
+	ErrNoCredentials      = errors.New("no credentials")
+	ErrInvalidCredentials = errors.New("invalid credentials")
+)
+
+// Authenticator checks the API key or the bearer JWT of a request.
+type Authenticator struct {
+	keys    map[string]domain.Principal
+	hmacKey []byte
+	rsaKey  *rsa.PublicKey
+	parser  *jwt.Parser
+}
+
+type claims struct {
+	jwt.RegisteredClaims
+	Admin bool `json:"admin,omitempty"`
+}
+
+// NewAuthenticator loads the API keys and the JWT verification keys. Tokens
+// are accepted only for the algorithms with a key configured and must expire.
+func NewAuthenticator(cfg *domain.Config) (*Authenticator, error) {
+	keys, err := loadAPIKeys(cfg)
+	if err != nil {
+		return nil, err
+	}
+	a := &Authenticator{keys: keys}
+
+	var methods []string
+	if cfg.Auth.JWT.HMACSecretFile != "" {
+		secret, err := os.ReadFile(cfg.Auth.JWT.HMACSecretFile)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read HMAC secret: %w", err)
+		}
+		a.hmacKey = bytes.TrimSpace(secret)
+		if len(a.hmacKey) < minHMACSecret {
+			return nil, fmt.Errorf("HMAC secret is shorter than %d bytes", minHMACSecret)
+		}
+		methods = append(methods, jwt.SigningMethodHS256.Alg())
+	}
+	if cfg.Auth.JWT.RSAPublicKeyFile != "" {
+		pem, err := os.ReadFile(cfg.Auth.JWT.RSAPublicKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read RSA public key: %w", err)
+		}
+		if a.rsaKey, err = jwt.ParseRSAPublicKeyFromPEM(pem); err != nil {
+			return nil, fmt.Errorf("cannot parse RSA public key: %w", err)
+		}
+		methods = append(methods, jwt.SigningMethodRS256.Alg())
+	}
+
+	leeway := cfg.Auth.JWT.Leeway
+	if leeway <= 0 {
+		leeway = domain.DefaultJWTLeeway
+	}
+	opts := []jwt.ParserOption{
+		jwt.WithValidMethods(methods),
+		jwt.WithExpirationRequired(),
+		jwt.WithLeeway(leeway),
+	}
+	if cfg.Auth.JWT.Issuer != "" {
+		opts = append(opts, jwt.WithIssuer(cfg.Auth.JWT.Issuer))
+	}
+	if cfg.Auth.JWT.Audience != "" {
+		opts = append(opts, jwt.WithAudience(cfg.Auth.JWT.Audience))
+	}
+	a.parser = jwt.NewParser(opts...)
+	return a, nil
+}
+
+// Authenticate returns the principal of the X-API-Key header or of the bearer
+// token in the Authorization header.
+func (s *Authenticator) Authenticate(r *http.Request) (*domain.Principal, error) {
+	if key := r.Header.Get(domain.HeaderAPIKey); key != "" {
+		p, ok := s.keys[HashAPIKey(key)]
+		if !ok {
+			return nil, ErrInvalidCredentials
+		}
+		return &p, nil
+	}
+
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return nil, ErrNoCredentials
+	}
+	if s.hmacKey == nil && s.rsaKey == nil {
+		return nil, ErrInvalidCredentials
+	}
+	c := &claims{}
+	if _, err := s.parser.ParseWithClaims(strings.TrimSpace(token), c, s.key); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidCredentials, err)
+	}
+	if c.Subject == "" {
+		return nil, fmt.Errorf("%w: no subject", ErrInvalidCredentials)
+	}
+	return &domain.Principal{Subject: c.Subject, Admin: c.Admin}, nil
+}
+
+// key returns the verification key of the token algorithm.
+func (s *Authenticator) key(token *jwt.Token) (any, error) {
+	switch token.Method.(type) {
+	case *jwt.SigningMethodHMAC:
+		return s.hmacKey, nil
+	case *jwt.SigningMethodRSA:
+		return s.rsaKey, nil
+	default:
+		return nil, fmt.Errorf("unexpected signing method %s", token.Method.Alg())
+	}
+}
+
+// HashAPIKey returns the SHA-256 hex hash the API key is stored as.
+func HashAPIKey(key string) string {
+	h := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(h[:])
+}
+
+// loadAPIKeys indexes the keys of the config and of the keys file by hash.
+func loadAPIKeys(cfg *domain.Config) (map[string]domain.Principal, error) {
+	keys := append([]domain.APIKey(nil), cfg.Auth.APIKeys...)
+	if cfg.Auth.APIKeysFile != "" {
+		data, err := os.ReadFile(cfg.Auth.APIKeysFile)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read API keys: %w", err)
+		}
+		var fileKeys []domain.APIKey
+		if err = yaml.Unmarshal(data, &fileKeys); err != nil {
+			return nil, fmt.Errorf("cannot parse API keys: %w", err)
+		}
+		keys = append(keys, fileKeys...)
+	}
+
+	principals := make(map[string]domain.Principal, len(keys))
+	for _, k := range keys {
+		hash := strings.ToLower(k.Hash)
+		if k.Subject == "" {
+			return nil, errors.New("API key without subject")
+		}
+		if b, err := hex.DecodeString(hash); err != nil || len(b) != sha256.Size {
+			return nil, fmt.Errorf("API key of %s is not a SHA-256 hex hash", k.Subject)
+		}
+		if _, ok := principals[hash]; ok {
+			return nil, fmt.Errorf("API key of %s is duplicated", k.Subject)
+		}
+		principals[hash] = domain.Principal{Subject: k.Subject, Admin: k.Admin}
+	}
+	return principals, nil
+}
@@ -0,0 +1,138 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/Kale-Grabovski/impay/domain"
+)
+
+const secret = "0123456789abcdef0123456789abcdef"
+
+func writeFile(t *testing.T, name string, data []byte) string {
+	path := filepath.Join(t.TempDir(), name)
+	assert.NoError(t, os.WriteFile(path, data, 0o600))
+	return path
+}
+
+func request(header, value string) *http.Request {
+	req := httptest.NewRequest(http.MethodGet, "/wallets", nil)
+	if header != "" {
+		req.Header.Set(header, value)
+	}
+	return req
+}
+
+func sign(t *testing.T, method jwt.SigningMethod, key any, c jwt.MapClaims) string {
+	token, err := jwt.NewWithClaims(method, c).SignedString(key)
+	assert.NoError(t, err)
+	return token
+}
+
+func TestAPIKeys(t *testing.T) {
+	cfg := &domain.Config{}
+	cfg.Auth.APIKeys = []domain.APIKey{{Subject: "alice", Hash: strings.ToUpper(HashAPIKey("alice-key"))}}
+	cfg.Auth.APIKeysFile = writeFile(t, "keys.yaml",
+		[]byte("- subject: ops\n  hash: "+HashAPIKey("ops-key")+"\n  admin: true\n"))
+	a, err := NewAuthenticator(cfg)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	p, err := a.Authenticate(request(domain.HeaderAPIKey, "alice-key"))
+	assert.NoError(t, err)
+	assert.Equal(t, &domain.Principal{Subject: "alice"}, p)
+	p, err = a.Authenticate(request(domain.HeaderAPIKey, "ops-key"))
+	assert.NoError(t, err)
+	assert.Equal(t, &domain.Principal{Subject: "ops", Admin: true}, p)
+
+	_, err = a.Authenticate(request(domain.HeaderAPIKey, "other-key"))
+	assert.ErrorIs(t, err, ErrInvalidCredentials)
+	_, err = a.Authenticate(request("", ""))
+	assert.ErrorIs(t, err, ErrNoCredentials)
+	// Without JWT keys no token is accepted
+	_, err = a.Authenticate(request("Authorization", "Bearer "+sign(t, jwt.SigningMethodHS256, []byte(secret), jwt.MapClaims{
+		"sub": "alice",
+		"exp": time.Now().Add(time.Hour).Unix(),
+	})))
+	assert.ErrorIs(t, err, ErrInvalidCredentials)
+
+	// Keys are stored as hashes only
+	cfg.Auth.APIKeysFile = ""
+	cfg.Auth.APIKeys = []domain.APIKey{{Subject: "alice", Hash: "alice-key"}}
+	_, err = NewAuthenticator(cfg)
+	assert.EqualError(t, err, "API key of alice is not a SHA-256 hex hash")
+}
+
+func TestJWT(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if !assert.NoError(t, err) {
+		return
+	}
+	der, err := x509.MarshalPKIXPublicKey(&rsaKey.PublicKey)
+	assert.NoError(t, err)
+
+	cfg := &domain.Config{}
+	cfg.Auth.JWT.HMACSecretFile = writeFile(t, "secret", []byte(secret+"\n"))
+	cfg.Auth.JWT.RSAPublicKeyFile = writeFile(t, "key.pem", pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+	cfg.Auth.JWT.Issuer = "impay-test"
+	a, err := NewAuthenticator(cfg)
+	if !assert.NoError(t, err) {
+		return
+	}
+	bearer := func(token string) *http.Request {
+		return request("Authorization", "Bearer "+token)
+	}
+	valid := func() jwt.MapClaims {
+		return jwt.MapClaims{"sub": "alice", "iss": "impay-test", "exp": time.Now().Add(time.Hour).Unix()}
+	}
+
+	p, err := a.Authenticate(bearer(sign(t, jwt.SigningMethodHS256, []byte(secret), valid())))
+	assert.NoError(t, err)
+	assert.Equal(t, &domain.Principal{Subject: "alice"}, p)
+
+	admin := valid()
+	admin["admin"] = true
+	p, err = a.Authenticate(bearer(sign(t, jwt.SigningMethodRS256, rsaKey, admin)))
+	assert.NoError(t, err)
+	assert.Equal(t, &domain.Principal{Subject: "alice", Admin: true}, p)
+
+	expired := valid()
+	expired["exp"] = time.Now().Add(-time.Hour).Unix()
+	noExpiration := valid()
+	delete(noExpiration, "exp")
+	noSubject := valid()
+	delete(noSubject, "sub")
+	otherIssuer := valid()
+	otherIssuer["iss"] = "other"
+	for name, token := range map[string]string{
+		"expired":       sign(t, jwt.SigningMethodHS256, []byte(secret), expired),
+		"no expiration": sign(t, jwt.SigningMethodHS256, []byte(secret), noExpiration),
+		"no subject":    sign(t, jwt.SigningMethodHS256, []byte(secret), noSubject),
+		"other issuer":  sign(t, jwt.SigningMethodHS256, []byte(secret), otherIssuer),
+		"wrong secret":  sign(t, jwt.SigningMethodHS256, []byte(strings.Repeat("x", 32)), valid()),
+		"other method":  sign(t, jwt.SigningMethodHS512, []byte(secret), valid()),
+		"unsigned":      sign(t, jwt.SigningMethodNone, jwt.UnsafeAllowNoneSignatureType, valid()),
+		// The public key must not pass for an HMAC secret
+		"key confusion": sign(t, jwt.SigningMethodHS256, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), valid()),
+	} {
+		_, err = a.Authenticate(bearer(token))
+		assert.ErrorIs(t, err, ErrInvalidCredentials, name)
+	}
+
+	cfg.Auth.JWT.HMACSecretFile = writeFile(t, "short", []byte("short"))
+	_, err = NewAuthenticator(cfg)
+	assert.EqualError(t, err, "HMAC secret is shorter than 32 bytes")
+}
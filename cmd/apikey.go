@@ -0,0 +1,31 @@
+package cmd
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"github.com/Kale-Grabovski/impay/auth"
+)
+
+const apiKeyBytes = 32
+
+var apiKeyCmd = &cobra.Command{
+	Use:   "apikey",
+	Short: "Generate an API key and the hash to put in auth.apiKeys",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		b := make([]byte, apiKeyBytes)
+		if _, err := rand.Read(b); err != nil {
+			return fmt.Errorf("cannot generate API key: %w", err)
+		}
+		key := hex.EncodeToString(b)
+		fmt.Printf("key  %s\nhash %s\n", key, auth.HashAPIKey(key))
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(apiKeyCmd)
+}
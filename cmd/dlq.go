@@ -0,0 +1,78 @@
+package cmd
+
+import (
+	"fmt"
+	"time"
+
+	kafkaBase "github.com/confluentinc/confluent-kafka-go/v2/kafka"
+	"github.com/spf13/cobra"
+
+	"github.com/Kale-Grabovski/impay/domain"
+	"github.com/Kale-Grabovski/impay/kafka"
+)
+
+var dlqIdle time.Duration
+
+var dlqCmd = &cobra.Command{
+	Use:   "dlq",
+	Short: "Inspect messages the stats service failed to process",
+}
+
+var dlqListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List dead letters not re-driven yet",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		defer diContainer.DeleteWithSubContainers()
+		return listDeadLetters()
+	},
+}
+
+var dlqRedriveCmd = &cobra.Command{
+	Use:   "redrive",
+	Short: "Send dead letters back to their topics",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		defer diContainer.DeleteWithSubContainers()
+		return redriveDeadLetters()
+	},
+}
+
+func init() {
+	dlqCmd.PersistentFlags().DurationVar(&dlqIdle, "idle", 5*time.Second, "stop after no message arrives for this long")
+	dlqCmd.AddCommand(dlqListCmd, dlqRedriveCmd)
+	rootCmd.AddCommand(dlqCmd)
+}
+
+func listDeadLetters() error {
+	cfg := diContainer.Get("config").(*domain.Config)
+	consumer := diContainer.Get("kafka.dlqConsumer").(*kafkaBase.Consumer)
+
+	count := 0
+	err := kafka.ReadDeadLetters(cfg, consumer, dlqIdle, func(d *kafka.DeadLetter) error {
+		count++
+		fmt.Printf("%d\t%s[%d]@%d\t%s\t%s\n", d.Offset, d.Topic, d.Partition, d.SourceOffset, d.Error, d.Value)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%d dead letters\n", count)
+	return nil
+}
+
+func redriveDeadLetters() error {
+	cfg := diContainer.Get("config").(*domain.Config)
+	consumer := diContainer.Get("kafka.dlqConsumer").(*kafkaBase.Consumer)
+	producer := diContainer.Get("kafka.producer").(*kafka.Producer)
+	defer producer.Close()
+
+	count := 0
+	err := kafka.ReadDeadLetters(cfg, consumer, dlqIdle, func(d *kafka.DeadLetter) error {
+		if err := kafka.Redrive(producer, consumer, d); err != nil {
+			return err
+		}
+		count++
+		return nil
+	})
+	fmt.Printf("%d dead letters re-driven\n", count)
+	return err
+}
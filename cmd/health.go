@@ -0,0 +1,18 @@
+package cmd
+
+import (
+	"github.com/labstack/echo/v4"
+
+	"github.com/Kale-Grabovski/impay/api"
+	"github.com/Kale-Grabovski/impay/domain"
+)
+
+// serveHealth exposes the liveness and readiness probes of the server with the readiness checks.
+func serveHealth(e *echo.Echo, checks map[string]domain.HealthCheck) {
+	healthApi := diContainer.Get("api.health").(*api.HealthAction)
+	for name, check := range checks {
+		healthApi.AddCheck(name, check)
+	}
+	e.GET("/healthz", healthApi.Live)
+	e.GET("/readyz", healthApi.Ready)
+}
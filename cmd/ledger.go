@@ -0,0 +1,61 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"github.com/Kale-Grabovski/impay/domain"
+	"github.com/Kale-Grabovski/impay/ledger"
+)
+
+var ledgerCmd = &cobra.Command{
+	Use: "ledger",
+}
+
+var ledgerVerifyCmd = &cobra.Command{
+	Use:   "verify",
+	Short: "Check every wallet balance against its ledger entries",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		defer diContainer.DeleteWithSubContainers()
+		return verifyLedger()
+	},
+}
+
+func init() {
+	ledgerCmd.AddCommand(ledgerVerifyCmd)
+	rootCmd.AddCommand(ledgerCmd)
+}
+
+func verifyLedger() error {
+	repo := diContainer.Get("storage.wallets").(domain.WalletRepository)
+
+	mismatches := 0
+	err := repo.View(func(tx domain.WalletTx) error {
+		wallets, err := tx.GetAll()
+		if err != nil {
+			return err
+		}
+		for _, w := range wallets {
+			err = ledger.Verify(tx, w.ID)
+			if errors.Is(err, ledger.ErrBalanceMismatch) {
+				mismatches++
+				fmt.Println(err)
+				continue
+			}
+			if err != nil {
+				return err
+			}
+		}
+		fmt.Printf("%d wallets checked\n", len(wallets))
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if mismatches > 0 {
+		return fmt.Errorf("%d wallets do not match the ledger", mismatches)
+	}
+	return nil
+}
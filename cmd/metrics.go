@@ -0,0 +1,30 @@
+package cmd
+
+import (
+	"github.com/labstack/echo/v4"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"github.com/Kale-Grabovski/impay/api"
+	"github.com/Kale-Grabovski/impay/domain"
+)
+
+// serveMetrics exposes metrics at metrics.path of the server if they are enabled,
+// every collector registers its metrics on the registry.
+func serveMetrics(e *echo.Echo, collectors ...func(reg prometheus.Registerer)) {
+	cfg := diContainer.Get("config").(*domain.Config)
+	if !cfg.Metrics.Enabled {
+		return
+	}
+	path := cfg.Metrics.Path
+	if path == "" {
+		path = domain.DefaultMetricsPath
+	}
+
+	reg := diContainer.Get("metrics").(*prometheus.Registry)
+	e.Use(api.MetricsMiddleware(reg))
+	for _, collect := range collectors {
+		collect(reg)
+	}
+	e.GET(path, echo.WrapHandler(promhttp.HandlerFor(reg, promhttp.HandlerOpts{})))
+}
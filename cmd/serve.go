@@ -0,0 +1,78 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+
+	"github.com/Kale-Grabovski/impay/domain"
+)
+
+// serve runs the server until SIGINT or SIGTERM and then shuts down in order within
+// shutdownTimeout: the server stops accepting connections and drains in-flight requests,
+// the stop functions are called in order and the container closes the rest. Services
+// stopped here have no Close in the container, so each is closed once. A second signal
+// or the deadline passing exits at once.
+func serve(e *echo.Echo, port string, stops ...func()) {
+	cfg := diContainer.Get("config").(*domain.Config)
+	logger := diContainer.Get("logger").(domain.Logger)
+
+	failed := make(chan error, 1)
+	go func() {
+		err := e.Start(":" + port)
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			failed <- err
+		}
+	}()
+
+	c := make(chan os.Signal, 2)
+	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
+	exitCode := 0
+	select {
+	case <-c:
+	case err := <-failed:
+		logger.Error("cannot start server", zap.Error(err))
+		exitCode = 1
+	}
+
+	timeout := cfg.ShutdownTimeout
+	if timeout <= 0 {
+		timeout = domain.DefaultShutdownTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		logger.Info("shutting down", zap.Duration("timeout", timeout))
+		if err := e.Shutdown(ctx); err != nil {
+			logger.Error("cannot drain requests", zap.Error(err))
+		}
+		for _, stop := range stops {
+			stop()
+		}
+		if err := diContainer.DeleteWithSubContainers(); err != nil {
+			logger.Error("cannot close services", zap.Error(err))
+		}
+	}()
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+		logger.Error("shutdown deadline exceeded")
+		exitCode = 1
+	case <-c:
+		logger.Warn("shutdown interrupted")
+		exitCode = 1
+	}
+	if exitCode != 0 {
+		os.Exit(exitCode)
+	}
+}
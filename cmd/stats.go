@@ -1,15 +1,14 @@
 package cmd
 
 import (
-	"os"
-	"os/signal"
-	"syscall"
+	"fmt"
 
 	"github.com/labstack/echo/v4"
 	"github.com/spf13/cobra"
 
 	"github.com/Kale-Grabovski/impay/api"
 	"github.com/Kale-Grabovski/impay/domain"
+	"github.com/Kale-Grabovski/impay/kafka"
 )
 
 var statsCmd = &cobra.Command{
@@ -19,26 +18,68 @@ var statsCmd = &cobra.Command{
 	},
 }
 
+var statsRebuildCmd = &cobra.Command{
+	Use:   "rebuild",
+	Short: "Recompute stats from the earliest offsets into the checkpoint file",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		defer diContainer.DeleteWithSubContainers()
+		return rebuildStats()
+	},
+}
+
 func init() {
+	statsCmd.AddCommand(statsRebuildCmd)
 	rootCmd.AddCommand(statsCmd)
 }
 
 func runStatsApi() {
+	stopTracing := startTracing("impay-stats")
 	e := echo.New()
+	cfg := diContainer.Get("config").(*domain.Config)
+	logger := diContainer.Get("logger").(domain.Logger)
+	e.Use(api.RequestIDMiddleware(), api.AccessLogMiddleware(logger))
 	statsApi := diContainer.Get("api.stats").(*api.StatsAction)
 	e.GET("/stats/wallets", statsApi.Get)
+	e.GET("/stats/wallets/stream", statsApi.Stream)
+	e.GET("/stats/wallets/top", statsApi.GetTop)
+	e.GET("/stats/wallets/distribution", statsApi.GetDistribution)
+	e.GET("/stats/wallets/:id", statsApi.GetWallet)
+	consumer := diContainer.Get("kafka.consumer").(*kafka.Consumer)
+	producer := diContainer.Get("kafka.producer").(*kafka.Producer)
+	serveMetrics(e, statsApi.RegisterMetrics, consumer.RegisterMetrics, producer.RegisterMetrics)
+	// Error responses are written after the middlewares above, so they see the status
+	e.Use(api.ErrorMiddleware())
+
+	maxLag := cfg.Health.MaxLag
+	if maxLag <= 0 {
+		maxLag = domain.DefaultHealthMaxLag
+	}
+	serveHealth(e, map[string]domain.HealthCheck{
+		"kafka":    consumer.HealthCheck,
+		"consumer": consumer.CaughtUpCheck(maxLag),
+	})
+
+	// Streams never finish by themselves, they are closed once the server starts draining requests
+	e.Server.RegisterOnShutdown(statsApi.CloseStreams)
+	// The consumer is closed before the producer it sends dead letters with
+	serve(e, cfg.StatsPort, statsApi.CloseConsumers, func() {
+		producer.Close()
+	}, stopTracing)
+}
 
-	go func() {
-		cfg := diContainer.Get("config").(*domain.Config)
-		err := e.Start(":" + cfg.StatsPort)
-		if err != nil {
-			panic(err)
-		}
-	}()
+func rebuildStats() error {
+	cfg := diContainer.Get("config").(*domain.Config)
+	logger := diContainer.Get("logger").(domain.Logger)
+	replayer := diContainer.Get("kafka.statsReplayer").(*kafka.Replayer)
+	checkpoints := diContainer.Get("storage.statsCheckpoints").(domain.CheckpointStore)
 
-	c := make(chan os.Signal, 2)
-	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
-	<-c
+	// Rebuild reads events by itself, the consumer is not started
+	statsApi := api.NewStatsAction(cfg, nil, logger)
+	if err := statsApi.Rebuild(replayer, checkpoints); err != nil {
+		return err
+	}
 
-	diContainer.DeleteWithSubContainers()
+	fmt.Printf("total %s, active %s, inactive %s, deposited %s, withdrawn %s, transferred %s\n",
+		statsApi.Total, statsApi.Active, statsApi.Inactive, statsApi.Deposited, statsApi.Withdrawn, statsApi.Transferred)
+	return nil
 }
@@ -0,0 +1,33 @@
+package cmd
+
+import (
+	"context"
+
+	"go.uber.org/zap"
+
+	"github.com/Kale-Grabovski/impay/domain"
+	"github.com/Kale-Grabovski/impay/tracing"
+)
+
+// startTracing sets up tracing of the service, the returned function flushes
+// the spans and is meant to be the last one called on shutdown.
+func startTracing(service string) func() {
+	cfg := diContainer.Get("config").(*domain.Config)
+	logger := diContainer.Get("logger").(domain.Logger)
+	shutdown, err := tracing.Setup(cfg, service)
+	if err != nil {
+		logger.Panic("cannot set up tracing", zap.Error(err))
+	}
+
+	return func() {
+		timeout := cfg.ShutdownTimeout
+		if timeout <= 0 {
+			timeout = domain.DefaultShutdownTimeout
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		if err := shutdown(ctx); err != nil {
+			logger.Error("cannot flush spans", zap.Error(err))
+		}
+	}
+}
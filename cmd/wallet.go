@@ -1,15 +1,13 @@
 package cmd
 
 import (
-	"os"
-	"os/signal"
-	"syscall"
-
 	"github.com/labstack/echo/v4"
 	"github.com/spf13/cobra"
 
 	"github.com/Kale-Grabovski/impay/api"
+	"github.com/Kale-Grabovski/impay/auth"
 	"github.com/Kale-Grabovski/impay/domain"
+	"github.com/Kale-Grabovski/impay/kafka"
 )
 
 var walletCmd = &cobra.Command{
@@ -24,29 +22,48 @@ func init() {
 }
 
 func runWalletApi() {
+	stopTracing := startTracing("impay-wallet")
 	e := echo.New()
+	cfg := diContainer.Get("config").(*domain.Config)
+	logger := diContainer.Get("logger").(domain.Logger)
+	e.Use(api.RequestIDMiddleware(), api.AccessLogMiddleware(logger), api.TracingMiddleware())
 	walletApi := diContainer.Get("api.wallet").(*api.WalletAction)
 
-	e.GET("/wallets", walletApi.GetAll)
-	e.GET("/wallets/:id", walletApi.GetById)
-	e.POST("/wallets", walletApi.Create)
-	e.PUT("/wallets/:id", walletApi.Update)
-	e.DELETE("/wallets/:id", walletApi.Delete)
-	e.POST("/wallets/:id/deposit", walletApi.Deposit)
-	e.POST("/wallets/:id/withdraw", walletApi.Withdraw)
-	e.POST("/wallets/:id/transfer", walletApi.Transfer)
-
-	go func() {
-		cfg := diContainer.Get("config").(*domain.Config)
-		err := e.Start(":" + cfg.WalletPort)
-		if err != nil {
-			panic(err)
-		}
-	}()
-
-	c := make(chan os.Signal, 2)
-	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
-	<-c
-
-	diContainer.DeleteWithSubContainers()
+	// Health and metrics stay open, wallet and hold routes require credentials if auth is enabled
+	var middlewares []echo.MiddlewareFunc
+	if cfg.Auth.Enabled {
+		middlewares = append(middlewares, api.AuthMiddleware(diContainer.Get("auth").(*auth.Authenticator)))
+	}
+	wallets := e.Group("/wallets", middlewares...)
+	wallets.GET("", walletApi.GetAll)
+	wallets.GET("/:id", walletApi.GetById)
+	wallets.POST("", walletApi.Create)
+	wallets.PUT("/:id", walletApi.Update)
+	wallets.DELETE("/:id", walletApi.Delete)
+	wallets.POST("/:id/deposit", walletApi.Deposit)
+	wallets.POST("/:id/withdraw", walletApi.Withdraw)
+	wallets.POST("/:id/transfer", walletApi.Transfer)
+	wallets.GET("/:id/transactions", walletApi.Transactions)
+	wallets.POST("/:id/holds", walletApi.CreateHold)
+	holds := e.Group("/holds", middlewares...)
+	holds.POST("/:id/capture", walletApi.CaptureHold)
+	holds.POST("/:id/void", walletApi.VoidHold)
+	producer := diContainer.Get("kafka.producer").(*kafka.Producer)
+	serveMetrics(e, walletApi.RegisterMetrics, producer.RegisterMetrics)
+	// Error responses are written after the middlewares above, so they see the status
+	e.Use(api.ErrorMiddleware())
+
+	checks := map[string]domain.HealthCheck{
+		"kafka": producer.HealthCheck,
+	}
+	// Storage backends with their own check, like bolt, are checked too
+	if checker, ok := diContainer.Get("storage.wallets").(domain.HealthChecker); ok {
+		checks["storage"] = checker.HealthCheck
+	}
+	serveHealth(e, checks)
+
+	// The relay is stopped before the producer is flushed, events it did not publish stay in the outbox
+	serve(e, cfg.WalletPort, walletApi.Stop, func() {
+		producer.Close()
+	}, stopTracing)
 }
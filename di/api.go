@@ -9,27 +9,66 @@ import (
 )
 
 var ConfigApi = []di.Def{
+	{
+		Name:  "api.health",
+		Scope: di.App,
+		Build: func(ctx di.Container) (interface{}, error) {
+			cfg := ctx.Get("config").(*domain.Config)
+			logger := ctx.Get("logger").(domain.Logger)
+			return api.NewHealthAction(cfg, logger), nil
+		},
+	},
 	{
 		Name:  "api.wallet",
 		Scope: di.App,
 		Build: func(ctx di.Container) (interface{}, error) {
+			cfg := ctx.Get("config").(*domain.Config)
 			logger := ctx.Get("logger").(domain.Logger)
+			repo := ctx.Get("storage.wallets").(domain.WalletRepository)
+			rates := ctx.Get("fx.rates").(domain.RateProvider)
 			producer := ctx.Get("kafka.producer").(*kafka.Producer)
-			return api.NewWalletAction(producer, logger), nil
+			action := api.NewWalletAction(cfg, repo, rates, producer, logger)
+			if cfg.EventSourcing.Enabled {
+				source := ctx.Get("kafka.replayer").(*kafka.Replayer)
+				var snapshots domain.SnapshotStore
+				if cfg.EventSourcing.SnapshotFile != "" {
+					snapshots = ctx.Get("storage.snapshots").(domain.SnapshotStore)
+				}
+				if err := action.Replay(source, snapshots); err != nil {
+					return nil, err
+				}
+				if snapshots != nil {
+					action.StartSnapshots(source, snapshots, cfg.EventSourcing.SnapshotInterval)
+				}
+			}
+			action.StartJanitor()
+			action.StartRelay()
+			return action, nil
 		},
+		// Stopped by the wallet command before the producer is closed
 	},
 	{
 		Name:  "api.stats",
 		Scope: di.App,
 		Build: func(ctx di.Container) (interface{}, error) {
+			cfg := ctx.Get("config").(*domain.Config)
 			logger := ctx.Get("logger").(domain.Logger)
 			consumer := ctx.Get("kafka.consumer").(*kafka.Consumer)
-			action := api.NewStatsAction(consumer, logger)
-			return action, action.InitConsumers()
-		},
-		Close: func(obj interface{}) error {
-			obj.(*api.StatsAction).CloseConsumers()
-			return nil
+			action := api.NewStatsAction(cfg, consumer, logger)
+			if cfg.Stats.CheckpointFile == "" {
+				return action, action.InitConsumers()
+			}
+
+			checkpoints := ctx.Get("storage.statsCheckpoints").(domain.CheckpointStore)
+			if err := action.Restore(checkpoints); err != nil {
+				return nil, err
+			}
+			if err := action.InitConsumers(); err != nil {
+				return nil, err
+			}
+			action.StartCheckpoints(checkpoints, cfg.Stats.CheckpointInterval)
+			return action, nil
 		},
+		// Consumers are closed by the stats command before the producer is closed
 	},
 }
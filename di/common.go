@@ -1,6 +1,7 @@
 package di
 
 import (
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/sarulabs/di"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
@@ -26,4 +27,11 @@ var ConfigCommon = []di.Def{
 			return obj.(*zap.Logger).Sync()
 		},
 	},
+	{
+		Name:  "metrics",
+		Scope: di.App,
+		Build: func(ctx di.Container) (interface{}, error) {
+			return prometheus.NewRegistry(), nil
+		},
+	},
 }
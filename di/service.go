@@ -4,11 +4,57 @@ import (
 	kafkaBase "github.com/confluentinc/confluent-kafka-go/v2/kafka"
 	"github.com/sarulabs/di"
 
+	"github.com/Kale-Grabovski/impay/auth"
 	"github.com/Kale-Grabovski/impay/domain"
+	"github.com/Kale-Grabovski/impay/fx"
 	"github.com/Kale-Grabovski/impay/kafka"
+	"github.com/Kale-Grabovski/impay/storage"
 )
 
 var ConfigService = []di.Def{
+	{
+		Name:  "auth",
+		Scope: di.App,
+		Build: func(ctx di.Container) (interface{}, error) {
+			cfg := ctx.Get("config").(*domain.Config)
+			return auth.NewAuthenticator(cfg)
+		},
+	},
+	{
+		Name:  "storage.wallets",
+		Scope: di.App,
+		Build: func(ctx di.Container) (interface{}, error) {
+			cfg := ctx.Get("config").(*domain.Config)
+			return storage.NewWalletRepository(cfg)
+		},
+		Close: func(obj interface{}) error {
+			return obj.(domain.WalletRepository).Close()
+		},
+	},
+	{
+		Name:  "storage.snapshots",
+		Scope: di.App,
+		Build: func(ctx di.Container) (interface{}, error) {
+			cfg := ctx.Get("config").(*domain.Config)
+			return storage.NewFileSnapshotStore(cfg.EventSourcing.SnapshotFile)
+		},
+	},
+	{
+		Name:  "storage.statsCheckpoints",
+		Scope: di.App,
+		Build: func(ctx di.Container) (interface{}, error) {
+			cfg := ctx.Get("config").(*domain.Config)
+			return storage.NewFileCheckpointStore(cfg.Stats.CheckpointFile)
+		},
+	},
+	{
+		Name:  "fx.rates",
+		Scope: di.App,
+		Build: func(ctx di.Container) (interface{}, error) {
+			cfg := ctx.Get("config").(*domain.Config)
+			return fx.NewFileRateProvider(cfg.FX.RatesFile)
+		},
+	},
 	{
 		Name:  "kafka.producer",
 		Scope: di.App,
@@ -17,21 +63,61 @@ var ConfigService = []di.Def{
 			logger := ctx.Get("logger").(domain.Logger)
 			return kafka.NewProducer(cfg, logger)
 		},
+		// Closed by the commands using it, after the services sending with it are stopped
+	},
+	{
+		Name:  "kafka.dlqConsumer",
+		Scope: di.App,
+		Build: func(ctx di.Container) (interface{}, error) {
+			cfg := ctx.Get("config").(*domain.Config)
+			return kafka.NewDeadLetterConsumer(cfg)
+		},
 		Close: func(obj interface{}) error {
-			obj.(*kafka.Producer).Close()
-			return nil
+			return obj.(*kafkaBase.Consumer).Close()
 		},
 	},
 	{
-		Name:  "kafka.consumer",
+		Name:  "kafka.replayer",
 		Scope: di.App,
 		Build: func(ctx di.Container) (interface{}, error) {
 			cfg := ctx.Get("config").(*domain.Config)
-			logger := ctx.Get("logger").(domain.Logger)
-			return kafka.NewConsumer(cfg, logger), nil
+			consumer, err := kafka.NewReplayConsumer(cfg)
+			if err != nil {
+				return nil, err
+			}
+			return kafka.NewReplayer(consumer, kafka.EventSourcingTopics(cfg)), nil
 		},
 		Close: func(obj interface{}) error {
-			return obj.(*kafkaBase.Consumer).Close()
+			return obj.(*kafka.Replayer).Close()
+		},
+	},
+	{
+		Name:  "kafka.statsReplayer",
+		Scope: di.App,
+		Build: func(ctx di.Container) (interface{}, error) {
+			cfg := ctx.Get("config").(*domain.Config)
+			consumer, err := kafka.NewReplayConsumer(cfg)
+			if err != nil {
+				return nil, err
+			}
+			return kafka.NewReplayer(consumer, domain.WalletTopics), nil
+		},
+		Close: func(obj interface{}) error {
+			return obj.(*kafka.Replayer).Close()
+		},
+	},
+	{
+		Name:  "kafka.consumer",
+		Scope: di.App,
+		Build: func(ctx di.Container) (interface{}, error) {
+			cfg := ctx.Get("config").(*domain.Config)
+			logger := ctx.Get("logger").(domain.Logger)
+			producer := ctx.Get("kafka.producer").(*kafka.Producer)
+			base, err := kafka.NewBaseConsumer(cfg)
+			if err != nil {
+				return nil, err
+			}
+			return kafka.NewConsumer(cfg, base, producer, logger), nil
 		},
 	},
 }
@@ -0,0 +1,46 @@
+package domain
+
+import (
+	"context"
+	"time"
+)
+
+const (
+	// HeaderAPIKey carries a static API key, bearer JWTs come in Authorization
+	HeaderAPIKey = "X-API-Key"
+
+	DefaultJWTLeeway = 30 * time.Second
+)
+
+// APIKey is a static key of a principal, only the SHA-256 hex hash of the key is kept.
+type APIKey struct {
+	Subject string `yaml:"subject"`
+	Hash    string `yaml:"hash"`
+	Admin   bool   `yaml:"admin"`
+}
+
+// Principal is the authenticated caller. Admins may use every wallet, others
+// only the wallets they own.
+type Principal struct {
+	Subject string
+	Admin   bool
+}
+
+// CanUse tells if the principal may use the wallet. Without authentication
+// there is no principal and every wallet may be used.
+func (p *Principal) CanUse(w *Wallet) bool {
+	return p == nil || p.Admin || w.Owner == p.Subject
+}
+
+type principalKey struct{}
+
+// WithPrincipal returns the context of the request made by the principal.
+func WithPrincipal(ctx context.Context, p *Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, p)
+}
+
+// PrincipalFrom returns the principal of the request, nil if it is not authenticated.
+func PrincipalFrom(ctx context.Context) *Principal {
+	p, _ := ctx.Value(principalKey{}).(*Principal)
+	return p
+}
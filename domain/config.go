@@ -1,12 +1,114 @@
 package domain
 
-const EnvPrefix = "IMPAY"
+import "time"
+
+const (
+	EnvPrefix              = "IMPAY"
+	DefaultMetricsPath     = "/metrics"
+	DefaultShutdownTimeout = 15 * time.Second
+	// WalletMetricsTTL is how long the wallet counts are cached between scrapes
+	WalletMetricsTTL = 15 * time.Second
+
+	TracingNone   = "none"
+	TracingStdout = "stdout"
+	TracingFile   = "file"
+)
 
 type Config struct {
-	LogLevel   string `yaml:"logLevel"`
-	WalletPort string `yaml:"walletPort"`
-	StatsPort  string `yaml:"statsPort"`
-	Kafka      struct {
+	LogLevel        string `yaml:"logLevel"`
+	WalletPort      string `yaml:"walletPort"`
+	StatsPort       string `yaml:"statsPort"`
+	DefaultCurrency string `yaml:"defaultCurrency"`
+	// ShutdownTimeout bounds draining requests and closing the services on shutdown
+	ShutdownTimeout time.Duration `yaml:"shutdownTimeout"`
+	Kafka           struct {
 		Host string `yaml:"host"`
+		// Partitioner is the librdkafka partitioner used for message keys
+		Partitioner string `yaml:"partitioner"`
+		// SyncPublish makes handlers wait until their events are acknowledged
+		SyncPublish  bool          `yaml:"syncPublish"`
+		FlushTimeout time.Duration `yaml:"flushTimeout"`
+		GroupID      string        `yaml:"groupId"`
+		OffsetReset  string        `yaml:"offsetReset"`
+		CommitMode   string        `yaml:"commitMode"`
+		// TopicPattern is a regex starting with ^ to subscribe to instead of the handled topics
+		TopicPattern    string `yaml:"topicPattern"`
+		DeadLetterTopic string `yaml:"deadLetterTopic"`
 	} `yaml:"kafka"`
+	Storage struct {
+		Driver string `yaml:"driver"`
+		Path   string `yaml:"path"`
+	} `yaml:"storage"`
+	Idempotency struct {
+		TTL time.Duration `yaml:"ttl"`
+	} `yaml:"idempotency"`
+	Holds struct {
+		TTL time.Duration `yaml:"ttl"`
+	} `yaml:"holds"`
+	FX struct {
+		RatesFile string `yaml:"ratesFile"`
+	} `yaml:"fx"`
+	Stats struct {
+		// CheckpointFile keeps the stats with the consumed offsets, the stats
+		// service resumes from it instead of the consumer group offsets
+		CheckpointFile     string        `yaml:"checkpointFile"`
+		CheckpointInterval time.Duration `yaml:"checkpointInterval"`
+		// MaxWallets bounds the number of wallets with stats, the least
+		// recently active ones are evicted
+		MaxWallets int `yaml:"maxWallets"`
+		// WalletWindow is the longest window of per wallet stats
+		WalletWindow time.Duration `yaml:"walletWindow"`
+		// HistogramBounds are the upper bounds of amount histogram buckets
+		HistogramBounds []float64 `yaml:"histogramBounds"`
+		// StreamInterval is the shortest time between stats pushed to a stream
+		StreamInterval time.Duration `yaml:"streamInterval"`
+		// Retention is how long buckets of each granularity are kept
+		Retention struct {
+			Minute time.Duration `yaml:"minute"`
+			Hour   time.Duration `yaml:"hour"`
+			Day    time.Duration `yaml:"day"`
+		} `yaml:"retention"`
+	} `yaml:"stats"`
+	Health struct {
+		// Timeout bounds all readiness checks of a request
+		Timeout time.Duration `yaml:"timeout"`
+		// MaxLag is the lag of a partition up to which the stats consumer is ready
+		MaxLag int64 `yaml:"maxLag"`
+	} `yaml:"health"`
+	Metrics struct {
+		// Enabled exposes Prometheus metrics at Path of the service port
+		Enabled bool   `yaml:"enabled"`
+		Path    string `yaml:"path"`
+	} `yaml:"metrics"`
+	Tracing struct {
+		// Exporter of the spans is none, stdout or file at File
+		Exporter string `yaml:"exporter"`
+		File     string `yaml:"file"`
+		// SampleRatio of the traces started by the services, 1 if not set
+		SampleRatio float64 `yaml:"sampleRatio"`
+	} `yaml:"tracing"`
+	Auth struct {
+		// Enabled requires an API key or a bearer JWT on the wallet routes
+		Enabled bool `yaml:"enabled"`
+		// APIKeys are hashed keys, APIKeysFile is a YAML list of more keys in the same form
+		APIKeys     []APIKey `yaml:"apiKeys"`
+		APIKeysFile string   `yaml:"apiKeysFile"`
+		JWT         struct {
+			// HMACSecretFile holds the HS256 secret, RSAPublicKeyFile the PEM key of RS256 tokens
+			HMACSecretFile   string        `yaml:"hmacSecretFile"`
+			RSAPublicKeyFile string        `yaml:"rsaPublicKeyFile"`
+			Issuer           string        `yaml:"issuer"`
+			Audience         string        `yaml:"audience"`
+			Leeway           time.Duration `yaml:"leeway"`
+		} `yaml:"jwt"`
+	} `yaml:"auth"`
+	EventSourcing struct {
+		// Enabled rebuilds wallets from Kafka events on startup
+		Enabled bool `yaml:"enabled"`
+		// Topic is a compacted topic receiving the state of every changed wallet,
+		// the Wallet_* topics are replayed if it is not set
+		Topic            string        `yaml:"topic"`
+		SnapshotFile     string        `yaml:"snapshotFile"`
+		SnapshotInterval time.Duration `yaml:"snapshotInterval"`
+	} `yaml:"eventSourcing"`
 }
@@ -0,0 +1,55 @@
+package domain
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// EventSchemaVersion is the version of WalletEvent produced by the wallet service.
+// Version 0 stands for events published before the envelope was introduced.
+const EventSchemaVersion = 1
+
+var ErrEventVersion = errors.New("unsupported event schema version")
+
+// WalletEvent is the envelope of all Wallet_* events. Type is the topic name.
+// Name, Status and Balance are the wallet state after the operation,
+// CounterpartyAmount and CounterpartyBalance are the amount received by the transfer
+// target in its currency and its resulting balance.
+type WalletEvent struct {
+	Version             int              `json:"version"`
+	EventID             string           `json:"event_id,omitempty"`
+	Type                string           `json:"type,omitempty"`
+	WalletID            string           `json:"wallet_id,omitempty"`
+	CounterpartyID      string           `json:"counterparty_id,omitempty"`
+	Amount              decimal.Decimal  `json:"amount"`
+	Currency            string           `json:"currency,omitempty"`
+	Name                string           `json:"name,omitempty"`
+	Status              string           `json:"status,omitempty"`
+	Owner               string           `json:"owner,omitempty"`
+	Balance             *decimal.Decimal `json:"balance,omitempty"`
+	CounterpartyAmount  *decimal.Decimal `json:"counterparty_amount,omitempty"`
+	CounterpartyBalance *decimal.Decimal `json:"counterparty_balance,omitempty"`
+	TransactionID       uint64           `json:"transaction_id,omitempty"`
+	OccurredAt          time.Time        `json:"occurred_at"`
+}
+
+// ParseWalletEvent decodes an event consumed from topic. Events in the old
+// shape carry only amount and currency, they are returned with version 0
+// and the type taken from the topic.
+func ParseWalletEvent(topic string, payload []byte) (*WalletEvent, error) {
+	var e WalletEvent
+	if err := json.Unmarshal(payload, &e); err != nil {
+		return nil, fmt.Errorf("cannot unmarshal event: %w", err)
+	}
+	if e.Version > EventSchemaVersion {
+		return nil, fmt.Errorf("%w: %d", ErrEventVersion, e.Version)
+	}
+	if e.Type == "" {
+		e.Type = topic
+	}
+	return &e, nil
+}
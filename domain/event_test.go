@@ -0,0 +1,58 @@
+package domain
+
+import (
+	"testing"
+
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseWalletEvent(t *testing.T) {
+	testCases := []struct {
+		payload  string
+		version  int
+		walletID string
+		amount   decimal.Decimal
+		currency string
+		err      bool
+	}{
+		{
+			payload:  `{"version":1,"event_id":"e1","type":"Wallet_Deposited","wallet_id":"w1","amount":"5.55","currency":"EUR","balance":"10","occurred_at":"2023-10-01T00:00:00Z"}`,
+			version:  1,
+			walletID: "w1",
+			amount:   decimal.NewFromFloat(5.55),
+			currency: "EUR",
+		},
+		{
+			payload:  `{"amount":"5.55","currency":"EUR"}`,
+			amount:   decimal.NewFromFloat(5.55),
+			currency: "EUR",
+		},
+		{
+			payload: `{}`,
+		},
+		{
+			payload: `{"version":2}`,
+			err:     true,
+		},
+		{
+			payload: `{"amount":`,
+			err:     true,
+		},
+	}
+
+	for _, tc := range testCases {
+		e, err := ParseWalletEvent(TopicWalletDeposited, []byte(tc.payload))
+		if tc.err {
+			assert.Error(t, err, tc.payload)
+			continue
+		}
+		if assert.NoError(t, err, tc.payload) {
+			assert.Equal(t, tc.version, e.Version)
+			assert.Equal(t, TopicWalletDeposited, e.Type)
+			assert.Equal(t, tc.walletID, e.WalletID)
+			assert.True(t, tc.amount.Equal(e.Amount), tc.payload)
+			assert.Equal(t, tc.currency, e.Currency)
+		}
+	}
+}
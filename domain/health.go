@@ -0,0 +1,20 @@
+package domain
+
+import (
+	"context"
+	"time"
+)
+
+const (
+	DefaultHealthTimeout = 2 * time.Second
+	// DefaultHealthMaxLag is the lag of a partition up to which the consumer is caught up
+	DefaultHealthMaxLag = 1000
+)
+
+// HealthCheck returns an error if the component cannot serve requests.
+type HealthCheck func(ctx context.Context) error
+
+// HealthChecker is implemented by components with a readiness check, like storage backends.
+type HealthChecker interface {
+	HealthCheck(ctx context.Context) error
+}
@@ -0,0 +1,36 @@
+package domain
+
+import (
+	"errors"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+const (
+	HoldActive   = "active"
+	HoldCaptured = "captured"
+	HoldVoided   = "voided"
+	HoldExpired  = "expired"
+
+	DefaultHoldTTL = 15 * time.Minute
+)
+
+var ErrHoldNotFound = errors.New("hold not found")
+
+// Hold reserves an amount of the wallet balance until it is captured, voided or expires.
+type Hold struct {
+	ID            string          `json:"id"`
+	WalletID      string          `json:"wallet_id"`
+	Amount        decimal.Decimal `json:"amount"`
+	Captured      decimal.Decimal `json:"captured"`
+	Currency      string          `json:"currency"`
+	Status        string          `json:"status"`
+	TransactionID uint64          `json:"transaction_id,omitempty"`
+	CreatedAt     time.Time       `json:"created_at"`
+	ExpiresAt     time.Time       `json:"expires_at"`
+}
+
+func (h *Hold) Expired(now time.Time) bool {
+	return !now.Before(h.ExpiresAt)
+}
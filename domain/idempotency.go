@@ -0,0 +1,28 @@
+package domain
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+const (
+	HeaderIdempotencyKey  = "Idempotency-Key"
+	DefaultIdempotencyTTL = 24 * time.Hour
+)
+
+var ErrIdempotencyConflict = errors.New("idempotency key reused with a different request")
+
+// IdempotencyRecord is the stored outcome of a request sent with an Idempotency-Key.
+// RequestHash fingerprints the request so that reusing the key for another payload is detected.
+type IdempotencyRecord struct {
+	Key         string          `json:"key"`
+	RequestHash string          `json:"request_hash"`
+	StatusCode  int             `json:"status_code"`
+	Body        json.RawMessage `json:"body"`
+	CreatedAt   time.Time       `json:"created_at"`
+}
+
+func (r *IdempotencyRecord) Expired(ttl time.Duration, now time.Time) bool {
+	return now.Sub(r.CreatedAt) >= ttl
+}
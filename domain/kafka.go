@@ -1,22 +1,57 @@
 package domain
 
-import (
-	"time"
-
-	"github.com/shopspring/decimal"
-)
+import "time"
 
 const (
-	ProducerFlushMs = 100
-	ConsumerTimeout = 100 * time.Millisecond
+	DefaultFlushTimeout     = 5 * time.Second
+	ProducerDeliveryTimeout = 30 * time.Second
+	ConsumerTimeout         = 100 * time.Millisecond
+	MetadataTimeout         = 10 * time.Second
+	// ReplayTimeout is how long replay waits for the next message before giving up
+	ReplayTimeout = 30 * time.Second
+	// DeadLetterBackoff is the first delay before retrying a failed send to the dead
+	// letter topic, it doubles up to DeadLetterMaxBackoff
+	DeadLetterBackoff    = 100 * time.Millisecond
+	DeadLetterMaxBackoff = 10 * time.Second
 
 	TopicWalletCreated     = "Wallet_Created"
+	TopicWalletUpdated     = "Wallet_Updated"
 	TopicWalletDeleted     = "Wallet_Deleted"
 	TopicWalletDeposited   = "Wallet_Deposited"
 	TopicWalletTransferred = "Wallet_Transferred"
 	TopicWalletWithdrawn   = "Wallet_Withdrawn"
+
+	DefaultConsumerGroup   = "impay-stats"
+	DefaultOffsetReset     = "earliest"
+	DefaultDeadLetterTopic = "Impay_DLQ"
+
+	// CommitManual commits offsets after the message is processed,
+	// CommitAuto leaves committing to the periodic auto commit
+	CommitManual = "manual"
+	CommitAuto   = "auto"
+
+	// DefaultPartitioner hashes keys the same way as the Java client,
+	// messages without a key are spread randomly
+	DefaultPartitioner = "murmur2_random"
 )
 
-type WalletMsg struct {
-	Amount decimal.Decimal `json:"amount,omitempty"`
+// WalletTopics are the topics of all wallet events.
+var WalletTopics = []string{
+	TopicWalletCreated,
+	TopicWalletUpdated,
+	TopicWalletDeleted,
+	TopicWalletDeposited,
+	TopicWalletTransferred,
+	TopicWalletWithdrawn,
+}
+
+// Partitioners supported by librdkafka.
+var Partitioners = map[string]bool{
+	"random":            true,
+	"consistent":        true,
+	"consistent_random": true,
+	"murmur2":           true,
+	"murmur2_random":    true,
+	"fnv1a":             true,
+	"fnv1a_random":      true,
 }
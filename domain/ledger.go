@@ -0,0 +1,124 @@
+package domain
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+const (
+	Debit  = "debit"
+	Credit = "credit"
+
+	AccountExternalCash = "external:cash"
+	// AccountReplay balances the wallet balances restored by event replay
+	AccountReplay       = "external:replay"
+	accountWalletPrefix = "wallet:"
+	accountFXPrefix     = "fx:"
+
+	TxDeposit     = "deposit"
+	TxWithdrawal  = "withdrawal"
+	TxTransfer    = "transfer"
+	TxCapture     = "capture"
+	TxTransferIn  = "transfer_in"
+	TxTransferOut = "transfer_out"
+	TxReplay      = "replay"
+)
+
+var (
+	ErrUnbalancedTransaction = errors.New("transaction debits and credits do not match")
+	ErrInvalidEntry          = errors.New("invalid ledger entry")
+	ErrInsufficientFunds     = errors.New("insufficient funds")
+)
+
+// Entry is a single posting of a ledger transaction. Amount is always positive,
+// Direction tells whether the account is debited or credited. Balance is
+// the resulting balance of a wallet account after the posting.
+type Entry struct {
+	Account   string           `json:"account"`
+	Direction string           `json:"direction"`
+	Amount    decimal.Decimal  `json:"amount"`
+	Currency  string           `json:"currency,omitempty"`
+	Balance   *decimal.Decimal `json:"balance,omitempty"`
+}
+
+// Transaction is a balanced set of entries: for every currency the sum of debits
+// equals the sum of credits. ID is assigned by the storage when posted.
+// Rate is set for transfers converted between currencies.
+type Transaction struct {
+	ID        uint64           `json:"id"`
+	Type      string           `json:"type"`
+	Entries   []Entry          `json:"entries"`
+	Rate      *decimal.Decimal `json:"rate,omitempty"`
+	CreatedAt time.Time        `json:"created_at"`
+}
+
+// TransactionQuery selects journal transactions of an account. Transactions
+// are returned newest first. Before is an exclusive cursor on the transaction ID,
+// zero values of Before and Limit mean no bound.
+type TransactionQuery struct {
+	Before uint64
+	Limit  int
+	Filter func(t *Transaction) bool
+}
+
+func (q TransactionQuery) Match(t *Transaction) bool {
+	if q.Before > 0 && t.ID >= q.Before {
+		return false
+	}
+	return q.Filter == nil || q.Filter(t)
+}
+
+func (t *Transaction) Validate() error {
+	if len(t.Entries) < 2 {
+		return ErrUnbalancedTransaction
+	}
+	// debit minus credit per currency
+	sums := make(map[string]decimal.Decimal)
+	for _, e := range t.Entries {
+		if e.Account == "" || !e.Amount.IsPositive() {
+			return ErrInvalidEntry
+		}
+		switch e.Direction {
+		case Debit:
+			sums[e.Currency] = sums[e.Currency].Add(e.Amount)
+		case Credit:
+			sums[e.Currency] = sums[e.Currency].Sub(e.Amount)
+		default:
+			return ErrInvalidEntry
+		}
+	}
+	for _, sum := range sums {
+		if !sum.IsZero() {
+			return ErrUnbalancedTransaction
+		}
+	}
+	return nil
+}
+
+// Entry returns the first entry posted to account.
+func (t *Transaction) Entry(account string) (Entry, bool) {
+	for _, e := range t.Entries {
+		if e.Account == account {
+			return e, true
+		}
+	}
+	return Entry{}, false
+}
+
+// WalletAccount returns the ledger account of the wallet.
+func WalletAccount(walletID string) string {
+	return accountWalletPrefix + walletID
+}
+
+// FXAccount returns the system account that converts money in the currency.
+func FXAccount(currency string) string {
+	return accountFXPrefix + currency
+}
+
+// WalletFromAccount returns the wallet ID of a wallet account.
+func WalletFromAccount(account string) (string, bool) {
+	return strings.CutPrefix(account, accountWalletPrefix)
+}
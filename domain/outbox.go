@@ -0,0 +1,22 @@
+package domain
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// OutboxEvent is an event stored together with the state change that caused it.
+// It is removed from the outbox once the broker confirms the delivery.
+type OutboxEvent struct {
+	ID      uint64          `json:"id"`
+	Topic   string          `json:"topic"`
+	Key     string          `json:"key,omitempty"`
+	Payload json.RawMessage `json:"payload"`
+	// TraceContext of the request that caused the event, the relay continues it
+	TraceContext map[string]string `json:"trace_context,omitempty"`
+	// RequestID of the request that caused the event, sent in the message headers
+	RequestID string    `json:"request_id,omitempty"`
+	Attempts  int       `json:"attempts,omitempty"`
+	LastError string    `json:"last_error,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
@@ -0,0 +1,68 @@
+package domain
+
+import (
+	"context"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// HeaderRequestID carries the request ID in HTTP requests and Kafka messages.
+const HeaderRequestID = "X-Request-ID"
+
+type requestIDKey struct{}
+
+// WithRequestID returns the context of the request with the ID.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestID returns the ID of the request the context belongs to, if any.
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// RequestLogger returns the logger adding the request ID of ctx to every line.
+// Without a request ID the logger is returned as is.
+func RequestLogger(ctx context.Context, logger Logger) Logger {
+	return LoggerWithRequestID(logger, RequestID(ctx))
+}
+
+// LoggerWithRequestID returns the logger adding the request ID to every line.
+func LoggerWithRequestID(logger Logger, id string) Logger {
+	if id == "" {
+		return logger
+	}
+	return &requestLogger{logger: logger, id: zap.String("request_id", id)}
+}
+
+type requestLogger struct {
+	logger Logger
+	id     zapcore.Field
+}
+
+func (l *requestLogger) Info(msg string, fields ...zapcore.Field) {
+	l.logger.Info(msg, l.with(fields)...)
+}
+
+func (l *requestLogger) Panic(msg string, fields ...zapcore.Field) {
+	l.logger.Panic(msg, l.with(fields)...)
+}
+
+func (l *requestLogger) Warn(msg string, fields ...zapcore.Field) {
+	l.logger.Warn(msg, l.with(fields)...)
+}
+
+func (l *requestLogger) Debug(msg string, fields ...zapcore.Field) {
+	l.logger.Debug(msg, l.with(fields)...)
+}
+
+func (l *requestLogger) Error(msg string, fields ...zapcore.Field) {
+	l.logger.Error(msg, l.with(fields)...)
+}
+
+// with appends the request ID without touching the array of the caller.
+func (l *requestLogger) with(fields []zapcore.Field) []zapcore.Field {
+	return append(fields[:len(fields):len(fields)], l.id)
+}
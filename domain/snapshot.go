@@ -0,0 +1,33 @@
+package domain
+
+import "time"
+
+const DefaultSnapshotInterval = time.Minute
+
+// Offsets are the next offsets to read by topic and partition.
+type Offsets map[string]map[int32]int64
+
+func (o Offsets) Get(topic string, partition int32) (int64, bool) {
+	offset, ok := o[topic][partition]
+	return offset, ok
+}
+
+func (o Offsets) Set(topic string, partition int32, offset int64) {
+	if o[topic] == nil {
+		o[topic] = make(map[int32]int64)
+	}
+	o[topic][partition] = offset
+}
+
+// WalletSnapshot is the state of all wallets after the events before Offsets were applied.
+type WalletSnapshot struct {
+	Wallets   []*Wallet `json:"wallets"`
+	Offsets   Offsets   `json:"offsets"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// SnapshotStore keeps the latest wallet snapshot. Load returns nil if no snapshot is saved.
+type SnapshotStore interface {
+	Load() (*WalletSnapshot, error)
+	Save(s *WalletSnapshot) error
+}
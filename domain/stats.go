@@ -0,0 +1,135 @@
+package domain
+
+import (
+	"time"
+
+	"github.com/shopspring/decimal"
+
+	"github.com/Kale-Grabovski/impay/sketch"
+)
+
+const (
+	DefaultCheckpointInterval = 10 * time.Second
+
+	GranularityMinute = "minute"
+	GranularityHour   = "hour"
+	GranularityDay    = "day"
+
+	DefaultMinuteRetention = 6 * time.Hour
+	DefaultHourRetention   = 30 * 24 * time.Hour
+	DefaultDayRetention    = 2 * 365 * 24 * time.Hour
+
+	DefaultStatsMaxWallets   = 10000
+	DefaultStatsWalletWindow = 24 * time.Hour
+	DefaultStreamInterval    = time.Second
+
+	OperationDeposited   = "deposited"
+	OperationWithdrawn   = "withdrawn"
+	OperationTransferred = "transferred"
+)
+
+// Operations are the operation types with amount distributions.
+var Operations = []string{OperationDeposited, OperationWithdrawn, OperationTransferred}
+
+// DefaultHistogramBounds are the upper bounds of amount histogram buckets.
+var DefaultHistogramBounds = []float64{10, 100, 1000, 10000, 100000}
+
+// Granularities are the sizes of stats buckets, days start at midnight UTC.
+var Granularities = map[string]time.Duration{
+	GranularityMinute: time.Minute,
+	GranularityHour:   time.Hour,
+	GranularityDay:    24 * time.Hour,
+}
+
+type CurrencyStats struct {
+	Deposited   decimal.Decimal `json:"deposited"`
+	Withdrawn   decimal.Decimal `json:"withdrawn"`
+	Transferred decimal.Decimal `json:"transferred"`
+}
+
+// WalletStats are the counters of the stats projection.
+type WalletStats struct {
+	Deposited   decimal.Decimal           `json:"deposited"`
+	Withdrawn   decimal.Decimal           `json:"withdrawn"`
+	Transferred decimal.Decimal           `json:"transferred"`
+	Total       decimal.Decimal           `json:"total"`
+	Active      decimal.Decimal           `json:"active"`
+	Inactive    decimal.Decimal           `json:"inactive"`
+	Currencies  map[string]*CurrencyStats `json:"currencies"`
+	// Buckets are keyed by granularity and the unix time of the bucket start
+	Buckets map[string]map[int64]*StatsBucket `json:"buckets,omitempty"`
+	// Wallets are ordered from the least recently active
+	Wallets []*WalletActivity `json:"wallets,omitempty"`
+	// Distributions are keyed by currency and operation
+	Distributions   map[string]map[string]*AmountDistribution `json:"distributions,omitempty"`
+	HistogramBounds []decimal.Decimal                         `json:"histogram_bounds,omitempty"`
+}
+
+// AmountDistribution describes the amounts of operations of a type. Histogram counts the
+// amounts up to each of HistogramBounds of the stats, the last count is of larger amounts.
+type AmountDistribution struct {
+	Count     int64           `json:"count"`
+	Sum       decimal.Decimal `json:"sum"`
+	Min       decimal.Decimal `json:"min"`
+	Max       decimal.Decimal `json:"max"`
+	Histogram []int64         `json:"histogram"`
+	Sketch    *sketch.Sketch  `json:"sketch"`
+}
+
+// WalletOperations are the amounts and counts of operations of a wallet. Incoming
+// transfers are in the wallet currency, converted if the sender has another one.
+type WalletOperations struct {
+	Deposited      decimal.Decimal `json:"deposited"`
+	Withdrawn      decimal.Decimal `json:"withdrawn"`
+	TransferredIn  decimal.Decimal `json:"transferred_in"`
+	TransferredOut decimal.Decimal `json:"transferred_out"`
+	Deposits       int64           `json:"deposits"`
+	Withdrawals    int64           `json:"withdrawals"`
+	TransfersIn    int64           `json:"transfers_in"`
+	TransfersOut   int64           `json:"transfers_out"`
+}
+
+// Add sums the operations into o.
+func (o *WalletOperations) Add(other *WalletOperations) {
+	o.Deposited = o.Deposited.Add(other.Deposited)
+	o.Withdrawn = o.Withdrawn.Add(other.Withdrawn)
+	o.TransferredIn = o.TransferredIn.Add(other.TransferredIn)
+	o.TransferredOut = o.TransferredOut.Add(other.TransferredOut)
+	o.Deposits += other.Deposits
+	o.Withdrawals += other.Withdrawals
+	o.TransfersIn += other.TransfersIn
+	o.TransfersOut += other.TransfersOut
+}
+
+// WalletActivity is the stats projection of a single wallet. Hours keep the operations
+// within the stats window keyed by the unix time of the hour start.
+type WalletActivity struct {
+	WalletID       string                      `json:"wallet_id"`
+	Currency       string                      `json:"currency,omitempty"`
+	Lifetime       WalletOperations            `json:"lifetime"`
+	Hours          map[int64]*WalletOperations `json:"hours,omitempty"`
+	LastActivityAt time.Time                   `json:"last_activity_at"`
+}
+
+// StatsBucket counts the events which occurred from Start within the bucket granularity.
+type StatsBucket struct {
+	Start       time.Time       `json:"start"`
+	Deposited   decimal.Decimal `json:"deposited"`
+	Withdrawn   decimal.Decimal `json:"withdrawn"`
+	Transferred decimal.Decimal `json:"transferred"`
+	Created     int64           `json:"created"`
+	Deleted     int64           `json:"deleted"`
+}
+
+// StatsCheckpoint is the stats projection after the messages before Offsets were consumed.
+type StatsCheckpoint struct {
+	Stats     WalletStats `json:"stats"`
+	Offsets   Offsets     `json:"offsets"`
+	CreatedAt time.Time   `json:"created_at"`
+}
+
+// CheckpointStore keeps the latest stats checkpoint. Load returns nil if no checkpoint is saved.
+type CheckpointStore interface {
+	Load() (*StatsCheckpoint, error)
+	Save(c *StatsCheckpoint) error
+}
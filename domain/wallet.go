@@ -1,23 +1,92 @@
 package domain
 
-import "github.com/shopspring/decimal"
+import (
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
 
 const (
 	StatusActive   = "active"
 	StatusInactive = "inactive"
+
+	StorageMemory = "memory"
+	StorageBolt   = "bolt"
+)
+
+var (
+	ErrWalletNotFound = errors.New("wallet not found")
+	ErrWalletExists   = errors.New("wallet already exists")
 )
 
 type Wallet struct {
-	Balance decimal.Decimal `json:"balance"`
-	ID      string          `json:"id"`
-	Name    string          `json:"name"`
-	Status  string          `json:"status"`
+	Balance  decimal.Decimal `json:"balance"`
+	Held     decimal.Decimal `json:"held"`
+	Currency string          `json:"currency"`
+	ID       string          `json:"id"`
+	Name     string          `json:"name"`
+	Status   string          `json:"status"`
+	// Owner is the subject of the principal that created the wallet
+	Owner string `json:"owner,omitempty"`
 }
 
-func NewWallet(id, name string) *Wallet {
+func NewWallet(id, name, currency string) *Wallet {
 	return &Wallet{
-		ID:     id,
-		Name:   name,
-		Status: StatusActive,
+		ID:       id,
+		Name:     name,
+		Currency: currency,
+		Status:   StatusActive,
 	}
 }
+
+// Available is the part of the balance not reserved by active holds.
+func (w *Wallet) Available() decimal.Decimal {
+	return w.Balance.Sub(w.Held)
+}
+
+// MarshalJSON adds the available amount to the wallet fields.
+func (w Wallet) MarshalJSON() ([]byte, error) {
+	type wallet Wallet
+	return json.Marshal(struct {
+		wallet
+		Available decimal.Decimal `json:"available"`
+	}{wallet(w), w.Available()})
+}
+
+// WalletRepository stores wallets. Every read and write happens inside
+// a transaction so that changes touching several wallets are applied
+// atomically.
+type WalletRepository interface {
+	View(fn func(tx WalletTx) error) error
+	Update(fn func(tx WalletTx) error) error
+	Close() error
+}
+
+// WalletTx is a unit of work opened by WalletRepository. Wallets returned
+// by the transaction are copies: changes must be written back with Put.
+type WalletTx interface {
+	GetAll() ([]*Wallet, error)
+	Get(id string) (*Wallet, error)
+	Put(wallet *Wallet) error
+	// AddTransaction stores a ledger transaction and assigns its ID.
+	AddTransaction(t *Transaction) error
+	// Transactions returns the ledger transactions touching account.
+	Transactions(account string, q TransactionQuery) ([]*Transaction, error)
+	// GetIdempotency returns nil if no record is stored for the key.
+	GetIdempotency(key string) (*IdempotencyRecord, error)
+	PutIdempotency(r *IdempotencyRecord) error
+	// PurgeIdempotency removes records created before the given time.
+	PurgeIdempotency(before time.Time) error
+	GetHold(id string) (*Hold, error)
+	PutHold(h *Hold) error
+	// ActiveHolds returns holds that are neither captured nor released.
+	ActiveHolds() ([]*Hold, error)
+	// AddOutbox assigns the event a sequential ID.
+	AddOutbox(e *OutboxEvent) error
+	// PendingOutbox returns up to limit undelivered events, oldest first.
+	PendingOutbox(limit int) ([]*OutboxEvent, error)
+	PutOutbox(e *OutboxEvent) error
+	DeleteOutbox(id uint64) error
+}
@@ -0,0 +1,77 @@
+// Package fx provides exchange rates for transfers between wallets of different currencies.
+package fx
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/shopspring/decimal"
+	"gopkg.in/yaml.v3"
+
+	"github.com/Kale-Grabovski/impay/domain"
+)
+
+// rateScale is the number of decimal places kept for derived cross rates.
+const rateScale = 10
+
+type ratesFile struct {
+	Base  string            `yaml:"base"`
+	Rates map[string]string `yaml:"rates"`
+}
+
+// FileRateProvider serves exchange rates loaded from a YAML file. Rates in the file
+// are quoted against the base currency, cross rates are derived from them.
+type FileRateProvider struct {
+	base  string
+	rates map[string]decimal.Decimal
+}
+
+// NewFileRateProvider loads rates from path. An empty path gives a provider
+// that knows only identity rates.
+func NewFileRateProvider(path string) (*FileRateProvider, error) {
+	p := &FileRateProvider{rates: make(map[string]decimal.Decimal)}
+	if path == "" {
+		return p, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read rates file: %w", err)
+	}
+	var f ratesFile
+	if err = yaml.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("cannot unmarshal rates file: %w", err)
+	}
+	if _, ok := domain.CurrencyScale(f.Base); !ok {
+		return nil, fmt.Errorf("rates base %q: %w", f.Base, domain.ErrUnknownCurrency)
+	}
+
+	p.base = f.Base
+	p.rates[f.Base] = decimal.NewFromInt(1)
+	for currency, v := range f.Rates {
+		if _, ok := domain.CurrencyScale(currency); !ok {
+			return nil, fmt.Errorf("rate %q: %w", currency, domain.ErrUnknownCurrency)
+		}
+		rate, err := decimal.NewFromString(v)
+		if err != nil || !rate.IsPositive() {
+			return nil, fmt.Errorf("wrong rate for %s: %s", currency, v)
+		}
+		p.rates[currency] = rate
+	}
+	return p, nil
+}
+
+func (s *FileRateProvider) Rate(from, to string) (decimal.Decimal, error) {
+	if from == to {
+		return decimal.NewFromInt(1), nil
+	}
+	fromRate, ok := s.rates[from]
+	if !ok {
+		return decimal.Zero, fmt.Errorf("%s: %w", from, domain.ErrRateNotFound)
+	}
+	toRate, ok := s.rates[to]
+	if !ok {
+		return decimal.Zero, fmt.Errorf("%s: %w", to, domain.ErrRateNotFound)
+	}
+	return toRate.DivRound(fromRate, rateScale), nil
+}
@@ -0,0 +1,54 @@
+package fx
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/Kale-Grabovski/impay/domain"
+)
+
+func TestFileRateProvider(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rates.yaml")
+	err := os.WriteFile(path, []byte("base: USD\nrates:\n  EUR: 0.8\n  JPY: 150\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := NewFileRateProvider(path)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	testCases := []struct {
+		from, to string
+		rate     string
+		err      error
+	}{
+		{from: "USD", to: "EUR", rate: "0.8"},
+		{from: "EUR", to: "USD", rate: "1.25"},
+		{from: "EUR", to: "JPY", rate: "187.5"},
+		{from: "GBP", to: "GBP", rate: "1"},
+		{from: "USD", to: "GBP", err: domain.ErrRateNotFound},
+	}
+	for _, tc := range testCases {
+		rate, err := p.Rate(tc.from, tc.to)
+		if tc.err != nil {
+			assert.ErrorIs(t, err, tc.err)
+			continue
+		}
+		if assert.NoError(t, err) {
+			assert.True(t, decimal.RequireFromString(tc.rate).Equal(rate), "%s/%s: %s", tc.from, tc.to, rate)
+		}
+	}
+
+	err = os.WriteFile(path, []byte("base: USD\nrates:\n  XXX: 1\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = NewFileRateProvider(path)
+	assert.ErrorIs(t, err, domain.ErrUnknownCurrency)
+}
@@ -2,11 +2,13 @@ package kafka
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"strconv"
+	"sync"
 	"time"
 
 	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
-	kafkaBase "github.com/confluentinc/confluent-kafka-go/v2/kafka"
 	"go.uber.org/zap"
 
 	"github.com/Kale-Grabovski/impay/domain"
@@ -15,62 +17,349 @@ import (
 type BaseConsumer interface {
 	SubscribeTopics(topics []string, rebalanceCb kafka.RebalanceCb) (err error)
 	ReadMessage(timeout time.Duration) (*kafka.Message, error)
+	CommitMessage(m *kafka.Message) ([]kafka.TopicPartition, error)
+	StoreMessage(m *kafka.Message) ([]kafka.TopicPartition, error)
+	Commit() ([]kafka.TopicPartition, error)
+	Assign(partitions []kafka.TopicPartition) error
+	Assignment() ([]kafka.TopicPartition, error)
+	Position(partitions []kafka.TopicPartition) ([]kafka.TopicPartition, error)
+	GetWatermarkOffsets(topic string, partition int32) (low, high int64, err error)
+	GetMetadata(topic *string, allTopics bool, timeoutMs int) (*kafka.Metadata, error)
+	Close() error
 }
 
-type Consumer struct {
-	cfg    *domain.Config
-	logger domain.Logger
+// ConsumeStats counts the messages consumed from a topic.
+type ConsumeStats struct {
+	Consumed     uint64 `json:"consumed"`
+	DeadLettered uint64 `json:"dead_lettered"`
 }
 
-func NewConsumer(cfg *domain.Config, logger domain.Logger) *Consumer {
-	return &Consumer{
-		logger: logger,
-		cfg:    cfg,
+// DeadLetterProducer sends messages that cannot be processed to the dead letter topic.
+type DeadLetterProducer interface {
+	SendRaw(topic string, key, value []byte, headers []kafka.Header) error
+}
+
+// NewBaseConsumer connects a consumer of the configured group.
+func NewBaseConsumer(cfg *domain.Config) (*kafka.Consumer, error) {
+	offsetReset := cfg.Kafka.OffsetReset
+	if offsetReset == "" {
+		offsetReset = domain.DefaultOffsetReset
+	}
+	manual, err := manualCommit(cfg)
+	if err != nil {
+		return nil, err
 	}
+	return newBaseConsumer(cfg.Kafka.Host, consumerGroup(cfg), offsetReset, manual)
 }
 
-func (s *Consumer) Subscribe(ctx context.Context, topic string, ch chan []byte) error {
-	consumer, err := kafkaBase.NewConsumer(&kafkaBase.ConfigMap{
-		"bootstrap.servers":        s.cfg.Kafka.Host,
-		"group.id":                 "test",
-		"auto.offset.reset":        "latest",
+func newBaseConsumer(host, groupID, offsetReset string, manual bool) (*kafka.Consumer, error) {
+	consumer, err := kafka.NewConsumer(&kafka.ConfigMap{
+		"bootstrap.servers":        host,
+		"group.id":                 groupID,
+		"auto.offset.reset":        offsetReset,
+		"enable.auto.commit":       !manual,
+		"enable.auto.offset.store": false,
 		"fetch.min.bytes":          "1",
 		"allow.auto.create.topics": "true",
 	})
 	if err != nil {
-		return err
+		return nil, fmt.Errorf("cannot create consumer: %w", err)
+	}
+	return consumer, nil
+}
+
+// Consumer reads all subscribed topics with a single base consumer
+// and dispatches messages to the handlers registered for their topics.
+// Messages the handler fails on are moved to the dead letter topic.
+type Consumer struct {
+	cfg         *domain.Config
+	consumer    BaseConsumer
+	deadLetters DeadLetterProducer
+	logger      domain.Logger
+	handlers    map[string]func([]byte) error
+	wg          sync.WaitGroup
+	// mu is held while a message is processed, offsets are
+	// the next offsets to process when resuming from a checkpoint
+	mu      sync.Mutex
+	offsets domain.Offsets
+	// topics count the processed messages per topic
+	topicsMu sync.Mutex
+	topics   map[string]*ConsumeStats
+}
+
+func NewConsumer(
+	cfg *domain.Config,
+	consumer BaseConsumer,
+	deadLetters DeadLetterProducer,
+	logger domain.Logger,
+) *Consumer {
+	return &Consumer{
+		cfg:         cfg,
+		consumer:    consumer,
+		deadLetters: deadLetters,
+		logger:      logger,
+		handlers:    make(map[string]func([]byte) error),
+		topics:      make(map[string]*ConsumeStats),
+	}
+}
+
+// Handle registers handler for messages of the topic, must be called before Start.
+func (s *Consumer) Handle(topic string, handler func([]byte) error) {
+	s.handlers[topic] = handler
+}
+
+// Resume makes the consumer start assigned partitions from offsets instead of the
+// group offsets and keep track of the processed offsets. Partitions missing in
+// offsets are read from the beginning. Must be called before Start.
+func (s *Consumer) Resume(offsets domain.Offsets) {
+	if offsets == nil {
+		offsets = make(domain.Offsets)
 	}
+	s.offsets = offsets
+}
+
+// Checkpoint calls fn with the offsets following the processed messages, no message
+// is processed meanwhile, so the state built by the handlers matches the offsets.
+func (s *Consumer) Checkpoint(fn func(offsets domain.Offsets) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return fn(s.offsets)
+}
 
-	err = consumer.SubscribeTopics([]string{topic}, nil)
+// Start subscribes to the topics with registered handlers, or to kafka.topicPattern
+// if it is set, and consumes them until ctx is done. The offset of a message is
+// stored only after the handler returns, or the failed message is dead lettered,
+// so a message is processed at least once. In manual commit mode it is committed
+// right away. Once ctx is done the offsets of the processed messages are
+// committed and the consumer leaves the group, Wait returns after that.
+func (s *Consumer) Start(ctx context.Context) error {
+	manual, err := manualCommit(s.cfg)
 	if err != nil {
+		return err
+	}
+
+	topics := make([]string, 0, len(s.handlers))
+	for topic := range s.handlers {
+		topics = append(topics, topic)
+	}
+	if s.cfg.Kafka.TopicPattern != "" {
+		topics = []string{s.cfg.Kafka.TopicPattern}
+	}
+	if err = s.consumer.SubscribeTopics(topics, s.rebalance); err != nil {
 		return fmt.Errorf("cannot subscribe to topics: %w", err)
 	}
 
+	s.wg.Add(1)
 	go func() {
+		defer s.wg.Done()
 		for {
-			msg, err := consumer.ReadMessage(domain.ConsumerTimeout)
-			if err != nil && !err.(kafka.Error).IsTimeout() {
-				s.logger.Error("cannot consume event", zap.Error(err))
-			}
-
 			select {
 			case <-ctx.Done():
-				s.logger.Debug("closing consumer " + topic)
-				if msg != nil {
-					ch <- msg.Value
+				s.logger.Debug("closing consumer")
+				// Offsets are stored only for processed messages
+				if manual {
+					s.commit()
 				}
-
-				err = consumer.Close()
-				if err != nil {
-					s.logger.Error("cannot close consumer: "+topic, zap.Error(err))
+				if err := s.consumer.Close(); err != nil {
+					s.logger.Error("cannot close consumer", zap.Error(err))
 				}
 				return
 			default:
-				if msg != nil {
-					ch <- msg.Value
+			}
+
+			msg, err := s.consumer.ReadMessage(domain.ConsumerTimeout)
+			var kafkaErr kafka.Error
+			if err != nil && !(errors.As(err, &kafkaErr) && kafkaErr.IsTimeout()) {
+				s.logger.Error("cannot consume event", zap.Error(err))
+			}
+			if err != nil || msg == nil {
+				continue
+			}
+
+			if !s.process(ctx, msg) {
+				continue
+			}
+			if _, err = s.consumer.StoreMessage(msg); err != nil {
+				s.logger.Error("cannot store offset", zap.Error(err))
+			}
+			if manual {
+				if _, err = s.consumer.CommitMessage(msg); err != nil {
+					s.logger.Error("cannot commit offset", zap.Error(err))
 				}
 			}
 		}
 	}()
 	return nil
 }
+
+// commit commits all offsets stored for processed messages once before closing,
+// so the offset of a message which own commit failed is committed as well.
+// A failed commit is only logged, the messages are consumed again after restart.
+func (s *Consumer) commit() {
+	_, err := s.consumer.Commit()
+	var kafkaErr kafka.Error
+	if err != nil && !(errors.As(err, &kafkaErr) && kafkaErr.Code() == kafka.ErrNoOffset) {
+		s.logger.Error("cannot commit offsets", zap.Error(err))
+	}
+}
+
+// Wait blocks until the consumer is closed after its context is done.
+func (s *Consumer) Wait() {
+	s.wg.Wait()
+}
+
+// process handles the message and moves past it. A message the handler fails
+// on is moved past only once it is in the dead letter topic, false is returned
+// if ctx is done before that.
+func (s *Consumer) process(ctx context.Context, msg *kafka.Message) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	logger := domain.LoggerWithRequestID(s.logger, requestID(msg.Headers))
+	_, span := startConsumerSpan(msg)
+	err := s.dispatch(msg, logger)
+	endSpan(span, err)
+	if err != nil {
+		logger.Error("cannot process message from topic "+topicName(msg), zap.Error(err))
+		if !s.sendDeadLetter(ctx, msg, err, logger) {
+			return false
+		}
+	}
+	s.topicsMu.Lock()
+	stats, ok := s.topics[topicName(msg)]
+	if !ok {
+		stats = &ConsumeStats{}
+		s.topics[topicName(msg)] = stats
+	}
+	stats.Consumed++
+	if err != nil {
+		stats.DeadLettered++
+	}
+	s.topicsMu.Unlock()
+
+	if s.offsets != nil {
+		s.offsets.Set(topicName(msg), msg.TopicPartition.Partition, int64(msg.TopicPartition.Offset)+1)
+	}
+	return true
+}
+
+// TopicStats returns the processed message counts per topic.
+func (s *Consumer) TopicStats() map[string]ConsumeStats {
+	s.topicsMu.Lock()
+	defer s.topicsMu.Unlock()
+	stats := make(map[string]ConsumeStats, len(s.topics))
+	for topic, ts := range s.topics {
+		stats[topic] = *ts
+	}
+	return stats
+}
+
+// Lag returns the number of messages behind the high watermark of every assigned
+// partition. Watermarks are the ones last fetched, partitions not consumed yet are left out.
+func (s *Consumer) Lag() (domain.Offsets, error) {
+	assigned, err := s.consumer.Assignment()
+	if err != nil {
+		return nil, fmt.Errorf("cannot get assignment: %w", err)
+	}
+	positions, err := s.consumer.Position(assigned)
+	if err != nil {
+		return nil, fmt.Errorf("cannot get positions: %w", err)
+	}
+
+	lag := make(domain.Offsets)
+	for _, tp := range positions {
+		_, high, err := s.consumer.GetWatermarkOffsets(partitionTopic(tp), tp.Partition)
+		if err != nil || tp.Offset < 0 || high < 0 {
+			continue
+		}
+		lag.Set(partitionTopic(tp), tp.Partition, max(high-int64(tp.Offset), 0))
+	}
+	return lag, nil
+}
+
+// rebalance starts assigned partitions from the tracked offsets when resuming from
+// a checkpoint, otherwise the group offsets are used.
+func (s *Consumer) rebalance(_ *kafka.Consumer, ev kafka.Event) error {
+	assigned, ok := ev.(kafka.AssignedPartitions)
+	if !ok || s.offsets == nil {
+		return nil
+	}
+
+	s.mu.Lock()
+	partitions := make([]kafka.TopicPartition, 0, len(assigned.Partitions))
+	for _, tp := range assigned.Partitions {
+		tp.Offset = kafka.OffsetBeginning
+		if offset, ok := s.offsets.Get(partitionTopic(tp), tp.Partition); ok {
+			tp.Offset = kafka.Offset(offset)
+		}
+		partitions = append(partitions, tp)
+	}
+	s.mu.Unlock()
+
+	if err := s.consumer.Assign(partitions); err != nil {
+		return fmt.Errorf("cannot assign partitions: %w", err)
+	}
+	return nil
+}
+
+func (s *Consumer) dispatch(msg *kafka.Message, logger domain.Logger) error {
+	topic := topicName(msg)
+	handler, ok := s.handlers[topic]
+	if !ok {
+		logger.Warn("no handler for topic " + topic)
+		return nil
+	}
+	return handler(msg.Value)
+}
+
+// sendDeadLetter retries sending the message to the dead letter topic with
+// backoff until it succeeds or ctx is done.
+func (s *Consumer) sendDeadLetter(ctx context.Context, msg *kafka.Message, cause error, logger domain.Logger) bool {
+	var backoff time.Duration
+	for {
+		err := s.deadLetter(msg, cause)
+		if err == nil {
+			return true
+		}
+		backoff = min(max(2*backoff, domain.DeadLetterBackoff), domain.DeadLetterMaxBackoff)
+		logger.Error("cannot send message to dead letter topic", zap.Error(err), zap.Duration("retry_in", backoff))
+
+		timer := time.NewTimer(backoff)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return false
+		}
+	}
+}
+
+// deadLetter sends the message to the dead letter topic with its origin and the error
+// in headers, the headers of the message, like its request ID, are kept.
+func (s *Consumer) deadLetter(msg *kafka.Message, cause error) error {
+	headers := append(sourceHeaders(msg.Headers),
+		kafka.Header{Key: HeaderDeadLetterTopic, Value: []byte(topicName(msg))},
+		kafka.Header{Key: HeaderDeadLetterPartition, Value: []byte(strconv.Itoa(int(msg.TopicPartition.Partition)))},
+		kafka.Header{Key: HeaderDeadLetterOffset, Value: []byte(strconv.FormatInt(int64(msg.TopicPartition.Offset), 10))},
+		kafka.Header{Key: HeaderDeadLetterError, Value: []byte(cause.Error())},
+	)
+	return s.deadLetters.SendRaw(deadLetterTopic(s.cfg), msg.Key, msg.Value, headers)
+}
+
+func consumerGroup(cfg *domain.Config) string {
+	if cfg.Kafka.GroupID == "" {
+		return domain.DefaultConsumerGroup
+	}
+	return cfg.Kafka.GroupID
+}
+
+func manualCommit(cfg *domain.Config) (bool, error) {
+	switch cfg.Kafka.CommitMode {
+	case "", domain.CommitManual:
+		return true, nil
+	case domain.CommitAuto:
+		return false, nil
+	default:
+		return false, fmt.Errorf("unknown commit mode %q", cfg.Kafka.CommitMode)
+	}
+}
@@ -0,0 +1,167 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+	"go.uber.org/zap"
+
+	"github.com/Kale-Grabovski/impay/domain"
+)
+
+// assignConsumer records the partitions assigned by the rebalance callback.
+type assignConsumer struct {
+	BaseConsumer
+	assigned []kafka.TopicPartition
+}
+
+func (c *assignConsumer) Assign(partitions []kafka.TopicPartition) error {
+	c.assigned = partitions
+	return nil
+}
+
+func (c *assignConsumer) Assignment() ([]kafka.TopicPartition, error) {
+	return c.assigned, nil
+}
+
+func TestConsumerResume(t *testing.T) {
+	base := &assignConsumer{}
+	consumer := NewConsumer(&domain.Config{}, base, nil, nil)
+	topic := domain.TopicWalletDeposited
+	assigned := kafka.AssignedPartitions{Partitions: []kafka.TopicPartition{
+		{Topic: &topic, Partition: 0},
+		{Topic: &topic, Partition: 1},
+	}}
+
+	// Group offsets are used unless resuming from a checkpoint
+	assert.NoError(t, consumer.rebalance(nil, assigned))
+	assert.Nil(t, base.assigned)
+
+	consumer.Resume(domain.Offsets{topic: {1: 5}})
+	consumer.Handle(topic, func([]byte) error { return nil })
+	consumer.process(context.Background(), &kafka.Message{TopicPartition: kafka.TopicPartition{Topic: &topic, Partition: 1, Offset: 5}})
+
+	assert.NoError(t, consumer.rebalance(nil, assigned))
+	if assert.Len(t, base.assigned, 2) {
+		assert.Equal(t, kafka.OffsetBeginning, base.assigned[0].Offset)
+		assert.Equal(t, kafka.Offset(6), base.assigned[1].Offset)
+	}
+	assert.NoError(t, consumer.Checkpoint(func(offsets domain.Offsets) error {
+		assert.Equal(t, domain.Offsets{topic: {1: 6}}, offsets)
+		return nil
+	}))
+}
+
+// lagConsumer reports the positions and watermarks of two partitions.
+type lagConsumer struct {
+	BaseConsumer
+	topic string
+}
+
+func (c *lagConsumer) Assignment() ([]kafka.TopicPartition, error) {
+	return []kafka.TopicPartition{{Topic: &c.topic, Partition: 0}, {Topic: &c.topic, Partition: 1}}, nil
+}
+
+func (c *lagConsumer) Position(partitions []kafka.TopicPartition) ([]kafka.TopicPartition, error) {
+	positions := append([]kafka.TopicPartition(nil), partitions...)
+	positions[0].Offset = 7
+	positions[1].Offset = kafka.OffsetInvalid
+	return positions, nil
+}
+
+func (c *lagConsumer) GetWatermarkOffsets(topic string, partition int32) (int64, int64, error) {
+	return 0, 10, nil
+}
+
+func TestConsumerMetrics(t *testing.T) {
+	topic := domain.TopicWalletDeposited
+	producer := &deadLetterProducer{}
+	consumer := NewConsumer(&domain.Config{}, &lagConsumer{topic: topic}, producer, zap.NewNop())
+	consumer.Handle(topic, func(m []byte) error {
+		if string(m) == "bad" {
+			return errors.New("bad message")
+		}
+		return nil
+	})
+	consumer.process(context.Background(), &kafka.Message{TopicPartition: kafka.TopicPartition{Topic: &topic}, Value: []byte("good")})
+	consumer.process(context.Background(), &kafka.Message{TopicPartition: kafka.TopicPartition{Topic: &topic}, Value: []byte("bad")})
+
+	assert.Equal(t, map[string]ConsumeStats{topic: {Consumed: 2, DeadLettered: 1}}, consumer.TopicStats())
+
+	// Partitions not consumed yet have no lag
+	lag, err := consumer.Lag()
+	assert.NoError(t, err)
+	assert.Equal(t, domain.Offsets{topic: {0: 3}}, lag)
+}
+
+func TestConsumerCaughtUp(t *testing.T) {
+	topic := domain.TopicWalletDeposited
+	consumer := NewConsumer(&domain.Config{}, &lagConsumer{topic: topic}, nil, zap.NewNop())
+
+	assert.NoError(t, consumer.CaughtUpCheck(3)(context.Background()))
+	assert.EqualError(t, consumer.CaughtUpCheck(2)(context.Background()),
+		"partition 0 of Wallet_Deposited is 3 messages behind")
+
+	consumer = NewConsumer(&domain.Config{}, &assignConsumer{}, nil, zap.NewNop())
+	assert.EqualError(t, consumer.CaughtUpCheck(3)(context.Background()), "no partitions assigned")
+}
+
+func TestConsumerTracing(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	defer func() {
+		otel.SetTracerProvider(noop.NewTracerProvider())
+		otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator())
+	}()
+
+	topic := domain.TopicWalletDeposited
+	consumer := NewConsumer(&domain.Config{}, nil, &deadLetterProducer{}, zap.NewNop())
+	consumer.Handle(topic, func(m []byte) error {
+		return errors.New("bad message")
+	})
+
+	// The producer passes its span in the headers and the consumer span continues it
+	var headers []kafka.Header
+	_, span := startProducerSpan(context.Background(), topic, "a", &headers)
+	span.End()
+	consumer.process(context.Background(), &kafka.Message{TopicPartition: kafka.TopicPartition{Topic: &topic}, Headers: headers})
+
+	spans := recorder.Ended()
+	if assert.Len(t, spans, 2) {
+		assert.Equal(t, trace.SpanKindProducer, spans[0].SpanKind())
+		assert.Equal(t, topic+" process", spans[1].Name())
+		assert.Equal(t, trace.SpanKindConsumer, spans[1].SpanKind())
+		assert.Equal(t, spans[0].SpanContext().TraceID(), spans[1].SpanContext().TraceID())
+		assert.Equal(t, spans[0].SpanContext().SpanID(), spans[1].Parent().SpanID())
+		assert.Equal(t, codes.Error, spans[1].Status().Code)
+	}
+}
+
+func TestConsumerRequestID(t *testing.T) {
+	topic := domain.TopicWalletDeposited
+	producer := &deadLetterProducer{}
+	consumer := NewConsumer(&domain.Config{}, nil, producer, zap.NewNop())
+	consumer.Handle(topic, func(m []byte) error {
+		return errors.New("bad message")
+	})
+
+	// The dead letter keeps the request the message was sent for
+	consumer.process(context.Background(), &kafka.Message{
+		TopicPartition: kafka.TopicPartition{Topic: &topic},
+		Headers:        []kafka.Header{{Key: domain.HeaderRequestID, Value: []byte("req-1")}},
+	})
+	if assert.Len(t, producer.messages, 1) {
+		assert.Equal(t, "req-1", requestID(producer.messages[0].Headers))
+	}
+}
@@ -0,0 +1,131 @@
+package kafka
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+
+	"github.com/Kale-Grabovski/impay/domain"
+)
+
+// Headers of dead letter messages describing where the message came from and why it failed.
+const (
+	HeaderDeadLetterTopic     = "dlq_topic"
+	HeaderDeadLetterPartition = "dlq_partition"
+	HeaderDeadLetterOffset    = "dlq_offset"
+	HeaderDeadLetterError     = "dlq_error"
+)
+
+// DeadLetter is a message of the dead letter topic.
+type DeadLetter struct {
+	Offset    int64
+	Topic     string
+	Partition int32
+	// SourceOffset is the offset of the message in Topic
+	SourceOffset int64
+	Error        string
+	Key          []byte
+	Value        []byte
+	// Headers are the headers of the source message
+	Headers []kafka.Header
+	msg     *kafka.Message
+}
+
+// NewDeadLetterConsumer connects a consumer of the dead letter topic. It has its own
+// group, the offset is committed once a message is re-driven to its topic.
+func NewDeadLetterConsumer(cfg *domain.Config) (*kafka.Consumer, error) {
+	return newBaseConsumer(cfg.Kafka.Host, consumerGroup(cfg)+"-dlq", "earliest", true)
+}
+
+// ReadDeadLetters calls fn for every dead letter not re-driven yet, it returns once
+// no message arrives within idle.
+func ReadDeadLetters(
+	cfg *domain.Config,
+	consumer BaseConsumer,
+	idle time.Duration,
+	fn func(*DeadLetter) error,
+) error {
+	if err := consumer.SubscribeTopics([]string{deadLetterTopic(cfg)}, nil); err != nil {
+		return fmt.Errorf("cannot subscribe to dead letter topic: %w", err)
+	}
+	for {
+		msg, err := consumer.ReadMessage(idle)
+		var kafkaErr kafka.Error
+		if errors.As(err, &kafkaErr) && kafkaErr.IsTimeout() {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("cannot read dead letter: %w", err)
+		}
+		if err = fn(newDeadLetter(msg)); err != nil {
+			return err
+		}
+	}
+}
+
+// Redrive sends the dead letter back to its topic with the headers of the source
+// message and commits it in the dead letter topic.
+func Redrive(producer DeadLetterProducer, consumer BaseConsumer, d *DeadLetter) error {
+	if d.Topic == "" {
+		return fmt.Errorf("dead letter %d has no source topic", d.Offset)
+	}
+	if err := producer.SendRaw(d.Topic, d.Key, d.Value, d.Headers); err != nil {
+		return fmt.Errorf("cannot re-drive dead letter %d: %w", d.Offset, err)
+	}
+	if _, err := consumer.CommitMessage(d.msg); err != nil {
+		return fmt.Errorf("cannot commit dead letter %d: %w", d.Offset, err)
+	}
+	return nil
+}
+
+func newDeadLetter(msg *kafka.Message) *DeadLetter {
+	d := &DeadLetter{
+		Offset:       int64(msg.TopicPartition.Offset),
+		Partition:    -1,
+		SourceOffset: -1,
+		Key:          msg.Key,
+		Value:        msg.Value,
+		Headers:      sourceHeaders(msg.Headers),
+		msg:          msg,
+	}
+	for _, h := range msg.Headers {
+		switch h.Key {
+		case HeaderDeadLetterTopic:
+			d.Topic = string(h.Value)
+		case HeaderDeadLetterPartition:
+			if p, err := strconv.ParseInt(string(h.Value), 10, 32); err == nil {
+				d.Partition = int32(p)
+			}
+		case HeaderDeadLetterOffset:
+			if o, err := strconv.ParseInt(string(h.Value), 10, 64); err == nil {
+				d.SourceOffset = o
+			}
+		case HeaderDeadLetterError:
+			d.Error = string(h.Value)
+		}
+	}
+	return d
+}
+
+// sourceHeaders returns the headers without the dead letter ones.
+func sourceHeaders(headers []kafka.Header) []kafka.Header {
+	source := make([]kafka.Header, 0, len(headers))
+	for _, h := range headers {
+		switch h.Key {
+		case HeaderDeadLetterTopic, HeaderDeadLetterPartition, HeaderDeadLetterOffset, HeaderDeadLetterError:
+		default:
+			source = append(source, h)
+		}
+	}
+	return source
+}
+
+func deadLetterTopic(cfg *domain.Config) string {
+	if cfg.Kafka.DeadLetterTopic == "" {
+		return domain.DefaultDeadLetterTopic
+	}
+	return cfg.Kafka.DeadLetterTopic
+}
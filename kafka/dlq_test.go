@@ -0,0 +1,111 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+
+	"github.com/Kale-Grabovski/impay/domain"
+)
+
+type deadLetterProducer struct {
+	messages []*kafka.Message
+	// failures is the number of sends to fail before messages are accepted
+	failures int
+}
+
+func (p *deadLetterProducer) SendRaw(topic string, key, value []byte, headers []kafka.Header) error {
+	if p.failures > 0 {
+		p.failures--
+		return errors.New("broker is down")
+	}
+	p.messages = append(p.messages, &kafka.Message{
+		TopicPartition: kafka.TopicPartition{Topic: &topic, Offset: kafka.Offset(len(p.messages))},
+		Key:            key,
+		Value:          value,
+		Headers:        headers,
+	})
+	return nil
+}
+
+// commitConsumer accepts commits of dead letters.
+type commitConsumer struct {
+	BaseConsumer
+}
+
+func (c *commitConsumer) CommitMessage(*kafka.Message) ([]kafka.TopicPartition, error) {
+	return nil, nil
+}
+
+func TestDeadLetter(t *testing.T) {
+	cfg := &domain.Config{}
+	cfg.Kafka.DeadLetterTopic = "dlq"
+	producer := &deadLetterProducer{}
+	consumer := NewConsumer(cfg, nil, producer, nil)
+
+	topic := domain.TopicWalletDeposited
+	headers := []kafka.Header{
+		{Key: domain.HeaderRequestID, Value: []byte("req-1")},
+		{Key: "traceparent", Value: []byte("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")},
+	}
+	err := consumer.deadLetter(&kafka.Message{
+		TopicPartition: kafka.TopicPartition{Topic: &topic, Partition: 2, Offset: 42},
+		Key:            []byte("w1"),
+		Value:          []byte(`{"amount":`),
+		Headers:        headers,
+	}, errors.New("cannot unmarshal event"))
+	assert.NoError(t, err)
+
+	if assert.Len(t, producer.messages, 1) {
+		assert.Equal(t, "dlq", topicName(producer.messages[0]))
+
+		d := newDeadLetter(producer.messages[0])
+		assert.Equal(t, int64(0), d.Offset)
+		assert.Equal(t, domain.TopicWalletDeposited, d.Topic)
+		assert.Equal(t, int32(2), d.Partition)
+		assert.Equal(t, int64(42), d.SourceOffset)
+		assert.Equal(t, "cannot unmarshal event", d.Error)
+		assert.Equal(t, []byte("w1"), d.Key)
+		assert.Equal(t, []byte(`{"amount":`), d.Value)
+		assert.Equal(t, headers, d.Headers)
+
+		// The source message is sent back with its own headers only
+		assert.NoError(t, Redrive(producer, &commitConsumer{}, d))
+		if assert.Len(t, producer.messages, 2) {
+			assert.Equal(t, domain.TopicWalletDeposited, topicName(producer.messages[1]))
+			assert.Equal(t, headers, producer.messages[1].Headers)
+		}
+	}
+}
+
+func TestDeadLetterRetry(t *testing.T) {
+	topic := domain.TopicWalletDeposited
+	producer := &deadLetterProducer{failures: 1}
+	consumer := NewConsumer(&domain.Config{}, nil, producer, zap.NewNop())
+	consumer.Resume(nil)
+	consumer.Handle(topic, func([]byte) error {
+		return errors.New("bad message")
+	})
+
+	// The message is moved past once the dead letter is sent
+	assert.True(t, consumer.process(context.Background(), &kafka.Message{
+		TopicPartition: kafka.TopicPartition{Topic: &topic, Offset: 1},
+	}))
+	assert.Len(t, producer.messages, 1)
+	assert.Equal(t, domain.Offsets{topic: {0: 2}}, consumer.offsets)
+
+	// It is not moved past if the consumer stops before that
+	producer.failures = 100
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.False(t, consumer.process(ctx, &kafka.Message{
+		TopicPartition: kafka.TopicPartition{Topic: &topic, Offset: 2},
+	}))
+	assert.Len(t, producer.messages, 1)
+	assert.Equal(t, domain.Offsets{topic: {0: 2}}, consumer.offsets)
+	assert.Equal(t, map[string]ConsumeStats{topic: {Consumed: 1, DeadLettered: 1}}, consumer.TopicStats())
+}
@@ -0,0 +1,61 @@
+package kafka
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/Kale-Grabovski/impay/domain"
+)
+
+// HealthCheck checks that the brokers are reachable by fetching the cluster metadata.
+func (s *Producer) HealthCheck(ctx context.Context) error {
+	if _, err := s.producer.GetMetadata(nil, false, metadataTimeout(ctx)); err != nil {
+		return fmt.Errorf("cannot get metadata: %w", err)
+	}
+	return nil
+}
+
+// HealthCheck checks that the brokers are reachable by fetching the cluster metadata.
+func (s *Consumer) HealthCheck(ctx context.Context) error {
+	if _, err := s.consumer.GetMetadata(nil, false, metadataTimeout(ctx)); err != nil {
+		return fmt.Errorf("cannot get metadata: %w", err)
+	}
+	return nil
+}
+
+// CaughtUpCheck returns a check that the consumer has partitions assigned and
+// every partition is at most maxLag messages behind.
+func (s *Consumer) CaughtUpCheck(maxLag int64) domain.HealthCheck {
+	return func(ctx context.Context) error {
+		assigned, err := s.consumer.Assignment()
+		if err != nil {
+			return fmt.Errorf("cannot get assignment: %w", err)
+		}
+		if len(assigned) == 0 {
+			return fmt.Errorf("no partitions assigned")
+		}
+
+		lag, err := s.Lag()
+		if err != nil {
+			return err
+		}
+		for topic, partitions := range lag {
+			for partition, n := range partitions {
+				if n > maxLag {
+					return fmt.Errorf("partition %d of %s is %d messages behind", partition, topic, n)
+				}
+			}
+		}
+		return nil
+	}
+}
+
+// metadataTimeout is the time left until the ctx deadline in milliseconds.
+func metadataTimeout(ctx context.Context) int {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return int(domain.MetadataTimeout.Milliseconds())
+	}
+	return max(int(time.Until(deadline).Milliseconds()), 1)
+}
@@ -0,0 +1,84 @@
+package kafka
+
+import (
+	"strconv"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+
+	"github.com/Kale-Grabovski/impay/metrics"
+)
+
+// RegisterMetrics exposes the delivery counts per topic and the queue depth.
+func (s *Producer) RegisterMetrics(reg prometheus.Registerer) {
+	reg.MustRegister(
+		metrics.CounterFunc("impay_kafka_messages_produced_total", "Messages acknowledged by the broker.",
+			[]string{"topic"}, func() []metrics.Sample {
+				return deliverySamples(s.TopicStats(), func(stats DeliveryStats) uint64 {
+					return stats.Delivered
+				})
+			}),
+		metrics.CounterFunc("impay_kafka_messages_failed_total", "Messages the broker failed to acknowledge.",
+			[]string{"topic"}, func() []metrics.Sample {
+				return deliverySamples(s.TopicStats(), func(stats DeliveryStats) uint64 {
+					return stats.Failed
+				})
+			}),
+		metrics.GaugeFunc("impay_kafka_producer_queue_depth", "Messages waiting for delivery or a delivery report.",
+			nil, func() []metrics.Sample {
+				return []metrics.Sample{{Value: float64(s.QueueLen())}}
+			}),
+	)
+}
+
+// RegisterMetrics exposes the processed message counts per topic and the lag per partition.
+func (s *Consumer) RegisterMetrics(reg prometheus.Registerer) {
+	reg.MustRegister(
+		metrics.CounterFunc("impay_kafka_messages_consumed_total", "Messages processed by the consumer.",
+			[]string{"topic"}, func() []metrics.Sample {
+				return consumeSamples(s.TopicStats(), func(stats ConsumeStats) uint64 {
+					return stats.Consumed
+				})
+			}),
+		metrics.CounterFunc("impay_kafka_messages_dead_lettered_total", "Messages moved to the dead letter topic.",
+			[]string{"topic"}, func() []metrics.Sample {
+				return consumeSamples(s.TopicStats(), func(stats ConsumeStats) uint64 {
+					return stats.DeadLettered
+				})
+			}),
+		metrics.GaugeFunc("impay_kafka_consumer_lag", "Messages behind the high watermark of the partition.",
+			[]string{"topic", "partition"}, func() []metrics.Sample {
+				lag, err := s.Lag()
+				if err != nil {
+					s.logger.Error("cannot get consumer lag", zap.Error(err))
+					return nil
+				}
+				var samples []metrics.Sample
+				for topic, partitions := range lag {
+					for partition, n := range partitions {
+						samples = append(samples, metrics.Sample{
+							Labels: []string{topic, strconv.Itoa(int(partition))},
+							Value:  float64(n),
+						})
+					}
+				}
+				return samples
+			}),
+	)
+}
+
+func deliverySamples(stats map[string]DeliveryStats, value func(DeliveryStats) uint64) []metrics.Sample {
+	samples := make([]metrics.Sample, 0, len(stats))
+	for topic, ts := range stats {
+		samples = append(samples, metrics.Sample{Labels: []string{topic}, Value: float64(value(ts))})
+	}
+	return samples
+}
+
+func consumeSamples(stats map[string]ConsumeStats, value func(ConsumeStats) uint64) []metrics.Sample {
+	samples := make([]metrics.Sample, 0, len(stats))
+	for topic, ts := range stats {
+		samples = append(samples, metrics.Sample{Labels: []string{topic}, Value: float64(value(ts))})
+	}
+	return samples
+}
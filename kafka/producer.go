@@ -1,57 +1,260 @@
 package kafka
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+	"sync"
+	"sync/atomic"
 
 	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+	"go.uber.org/zap"
 
 	"github.com/Kale-Grabovski/impay/domain"
 )
 
+// DeliveryStats counts delivery reports received from the broker.
+type DeliveryStats struct {
+	Sent      uint64 `json:"sent"`
+	Delivered uint64 `json:"delivered"`
+	Failed    uint64 `json:"failed"`
+}
+
 type Producer struct {
 	cfg      *domain.Config
 	producer *kafka.Producer
 	logger   domain.Logger
+	wg       sync.WaitGroup
+
+	sent      atomic.Uint64
+	delivered atomic.Uint64
+	failed    atomic.Uint64
+	// topics count the delivery reports per topic
+	topicsMu sync.Mutex
+	topics   map[string]*DeliveryStats
+
+	closeOnce   sync.Once
+	undelivered int
 }
 
 func NewProducer(cfg *domain.Config, logger domain.Logger) (*Producer, error) {
+	partitioner := cfg.Kafka.Partitioner
+	if partitioner == "" {
+		partitioner = domain.DefaultPartitioner
+	}
+	if !domain.Partitioners[partitioner] {
+		return nil, fmt.Errorf("unknown partitioner %q", partitioner)
+	}
+
 	producer, err := kafka.NewProducer(&kafka.ConfigMap{
 		"bootstrap.servers":      cfg.Kafka.Host,
+		"partitioner":            partitioner,
 		"queue.buffering.max.ms": 5,
+		"message.timeout.ms":     int(domain.ProducerDeliveryTimeout.Milliseconds()),
 	})
 	if err != nil {
 		return nil, fmt.Errorf("cannot connect to producer: %v", err)
 	}
-	return &Producer{
+	p := &Producer{
 		cfg:      cfg,
 		producer: producer,
 		logger:   logger,
-	}, nil
+		topics:   make(map[string]*DeliveryStats),
+	}
+	p.wg.Add(1)
+	go p.deliveryReports()
+	return p, nil
 }
 
-func (s *Producer) Send(topic string, partition int32, msg any) error {
-	if partition == 0 {
-		partition = kafka.PartitionAny
+// Send blocks until the broker confirms the delivery of the message, it is
+// meant for the synchronous publish mode. Messages with the same key go to the
+// same partition, so their order is kept. The trace context and the request ID
+// of ctx are passed along in the message headers.
+func (s *Producer) Send(ctx context.Context, topic string, key string, msg any) error {
+	delivery := make(chan error, 1)
+	if err := s.Produce(ctx, topic, key, msg, func(err error) {
+		delivery <- err
+	}); err != nil {
+		return err
 	}
+	// librdkafka always reports the delivery once message.timeout.ms passes
+	return <-delivery
+}
 
+// Produce queues the message and returns without waiting for the broker. The
+// delivery report loop calls done with the delivery result, so done must not
+// block. It is not called when the message cannot be queued. Headers are set
+// as Send does.
+func (s *Producer) Produce(ctx context.Context, topic string, key string, msg any, done func(error)) error {
 	m, err := json.Marshal(msg)
 	if err != nil {
 		return fmt.Errorf("cannot marshal msg: %v", err)
 	}
+	var headers []kafka.Header
+	if id := domain.RequestID(ctx); id != "" {
+		headers = append(headers, kafka.Header{Key: domain.HeaderRequestID, Value: []byte(id)})
+	}
+	_, span := startProducerSpan(ctx, topic, key, &headers)
+	err = s.produce(topic, []byte(key), m, headers, func(err error) {
+		endSpan(span, err)
+		done(err)
+	})
+	if err != nil {
+		endSpan(span, err)
+	}
+	return err
+}
+
+// SendRaw sends the value as is, it blocks until the delivery is confirmed.
+func (s *Producer) SendRaw(topic string, key, value []byte, headers []kafka.Header) error {
+	delivery := make(chan error, 1)
+	if err := s.produce(topic, key, value, headers, func(err error) {
+		delivery <- err
+	}); err != nil {
+		return err
+	}
+	return <-delivery
+}
 
-	err = s.producer.Produce(&kafka.Message{
-		TopicPartition: kafka.TopicPartition{Topic: &topic, Partition: partition},
-		Value:          m,
+// produce queues the message, the delivery report loop passes the result to
+// done through the message opaque.
+func (s *Producer) produce(topic string, key, value []byte, headers []kafka.Header, done func(error)) error {
+	err := s.producer.Produce(&kafka.Message{
+		TopicPartition: kafka.TopicPartition{Topic: &topic, Partition: kafka.PartitionAny},
+		Key:            key,
+		Value:          value,
+		Headers:        headers,
+		Opaque:         deliveryFunc(done),
 	}, nil)
 	if err != nil {
 		return fmt.Errorf("cannot producer a message: %v", err)
 	}
+	s.sent.Add(1)
+	s.countTopic(topic, func(stats *DeliveryStats) {
+		stats.Sent++
+	})
 	return nil
 }
 
-func (s *Producer) Close() {
-	s.producer.Flush(domain.ProducerFlushMs)
+// deliveryFunc receives the delivery result of a message.
+type deliveryFunc func(error)
+
+// requestID returns the ID of the request the message was sent for, if any.
+func requestID(headers []kafka.Header) string {
+	for _, h := range headers {
+		if h.Key == domain.HeaderRequestID {
+			return string(h.Value)
+		}
+	}
+	return ""
+}
+
+// deliveryReports serves the producer events until the producer is closed.
+func (s *Producer) deliveryReports() {
+	defer s.wg.Done()
+	for e := range s.producer.Events() {
+		switch ev := e.(type) {
+		case *kafka.Message:
+			err := ev.TopicPartition.Error
+			if err != nil {
+				s.failed.Add(1)
+				s.countTopic(topicName(ev), func(stats *DeliveryStats) {
+					stats.Failed++
+				})
+				domain.LoggerWithRequestID(s.logger, requestID(ev.Headers)).
+					Error("cannot deliver message to topic "+topicName(ev), zap.Error(err))
+			} else {
+				s.delivered.Add(1)
+				s.countTopic(topicName(ev), func(stats *DeliveryStats) {
+					stats.Delivered++
+				})
+			}
+			if done, ok := ev.Opaque.(deliveryFunc); ok {
+				if err != nil {
+					err = fmt.Errorf("cannot deliver a message: %w", err)
+				}
+				done(err)
+			}
+		case kafka.Error:
+			s.logger.Error("producer error", zap.Error(ev))
+		}
+	}
+}
+
+func (s *Producer) Stats() DeliveryStats {
+	return DeliveryStats{
+		Sent:      s.sent.Load(),
+		Delivered: s.delivered.Load(),
+		Failed:    s.failed.Load(),
+	}
+}
+
+// TopicStats returns the delivery stats per topic.
+func (s *Producer) TopicStats() map[string]DeliveryStats {
+	s.topicsMu.Lock()
+	defer s.topicsMu.Unlock()
+	stats := make(map[string]DeliveryStats, len(s.topics))
+	for topic, ts := range s.topics {
+		stats[topic] = *ts
+	}
+	return stats
+}
+
+// QueueLen returns the number of messages waiting for delivery or a delivery report.
+func (s *Producer) QueueLen() int {
+	return s.producer.Len()
+}
+
+func (s *Producer) countTopic(topic string, fn func(stats *DeliveryStats)) {
+	s.topicsMu.Lock()
+	defer s.topicsMu.Unlock()
+	stats, ok := s.topics[topic]
+	if !ok {
+		stats = &DeliveryStats{}
+		s.topics[topic] = stats
+	}
+	fn(stats)
+}
+
+// Close waits up to kafka.flushTimeout for outstanding messages and returns
+// the number of messages left undelivered. Closing again returns the same number.
+func (s *Producer) Close() int {
+	s.closeOnce.Do(func() {
+		s.undelivered = s.close()
+	})
+	return s.undelivered
+}
+
+func (s *Producer) close() int {
+	timeout := s.cfg.Kafka.FlushTimeout
+	if timeout <= 0 {
+		timeout = domain.DefaultFlushTimeout
+	}
+	undelivered := s.producer.Flush(int(timeout.Milliseconds()))
 	s.producer.Close()
-	s.logger.Debug("producer closed")
+	s.wg.Wait()
+
+	stats := s.Stats()
+	fields := []zap.Field{
+		zap.Int("undelivered", undelivered),
+		zap.Uint64("delivered", stats.Delivered),
+		zap.Uint64("failed", stats.Failed),
+	}
+	if undelivered > 0 {
+		s.logger.Warn("producer closed with undelivered messages", fields...)
+	} else {
+		s.logger.Debug("producer closed", fields...)
+	}
+	return undelivered
+}
+
+func topicName(m *kafka.Message) string {
+	return partitionTopic(m.TopicPartition)
+}
+
+func partitionTopic(tp kafka.TopicPartition) string {
+	if tp.Topic == nil {
+		return ""
+	}
+	return *tp.Topic
 }
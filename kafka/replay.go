@@ -0,0 +1,186 @@
+package kafka
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+
+	"github.com/Kale-Grabovski/impay/domain"
+)
+
+// ReplayConsumer reads assigned partitions without a consumer group.
+type ReplayConsumer interface {
+	GetMetadata(topic *string, allTopics bool, timeoutMs int) (*kafka.Metadata, error)
+	QueryWatermarkOffsets(topic string, partition int32, timeoutMs int) (low, high int64, err error)
+	Assign(partitions []kafka.TopicPartition) error
+	Unassign() error
+	Position(partitions []kafka.TopicPartition) ([]kafka.TopicPartition, error)
+	ReadMessage(timeout time.Duration) (*kafka.Message, error)
+	Close() error
+}
+
+// NewReplayConsumer connects a consumer for replaying wallet events. Partitions are
+// assigned explicitly and offsets are never committed, the group is not used.
+func NewReplayConsumer(cfg *domain.Config) (*kafka.Consumer, error) {
+	return newBaseConsumer(cfg.Kafka.Host, consumerGroup(cfg)+"-replay", "earliest", true)
+}
+
+// Replayer reads wallet events from the beginning, or from the given offsets,
+// up to the end of the topics at the moment of the call.
+type Replayer struct {
+	consumer ReplayConsumer
+	topics   []string
+}
+
+func NewReplayer(consumer ReplayConsumer, topics []string) *Replayer {
+	return &Replayer{
+		consumer: consumer,
+		topics:   topics,
+	}
+}
+
+// EventSourcingTopics returns the compacted eventSourcing.topic if it is set,
+// otherwise the Wallet_* topics.
+func EventSourcingTopics(cfg *domain.Config) []string {
+	if cfg.EventSourcing.Topic != "" {
+		return []string{cfg.EventSourcing.Topic}
+	}
+	return domain.WalletTopics
+}
+
+// Offsets returns the end offsets of all partitions of the replayed topics.
+// Topics that do not exist yet are left out.
+func (s *Replayer) Offsets() (domain.Offsets, error) {
+	_, ends, err := s.watermarks()
+	return ends, err
+}
+
+// watermarks returns the first and the end offsets of the replayed partitions.
+func (s *Replayer) watermarks() (domain.Offsets, domain.Offsets, error) {
+	md, err := s.consumer.GetMetadata(nil, true, int(domain.MetadataTimeout.Milliseconds()))
+	if err != nil {
+		return nil, nil, fmt.Errorf("cannot get topics metadata: %w", err)
+	}
+
+	starts, ends := make(domain.Offsets), make(domain.Offsets)
+	for _, topic := range s.topics {
+		tm, ok := md.Topics[topic]
+		if !ok || tm.Error.Code() != kafka.ErrNoError {
+			continue
+		}
+		for _, p := range tm.Partitions {
+			low, high, err := s.consumer.QueryWatermarkOffsets(topic, p.ID, int(domain.MetadataTimeout.Milliseconds()))
+			if err != nil {
+				return nil, nil, fmt.Errorf("cannot get offsets of %s[%d]: %w", topic, p.ID, err)
+			}
+			starts.Set(topic, p.ID, low)
+			ends.Set(topic, p.ID, high)
+		}
+	}
+	return starts, ends, nil
+}
+
+// Replay calls fn for every message from offsets, partitions missing in offsets
+// are read from the beginning, up to the current end of the topics. Offsets are
+// advanced past the messages read, messages appended during the replay are left
+// for the next one. Offsets removed by retention are skipped.
+func (s *Replayer) Replay(offsets domain.Offsets, fn func(topic string, payload []byte) error) error {
+	lows, ends, err := s.watermarks()
+	if err != nil {
+		return err
+	}
+
+	var assignment []kafka.TopicPartition
+	for topic, partitions := range ends {
+		for partition, end := range partitions {
+			low, _ := lows.Get(topic, partition)
+			start, ok := offsets.Get(topic, partition)
+			if low >= end || ok && start >= end {
+				offsets.Set(topic, partition, end)
+				continue
+			}
+			offset := kafka.OffsetBeginning
+			if ok {
+				offset = kafka.Offset(max(start, low))
+			}
+			topic := topic
+			assignment = append(assignment, kafka.TopicPartition{Topic: &topic, Partition: partition, Offset: offset})
+		}
+	}
+	if len(assignment) == 0 {
+		return nil
+	}
+	if err = s.consumer.Assign(assignment); err != nil {
+		return fmt.Errorf("cannot assign partitions: %w", err)
+	}
+	defer s.consumer.Unassign()
+
+	left := assignment
+	progressed := time.Now()
+	for len(left) > 0 {
+		msg, err := s.consumer.ReadMessage(domain.ConsumerTimeout)
+		var kafkaErr kafka.Error
+		if errors.As(err, &kafkaErr) && kafkaErr.IsTimeout() {
+			// Records removed by compaction and transaction markers at the end of
+			// a partition are never delivered, the position moves past them though
+			if left, err = s.reachedEnd(left, ends, offsets); err != nil {
+				return err
+			}
+			if len(left) > 0 && time.Since(progressed) > domain.ReplayTimeout {
+				return fmt.Errorf("replay stalled with %d partitions left", len(left))
+			}
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("cannot replay event: %w", err)
+		}
+		progressed = time.Now()
+
+		topic, partition := topicName(msg), msg.TopicPartition.Partition
+		next := int64(msg.TopicPartition.Offset) + 1
+		end, _ := ends.Get(topic, partition)
+		if next > end {
+			continue
+		}
+		if err = fn(topic, msg.Value); err != nil {
+			return err
+		}
+		offsets.Set(topic, partition, next)
+		if next == end {
+			left = slices.DeleteFunc(left, func(tp kafka.TopicPartition) bool {
+				return partitionTopic(tp) == topic && tp.Partition == partition
+			})
+		}
+	}
+	return nil
+}
+
+// reachedEnd returns the partitions which consumer position is still before
+// their end, the offsets of the others are set to the end.
+func (s *Replayer) reachedEnd(
+	partitions []kafka.TopicPartition,
+	ends, offsets domain.Offsets,
+) ([]kafka.TopicPartition, error) {
+	positions, err := s.consumer.Position(partitions)
+	if err != nil {
+		return nil, fmt.Errorf("cannot get replay positions: %w", err)
+	}
+	var left []kafka.TopicPartition
+	for _, tp := range positions {
+		topic := partitionTopic(tp)
+		end, _ := ends.Get(topic, tp.Partition)
+		if int64(tp.Offset) >= end {
+			offsets.Set(topic, tp.Partition, end)
+			continue
+		}
+		left = append(left, tp)
+	}
+	return left, nil
+}
+
+func (s *Replayer) Close() error {
+	return s.consumer.Close()
+}
@@ -0,0 +1,150 @@
+package kafka
+
+import (
+	"testing"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/Kale-Grabovski/impay/domain"
+)
+
+// replayConsumer serves messages of the assigned partitions from memory, empty
+// messages stand for records removed by compaction and are never served.
+type replayConsumer struct {
+	partitions map[int32][]string
+	lows       map[int32]int64
+	assigned   []kafka.TopicPartition
+	queue      []*kafka.Message
+}
+
+func (c *replayConsumer) GetMetadata(*string, bool, int) (*kafka.Metadata, error) {
+	tm := kafka.TopicMetadata{Topic: "state"}
+	for p := range c.partitions {
+		tm.Partitions = append(tm.Partitions, kafka.PartitionMetadata{ID: p})
+	}
+	return &kafka.Metadata{Topics: map[string]kafka.TopicMetadata{"state": tm}}, nil
+}
+
+func (c *replayConsumer) QueryWatermarkOffsets(_ string, partition int32, _ int) (int64, int64, error) {
+	return c.lows[partition], int64(len(c.partitions[partition])), nil
+}
+
+func (c *replayConsumer) Assign(partitions []kafka.TopicPartition) error {
+	c.assigned = partitions
+	for _, tp := range partitions {
+		start := int64(tp.Offset)
+		if tp.Offset == kafka.OffsetBeginning {
+			start = c.lows[tp.Partition]
+		}
+		for offset := start; offset < int64(len(c.partitions[tp.Partition])); offset++ {
+			if c.partitions[tp.Partition][offset] == "" {
+				continue
+			}
+			c.queue = append(c.queue, &kafka.Message{
+				TopicPartition: kafka.TopicPartition{Topic: tp.Topic, Partition: tp.Partition, Offset: kafka.Offset(offset)},
+				Value:          []byte(c.partitions[tp.Partition][offset]),
+			})
+		}
+	}
+	return nil
+}
+
+func (c *replayConsumer) Unassign() error {
+	c.queue = nil
+	return nil
+}
+
+// Position is the offset after the last served message, or the end of the
+// partition once all of its messages were served.
+func (c *replayConsumer) Position(partitions []kafka.TopicPartition) ([]kafka.TopicPartition, error) {
+	positions := make([]kafka.TopicPartition, 0, len(partitions))
+	for _, tp := range partitions {
+		tp.Offset = kafka.Offset(len(c.partitions[tp.Partition]))
+		for _, msg := range c.queue {
+			if msg.TopicPartition.Partition == tp.Partition {
+				tp.Offset = msg.TopicPartition.Offset
+				break
+			}
+		}
+		positions = append(positions, tp)
+	}
+	return positions, nil
+}
+
+func (c *replayConsumer) ReadMessage(time.Duration) (*kafka.Message, error) {
+	if len(c.queue) == 0 {
+		return nil, kafka.NewError(kafka.ErrTimedOut, "timed out", false)
+	}
+	msg := c.queue[0]
+	c.queue = c.queue[1:]
+	return msg, nil
+}
+
+func (c *replayConsumer) Close() error {
+	return nil
+}
+
+func TestReplay(t *testing.T) {
+	consumer := &replayConsumer{partitions: map[int32][]string{
+		0: {"a1", "a2", "a3"},
+		1: {"b1"},
+		2: {},
+	}}
+	replayer := NewReplayer(consumer, []string{"state"})
+
+	// Partition 0 continues after the snapshot, partition 1 is read from the beginning
+	offsets := domain.Offsets{"state": {0: 2}}
+	var replayed []string
+	err := replayer.Replay(offsets, func(topic string, payload []byte) error {
+		assert.Equal(t, "state", topic)
+		replayed = append(replayed, string(payload))
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{"a3", "b1"}, replayed)
+	assert.Equal(t, domain.Offsets{"state": {0: 3, 1: 1, 2: 0}}, offsets)
+
+	ends, err := replayer.Offsets()
+	assert.NoError(t, err)
+	assert.Equal(t, offsets, ends)
+
+	// Nothing is left to replay
+	replayed = nil
+	assert.NoError(t, replayer.Replay(offsets, func(topic string, payload []byte) error {
+		replayed = append(replayed, string(payload))
+		return nil
+	}))
+	assert.Empty(t, replayed)
+}
+
+func TestReplaySkippedOffsets(t *testing.T) {
+	consumer := &replayConsumer{
+		partitions: map[int32][]string{
+			// The last record was compacted away
+			0: {"a1", "a2", ""},
+			// The first record was removed by retention after the snapshot
+			1: {"", "b2", "b3"},
+		},
+		lows: map[int32]int64{1: 1},
+	}
+	replayer := NewReplayer(consumer, []string{"state"})
+
+	offsets := domain.Offsets{"state": {1: 0}}
+	var replayed []string
+	err := replayer.Replay(offsets, func(topic string, payload []byte) error {
+		replayed = append(replayed, string(payload))
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{"a1", "a2", "b2", "b3"}, replayed)
+	assert.Equal(t, domain.Offsets{"state": {0: 3, 1: 3}}, offsets)
+
+	// The snapshot offset is moved up to the first one kept
+	for _, tp := range consumer.assigned {
+		if tp.Partition == 1 {
+			assert.Equal(t, kafka.Offset(1), tp.Offset)
+		}
+	}
+}
@@ -0,0 +1,178 @@
+// Package ledger implements double-entry bookkeeping for wallets.
+// Wallets are liability accounts: a credit increases the balance, a debit decreases it.
+package ledger
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/shopspring/decimal"
+
+	"github.com/Kale-Grabovski/impay/domain"
+)
+
+var ErrBalanceMismatch = errors.New("wallet balance does not match ledger entries")
+
+// Deposit moves money from external cash into the wallet.
+func Deposit(walletID, currency string, amount decimal.Decimal) *domain.Transaction {
+	return newTransaction(domain.TxDeposit, domain.AccountExternalCash, domain.WalletAccount(walletID), currency, amount)
+}
+
+// Withdraw moves money from the wallet to external cash.
+func Withdraw(walletID, currency string, amount decimal.Decimal) *domain.Transaction {
+	return newTransaction(domain.TxWithdrawal, domain.WalletAccount(walletID), domain.AccountExternalCash, currency, amount)
+}
+
+// Capture moves money reserved by a hold from the wallet to external cash.
+func Capture(walletID, currency string, amount decimal.Decimal) *domain.Transaction {
+	return newTransaction(domain.TxCapture, domain.WalletAccount(walletID), domain.AccountExternalCash, currency, amount)
+}
+
+// Transfer moves money between two wallets of the same currency.
+func Transfer(fromID, toID, currency string, amount decimal.Decimal) *domain.Transaction {
+	return newTransaction(domain.TxTransfer, domain.WalletAccount(fromID), domain.WalletAccount(toID), currency, amount)
+}
+
+// Exchange moves money between wallets of different currencies. The converted amount
+// is rounded to the target currency scale, both legs go through the FX accounts
+// so that every currency stays balanced.
+func Exchange(
+	fromID, fromCurrency, toID, toCurrency string,
+	amount, rate decimal.Decimal,
+) (*domain.Transaction, error) {
+	scale, ok := domain.CurrencyScale(toCurrency)
+	if !ok {
+		return nil, domain.ErrUnknownCurrency
+	}
+	converted := amount.Mul(rate).Round(scale)
+	if !converted.IsPositive() {
+		return nil, fmt.Errorf("%s %s converts to zero %s: %w", amount, fromCurrency, toCurrency, domain.ErrInvalidEntry)
+	}
+
+	return &domain.Transaction{
+		Type: domain.TxTransfer,
+		Entries: []domain.Entry{
+			{Account: domain.WalletAccount(fromID), Direction: domain.Debit, Amount: amount, Currency: fromCurrency},
+			{Account: domain.FXAccount(fromCurrency), Direction: domain.Credit, Amount: amount, Currency: fromCurrency},
+			{Account: domain.FXAccount(toCurrency), Direction: domain.Debit, Amount: converted, Currency: toCurrency},
+			{Account: domain.WalletAccount(toID), Direction: domain.Credit, Amount: converted, Currency: toCurrency},
+		},
+		Rate:      &rate,
+		CreatedAt: time.Now().UTC(),
+	}, nil
+}
+
+// Post validates the transaction, applies its entries to the balances
+// of the wallets involved and stores it in the journal. Debits may not
+// spend money reserved by holds.
+func Post(tx domain.WalletTx, t *domain.Transaction) error {
+	if err := t.Validate(); err != nil {
+		return err
+	}
+
+	for i, e := range t.Entries {
+		walletID, ok := domain.WalletFromAccount(e.Account)
+		if !ok {
+			continue
+		}
+		wallet, err := tx.Get(walletID)
+		if err != nil {
+			return err
+		}
+		if e.Currency != wallet.Currency {
+			return fmt.Errorf("wallet %s: %w", walletID, domain.ErrCurrencyMismatch)
+		}
+		wallet.Balance = wallet.Balance.Add(signed(e))
+		if e.Direction == domain.Debit && wallet.Available().IsNegative() {
+			return fmt.Errorf("wallet %s: %w", walletID, domain.ErrInsufficientFunds)
+		}
+		if err = tx.Put(wallet); err != nil {
+			return err
+		}
+		balance := wallet.Balance
+		t.Entries[i].Balance = &balance
+	}
+	return tx.AddTransaction(t)
+}
+
+// Balance sums the journal entries of the wallet.
+func Balance(tx domain.WalletTx, walletID string) (decimal.Decimal, error) {
+	account := domain.WalletAccount(walletID)
+	transactions, err := tx.Transactions(account, domain.TransactionQuery{})
+	if err != nil {
+		return decimal.Zero, err
+	}
+
+	balance := decimal.Zero
+	for _, t := range transactions {
+		for _, e := range t.Entries {
+			if e.Account == account {
+				balance = balance.Add(signed(e))
+			}
+		}
+	}
+	return balance, nil
+}
+
+// Verify checks that the stored wallet balance matches its journal entries.
+func Verify(tx domain.WalletTx, walletID string) error {
+	wallet, err := tx.Get(walletID)
+	if err != nil {
+		return err
+	}
+	balance, err := Balance(tx, walletID)
+	if err != nil {
+		return err
+	}
+	if !balance.Equal(wallet.Balance) {
+		return fmt.Errorf("wallet %s has %s, entries sum to %s: %w",
+			walletID, wallet.Balance, balance, ErrBalanceMismatch)
+	}
+	return nil
+}
+
+// Reconcile journals the difference between the stored wallet balance and its
+// entries as a replay transaction. It is meant for balances restored without
+// the ledger, like the ones rebuilt from events. The wallet is left as is.
+func Reconcile(tx domain.WalletTx, walletID string) error {
+	wallet, err := tx.Get(walletID)
+	if err != nil {
+		return err
+	}
+	balance, err := Balance(tx, walletID)
+	if err != nil {
+		return err
+	}
+	diff := wallet.Balance.Sub(balance)
+	if diff.IsZero() {
+		return nil
+	}
+
+	t := newTransaction(domain.TxReplay, domain.AccountReplay, domain.WalletAccount(walletID), wallet.Currency, diff.Abs())
+	if diff.IsNegative() {
+		t.Entries[0].Direction, t.Entries[1].Direction = domain.Credit, domain.Debit
+	}
+	balance = wallet.Balance
+	t.Entries[1].Balance = &balance
+	return tx.AddTransaction(t)
+}
+
+func newTransaction(txType, debit, credit, currency string, amount decimal.Decimal) *domain.Transaction {
+	return &domain.Transaction{
+		Type: txType,
+		Entries: []domain.Entry{
+			{Account: debit, Direction: domain.Debit, Amount: amount, Currency: currency},
+			{Account: credit, Direction: domain.Credit, Amount: amount, Currency: currency},
+		},
+		CreatedAt: time.Now().UTC(),
+	}
+}
+
+// signed returns the entry amount as a change of a wallet balance.
+func signed(e domain.Entry) decimal.Decimal {
+	if e.Direction == domain.Debit {
+		return e.Amount.Neg()
+	}
+	return e.Amount
+}
@@ -0,0 +1,145 @@
+package ledger
+
+import (
+	"testing"
+
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/Kale-Grabovski/impay/domain"
+	"github.com/Kale-Grabovski/impay/storage"
+)
+
+func TestPost(t *testing.T) {
+	repo := storage.NewMemoryRepository()
+	assert.NoError(t, repo.Update(func(tx domain.WalletTx) error {
+		if err := tx.Put(domain.NewWallet("a", "", "USD")); err != nil {
+			return err
+		}
+		return tx.Put(domain.NewWallet("b", "", "USD"))
+	}))
+
+	testCases := []struct {
+		tx  *domain.Transaction
+		err error
+	}{
+		{tx: Deposit("a", "USD", decimal.NewFromInt(10))},
+		{tx: Withdraw("a", "USD", decimal.NewFromFloat(2.5))},
+		{tx: Transfer("a", "b", "USD", decimal.NewFromInt(3))},
+		{tx: Transfer("b", "a", "USD", decimal.NewFromInt(4)), err: domain.ErrInsufficientFunds},
+		{tx: Deposit("c", "USD", decimal.NewFromInt(1)), err: domain.ErrWalletNotFound},
+		{tx: Deposit("a", "USD", decimal.NewFromInt(-1)), err: domain.ErrInvalidEntry},
+		{
+			tx: &domain.Transaction{
+				Type: domain.TxDeposit,
+				Entries: []domain.Entry{
+					{Account: domain.AccountExternalCash, Direction: domain.Debit, Amount: decimal.NewFromInt(2)},
+					{Account: domain.WalletAccount("a"), Direction: domain.Credit, Amount: decimal.NewFromInt(1)},
+				},
+			},
+			err: domain.ErrUnbalancedTransaction,
+		},
+	}
+
+	for _, tc := range testCases {
+		err := repo.Update(func(tx domain.WalletTx) error {
+			return Post(tx, tc.tx)
+		})
+		if tc.err != nil {
+			assert.ErrorIs(t, err, tc.err)
+		} else {
+			assert.NoError(t, err)
+		}
+	}
+
+	assert.NoError(t, repo.View(func(tx domain.WalletTx) error {
+		expected := map[string]decimal.Decimal{
+			"a": decimal.NewFromFloat(4.5),
+			"b": decimal.NewFromInt(3),
+		}
+		for id, balance := range expected {
+			w, err := tx.Get(id)
+			assert.NoError(t, err)
+			assert.True(t, balance.Equal(w.Balance))
+			assert.NoError(t, Verify(tx, id))
+		}
+
+		transactions, err := tx.Transactions(domain.AccountExternalCash, domain.TransactionQuery{})
+		assert.NoError(t, err)
+		assert.Len(t, transactions, 2)
+		return nil
+	}))
+
+	// Balance changed outside the ledger is detected
+	assert.NoError(t, repo.Update(func(tx domain.WalletTx) error {
+		w, _ := tx.Get("b")
+		w.Balance = decimal.NewFromInt(100)
+		return tx.Put(w)
+	}))
+	assert.NoError(t, repo.View(func(tx domain.WalletTx) error {
+		assert.ErrorIs(t, Verify(tx, "b"), ErrBalanceMismatch)
+		return nil
+	}))
+
+	// Reconciled balances match the journal again, both up and down
+	for _, balance := range []int64{100, 1} {
+		assert.NoError(t, repo.Update(func(tx domain.WalletTx) error {
+			w, _ := tx.Get("b")
+			w.Balance = decimal.NewFromInt(balance)
+			if err := tx.Put(w); err != nil {
+				return err
+			}
+			return Reconcile(tx, "b")
+		}))
+	}
+	assert.NoError(t, repo.View(func(tx domain.WalletTx) error {
+		assert.NoError(t, Verify(tx, "b"))
+		transactions, err := tx.Transactions(domain.AccountReplay, domain.TransactionQuery{})
+		assert.NoError(t, err)
+		if assert.Len(t, transactions, 2) {
+			e, _ := transactions[0].Entry(domain.WalletAccount("b"))
+			assert.Equal(t, domain.Debit, e.Direction)
+			assert.True(t, decimal.NewFromInt(99).Equal(e.Amount))
+		}
+		return nil
+	}))
+}
+
+func TestExchange(t *testing.T) {
+	repo := storage.NewMemoryRepository()
+	assert.NoError(t, repo.Update(func(tx domain.WalletTx) error {
+		w := domain.NewWallet("usd", "", "USD")
+		w.Balance = decimal.NewFromInt(100)
+		if err := tx.Put(w); err != nil {
+			return err
+		}
+		return tx.Put(domain.NewWallet("jpy", "", "JPY"))
+	}))
+
+	tr, err := Exchange("usd", "USD", "jpy", "JPY", decimal.NewFromFloat(10.01), decimal.NewFromFloat(149.5))
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.NoError(t, tr.Validate())
+	assert.NoError(t, repo.Update(func(tx domain.WalletTx) error {
+		return Post(tx, tr)
+	}))
+
+	assert.NoError(t, repo.View(func(tx domain.WalletTx) error {
+		usd, _ := tx.Get("usd")
+		jpy, _ := tx.Get("jpy")
+		assert.True(t, decimal.NewFromFloat(89.99).Equal(usd.Balance))
+		// 10.01 * 149.5 = 1496.495 rounded to JPY scale
+		assert.True(t, decimal.NewFromInt(1496).Equal(jpy.Balance))
+		assert.NoError(t, Verify(tx, "jpy"))
+		return nil
+	}))
+
+	_, err = Exchange("usd", "USD", "jpy", "JPY", decimal.NewFromFloat(0.001), decimal.NewFromFloat(149.5))
+	assert.ErrorIs(t, err, domain.ErrInvalidEntry)
+
+	// Entries in a currency other than the wallet one are rejected
+	assert.ErrorIs(t, repo.Update(func(tx domain.WalletTx) error {
+		return Post(tx, Deposit("jpy", "USD", decimal.NewFromInt(1)))
+	}), domain.ErrCurrencyMismatch)
+}
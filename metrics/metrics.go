@@ -0,0 +1,51 @@
+// Package metrics adapts values kept by the services to Prometheus collectors.
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Sample is a value of a metric with the label values in the order of the metric labels.
+type Sample struct {
+	Labels []string
+	Value  float64
+}
+
+// CounterFunc returns a counter whose samples are taken from fn on every scrape.
+func CounterFunc(name, help string, labels []string, fn func() []Sample) prometheus.Collector {
+	return newSampleCollector(name, help, prometheus.CounterValue, labels, fn)
+}
+
+// GaugeFunc returns a gauge whose samples are taken from fn on every scrape.
+func GaugeFunc(name, help string, labels []string, fn func() []Sample) prometheus.Collector {
+	return newSampleCollector(name, help, prometheus.GaugeValue, labels, fn)
+}
+
+type sampleCollector struct {
+	desc      *prometheus.Desc
+	valueType prometheus.ValueType
+	fn        func() []Sample
+}
+
+func newSampleCollector(
+	name, help string,
+	valueType prometheus.ValueType,
+	labels []string,
+	fn func() []Sample,
+) *sampleCollector {
+	return &sampleCollector{
+		desc:      prometheus.NewDesc(name, help, labels, nil),
+		valueType: valueType,
+		fn:        fn,
+	}
+}
+
+func (c *sampleCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.desc
+}
+
+func (c *sampleCollector) Collect(ch chan<- prometheus.Metric) {
+	for _, s := range c.fn() {
+		ch <- prometheus.MustNewConstMetric(c.desc, c.valueType, s.Value, s.Labels...)
+	}
+}
@@ -0,0 +1,39 @@
+package metrics
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSampleFuncs(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(
+		GaugeFunc("queue_depth", "Messages waiting.", nil, func() []Sample {
+			return []Sample{{Value: 7}}
+		}),
+		CounterFunc("produced_total", "Messages produced.", []string{"topic"}, func() []Sample {
+			return []Sample{
+				{Labels: []string{"Wallet_Created"}, Value: 3},
+				{Labels: []string{`Wallet_"x"`}, Value: 1},
+			}
+		}),
+	)
+
+	assert.NoError(t, testutil.GatherAndCompare(reg, strings.NewReader(`
+# HELP produced_total Messages produced.
+# TYPE produced_total counter
+produced_total{topic="Wallet_Created"} 3
+produced_total{topic="Wallet_\"x\""} 1
+# HELP queue_depth Messages waiting.
+# TYPE queue_depth gauge
+queue_depth 7
+`)))
+
+	assert.Panics(t, func() {
+		reg.MustRegister(GaugeFunc("queue_depth", "Registered twice.", nil, nil))
+	})
+}
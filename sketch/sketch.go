@@ -0,0 +1,124 @@
+// Package sketch estimates quantiles of a stream of amounts in bounded memory.
+package sketch
+
+import (
+	"fmt"
+	"math"
+	"sort"
+)
+
+const (
+	// DefaultAccuracy is the relative error of estimated quantiles
+	DefaultAccuracy = 0.01
+	DefaultMaxBins  = 2048
+)
+
+// Sketch is a DDSketch: values are counted in bins growing logarithmically, so a
+// quantile is estimated within the relative accuracy of its value. Once there are
+// more than MaxBins bins the lowest ones are collapsed, which only makes the lowest
+// quantiles less accurate. Values up to zero are counted separately as zeros.
+type Sketch struct {
+	Accuracy float64       `json:"accuracy"`
+	MaxBins  int           `json:"max_bins"`
+	Bins     map[int]int64 `json:"bins"`
+	Zeros    int64         `json:"zeros"`
+	Count    int64         `json:"count"`
+}
+
+func New(accuracy float64, maxBins int) *Sketch {
+	return &Sketch{
+		Accuracy: accuracy,
+		MaxBins:  maxBins,
+		Bins:     make(map[int]int64),
+	}
+}
+
+func (s *Sketch) Add(v float64) {
+	s.Count++
+	if v <= 0 {
+		s.Zeros++
+		return
+	}
+	if s.Bins == nil {
+		s.Bins = make(map[int]int64)
+	}
+	s.Bins[int(math.Ceil(math.Log(v)/math.Log(s.gamma())))]++
+	if len(s.Bins) > s.MaxBins {
+		s.collapse()
+	}
+}
+
+// Merge adds the values counted by other, both sketches must have the same accuracy.
+func (s *Sketch) Merge(other *Sketch) error {
+	if other.Accuracy != s.Accuracy {
+		return fmt.Errorf("cannot merge sketch with accuracy %v into %v", other.Accuracy, s.Accuracy)
+	}
+	if s.Bins == nil {
+		s.Bins = make(map[int]int64, len(other.Bins))
+	}
+	for i, n := range other.Bins {
+		s.Bins[i] += n
+	}
+	s.Zeros += other.Zeros
+	s.Count += other.Count
+	for len(s.Bins) > s.MaxBins {
+		s.collapse()
+	}
+	return nil
+}
+
+// Quantile returns the estimated value below which q (0 to 1) of the values are.
+func (s *Sketch) Quantile(q float64) float64 {
+	if s.Count == 0 {
+		return 0
+	}
+	rank := int64(q * float64(s.Count-1))
+	if rank < s.Zeros {
+		return 0
+	}
+
+	g := s.gamma()
+	n := s.Zeros
+	indexes := s.indexes()
+	for _, i := range indexes {
+		n += s.Bins[i]
+		if n > rank {
+			return s.value(i, g)
+		}
+	}
+	return s.value(indexes[len(indexes)-1], g)
+}
+
+func (s *Sketch) Copy() *Sketch {
+	c := *s
+	c.Bins = make(map[int]int64, len(s.Bins))
+	for i, n := range s.Bins {
+		c.Bins[i] = n
+	}
+	return &c
+}
+
+func (s *Sketch) gamma() float64 {
+	return (1 + s.Accuracy) / (1 - s.Accuracy)
+}
+
+// value is the middle of bin i, within the accuracy of every value of the bin.
+func (s *Sketch) value(i int, g float64) float64 {
+	return 2 * math.Pow(g, float64(i)) / (g + 1)
+}
+
+// collapse merges the two lowest bins.
+func (s *Sketch) collapse() {
+	indexes := s.indexes()
+	s.Bins[indexes[1]] += s.Bins[indexes[0]]
+	delete(s.Bins, indexes[0])
+}
+
+func (s *Sketch) indexes() []int {
+	indexes := make([]int, 0, len(s.Bins))
+	for i := range s.Bins {
+		indexes = append(indexes, i)
+	}
+	sort.Ints(indexes)
+	return indexes
+}
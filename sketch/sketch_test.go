@@ -0,0 +1,57 @@
+package sketch
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSketchQuantile(t *testing.T) {
+	s := New(DefaultAccuracy, DefaultMaxBins)
+	assert.Equal(t, 0.0, s.Quantile(0.5))
+
+	for v := 1; v <= 10000; v++ {
+		s.Add(float64(v))
+	}
+	for q, want := range map[float64]float64{0.5: 5000, 0.95: 9500, 0.99: 9900, 1: 10000} {
+		assert.InDelta(t, want, s.Quantile(q), want*DefaultAccuracy+1, q)
+	}
+	assert.Equal(t, int64(10000), s.Count)
+}
+
+func TestSketchZeros(t *testing.T) {
+	s := New(DefaultAccuracy, DefaultMaxBins)
+	for i := 0; i < 6; i++ {
+		s.Add(0)
+	}
+	for i := 0; i < 4; i++ {
+		s.Add(100)
+	}
+	assert.Equal(t, 0.0, s.Quantile(0.5))
+	assert.InDelta(t, 100, s.Quantile(0.99), 1)
+}
+
+func TestSketchCollapse(t *testing.T) {
+	s := New(DefaultAccuracy, 10)
+	for e := 0; e < 100; e++ {
+		s.Add(math.Pow(2, float64(e)))
+	}
+	assert.Len(t, s.Bins, 10)
+	assert.Equal(t, int64(100), s.Count)
+	// The highest quantiles stay accurate
+	assert.InEpsilon(t, math.Pow(2, 99), s.Quantile(1), DefaultAccuracy)
+}
+
+func TestSketchMerge(t *testing.T) {
+	a, b := New(DefaultAccuracy, DefaultMaxBins), New(DefaultAccuracy, DefaultMaxBins)
+	for v := 1; v <= 500; v++ {
+		a.Add(float64(v))
+		b.Add(float64(v + 500))
+	}
+	assert.NoError(t, a.Merge(b))
+	assert.Equal(t, int64(1000), a.Count)
+	assert.InDelta(t, 500, a.Quantile(0.5), 500*DefaultAccuracy+1)
+
+	assert.Error(t, a.Merge(New(0.05, DefaultMaxBins)))
+}
@@ -0,0 +1,323 @@
+package storage
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/Kale-Grabovski/impay/domain"
+)
+
+const boltOpenTimeout = time.Second
+
+var (
+	bucketWallets      = []byte("wallets")
+	bucketTransactions = []byte("transactions")
+	bucketAccounts     = []byte("accounts")
+	bucketIdempotency  = []byte("idempotency")
+	bucketHolds        = []byte("holds")
+	bucketActiveHolds  = []byte("active_holds")
+	bucketOutbox       = []byte("outbox")
+)
+
+// BoltRepository keeps wallets in an embedded bbolt database file.
+type BoltRepository struct {
+	db *bolt.DB
+}
+
+func NewBoltRepository(path string) (*BoltRepository, error) {
+	if path == "" {
+		return nil, fmt.Errorf("storage path is not set")
+	}
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: boltOpenTimeout})
+	if err != nil {
+		return nil, fmt.Errorf("cannot open storage %s: %w", path, err)
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, b := range [][]byte{
+			bucketWallets,
+			bucketTransactions,
+			bucketAccounts,
+			bucketIdempotency,
+			bucketHolds,
+			bucketActiveHolds,
+			bucketOutbox,
+		} {
+			if _, err := tx.CreateBucketIfNotExists(b); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		_ = db.Close()
+		return nil, fmt.Errorf("cannot init storage buckets: %w", err)
+	}
+	return &BoltRepository{db: db}, nil
+}
+
+func (s *BoltRepository) View(fn func(tx domain.WalletTx) error) error {
+	return s.db.View(func(tx *bolt.Tx) error {
+		return fn(&boltTx{tx: tx})
+	})
+}
+
+func (s *BoltRepository) Update(fn func(tx domain.WalletTx) error) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return fn(&boltTx{tx: tx})
+	})
+}
+
+// HealthCheck checks that the database file can be read.
+func (s *BoltRepository) HealthCheck(_ context.Context) error {
+	return s.db.View(func(tx *bolt.Tx) error {
+		if tx.Bucket(bucketWallets) == nil {
+			return fmt.Errorf("bucket %s not found", bucketWallets)
+		}
+		return nil
+	})
+}
+
+func (s *BoltRepository) Close() error {
+	return s.db.Close()
+}
+
+type boltTx struct {
+	tx *bolt.Tx
+}
+
+func (s *boltTx) GetAll() ([]*domain.Wallet, error) {
+	b := s.tx.Bucket(bucketWallets)
+	wallets := make([]*domain.Wallet, 0, b.Stats().KeyN)
+	err := b.ForEach(func(k, v []byte) error {
+		var w *domain.Wallet
+		if err := json.Unmarshal(v, &w); err != nil {
+			return fmt.Errorf("cannot unmarshal wallet %s: %w", k, err)
+		}
+		wallets = append(wallets, w)
+		return nil
+	})
+	return wallets, err
+}
+
+func (s *boltTx) Get(id string) (*domain.Wallet, error) {
+	v := s.tx.Bucket(bucketWallets).Get([]byte(id))
+	if v == nil {
+		return nil, domain.ErrWalletNotFound
+	}
+	var w *domain.Wallet
+	if err := json.Unmarshal(v, &w); err != nil {
+		return nil, fmt.Errorf("cannot unmarshal wallet %s: %w", id, err)
+	}
+	return w, nil
+}
+
+func (s *boltTx) Put(wallet *domain.Wallet) error {
+	v, err := json.Marshal(wallet)
+	if err != nil {
+		return fmt.Errorf("cannot marshal wallet: %w", err)
+	}
+	return s.tx.Bucket(bucketWallets).Put([]byte(wallet.ID), v)
+}
+
+func (s *boltTx) AddTransaction(t *domain.Transaction) error {
+	b := s.tx.Bucket(bucketTransactions)
+	id, err := b.NextSequence()
+	if err != nil {
+		return err
+	}
+	t.ID = id
+
+	v, err := json.Marshal(t)
+	if err != nil {
+		return fmt.Errorf("cannot marshal transaction: %w", err)
+	}
+	key := seqKey(id)
+	if err = b.Put(key, v); err != nil {
+		return err
+	}
+
+	for _, account := range entryAccounts(t) {
+		index, err := s.tx.Bucket(bucketAccounts).CreateBucketIfNotExists([]byte(account))
+		if err != nil {
+			return err
+		}
+		if err = index.Put(key, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *boltTx) Transactions(account string, q domain.TransactionQuery) ([]*domain.Transaction, error) {
+	index := s.tx.Bucket(bucketAccounts).Bucket([]byte(account))
+	if index == nil {
+		return nil, nil
+	}
+
+	var transactions []*domain.Transaction
+	c := index.Cursor()
+	k, _ := c.Last()
+	if q.Before > 0 {
+		if k, _ = c.Seek(seqKey(q.Before)); k == nil {
+			k, _ = c.Last()
+		}
+	}
+	for ; k != nil; k, _ = c.Prev() {
+		t, err := s.getTransaction(k)
+		if err != nil {
+			return nil, err
+		}
+		if !q.Match(t) {
+			continue
+		}
+		transactions = append(transactions, t)
+		if q.Limit > 0 && len(transactions) == q.Limit {
+			break
+		}
+	}
+	return transactions, nil
+}
+
+func (s *boltTx) getTransaction(key []byte) (*domain.Transaction, error) {
+	v := s.tx.Bucket(bucketTransactions).Get(key)
+	if v == nil {
+		return nil, fmt.Errorf("transaction %d not found", binary.BigEndian.Uint64(key))
+	}
+	var t *domain.Transaction
+	if err := json.Unmarshal(v, &t); err != nil {
+		return nil, fmt.Errorf("cannot unmarshal transaction: %w", err)
+	}
+	return t, nil
+}
+
+func (s *boltTx) GetIdempotency(key string) (*domain.IdempotencyRecord, error) {
+	v := s.tx.Bucket(bucketIdempotency).Get([]byte(key))
+	if v == nil {
+		return nil, nil
+	}
+	var r *domain.IdempotencyRecord
+	if err := json.Unmarshal(v, &r); err != nil {
+		return nil, fmt.Errorf("cannot unmarshal idempotency record: %w", err)
+	}
+	return r, nil
+}
+
+func (s *boltTx) PutIdempotency(r *domain.IdempotencyRecord) error {
+	v, err := json.Marshal(r)
+	if err != nil {
+		return fmt.Errorf("cannot marshal idempotency record: %w", err)
+	}
+	return s.tx.Bucket(bucketIdempotency).Put([]byte(r.Key), v)
+}
+
+func (s *boltTx) PurgeIdempotency(before time.Time) error {
+	c := s.tx.Bucket(bucketIdempotency).Cursor()
+	for k, v := c.First(); k != nil; {
+		var r domain.IdempotencyRecord
+		if err := json.Unmarshal(v, &r); err != nil {
+			return fmt.Errorf("cannot unmarshal idempotency record: %w", err)
+		}
+		if !r.CreatedAt.Before(before) {
+			k, v = c.Next()
+			continue
+		}
+		key := append([]byte(nil), k...)
+		if err := c.Delete(); err != nil {
+			return err
+		}
+		// Calling Next after Delete skips an item, seek to the following key instead
+		k, v = c.Seek(key)
+	}
+	return nil
+}
+
+func (s *boltTx) GetHold(id string) (*domain.Hold, error) {
+	v := s.tx.Bucket(bucketHolds).Get([]byte(id))
+	if v == nil {
+		return nil, domain.ErrHoldNotFound
+	}
+	var h *domain.Hold
+	if err := json.Unmarshal(v, &h); err != nil {
+		return nil, fmt.Errorf("cannot unmarshal hold %s: %w", id, err)
+	}
+	return h, nil
+}
+
+// PutHold keeps ids of active holds in a separate bucket, so that expiration
+// does not have to scan the whole history of holds.
+func (s *boltTx) PutHold(h *domain.Hold) error {
+	v, err := json.Marshal(h)
+	if err != nil {
+		return fmt.Errorf("cannot marshal hold: %w", err)
+	}
+	if err = s.tx.Bucket(bucketHolds).Put([]byte(h.ID), v); err != nil {
+		return err
+	}
+	if h.Status == domain.HoldActive {
+		return s.tx.Bucket(bucketActiveHolds).Put([]byte(h.ID), nil)
+	}
+	return s.tx.Bucket(bucketActiveHolds).Delete([]byte(h.ID))
+}
+
+func (s *boltTx) ActiveHolds() ([]*domain.Hold, error) {
+	var holds []*domain.Hold
+	err := s.tx.Bucket(bucketActiveHolds).ForEach(func(k, _ []byte) error {
+		h, err := s.GetHold(string(k))
+		if err != nil {
+			return err
+		}
+		holds = append(holds, h)
+		return nil
+	})
+	return holds, err
+}
+
+func (s *boltTx) AddOutbox(e *domain.OutboxEvent) error {
+	id, err := s.tx.Bucket(bucketOutbox).NextSequence()
+	if err != nil {
+		return err
+	}
+	e.ID = id
+	return s.PutOutbox(e)
+}
+
+func (s *boltTx) PendingOutbox(limit int) ([]*domain.OutboxEvent, error) {
+	var events []*domain.OutboxEvent
+	c := s.tx.Bucket(bucketOutbox).Cursor()
+	for k, v := c.First(); k != nil; k, v = c.Next() {
+		var e *domain.OutboxEvent
+		if err := json.Unmarshal(v, &e); err != nil {
+			return nil, fmt.Errorf("cannot unmarshal outbox event: %w", err)
+		}
+		events = append(events, e)
+		if limit > 0 && len(events) == limit {
+			break
+		}
+	}
+	return events, nil
+}
+
+func (s *boltTx) PutOutbox(e *domain.OutboxEvent) error {
+	v, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("cannot marshal outbox event: %w", err)
+	}
+	return s.tx.Bucket(bucketOutbox).Put(seqKey(e.ID), v)
+}
+
+func (s *boltTx) DeleteOutbox(id uint64) error {
+	return s.tx.Bucket(bucketOutbox).Delete(seqKey(id))
+}
+
+// seqKey encodes sequence numbers big-endian so bolt keeps them sorted.
+func seqKey(seq uint64) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, seq)
+	return key
+}
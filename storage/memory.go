@@ -0,0 +1,301 @@
+package storage
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Kale-Grabovski/impay/domain"
+)
+
+var errReadOnlyTx = errors.New("cannot write in a read-only transaction")
+
+type MemoryRepository struct {
+	sync.RWMutex
+	wallets      map[string]*domain.Wallet
+	transactions []*domain.Transaction
+	accounts     map[string][]int
+	idempotency  map[string]*domain.IdempotencyRecord
+	holds        map[string]*domain.Hold
+	outbox       map[uint64]*domain.OutboxEvent
+	outboxSeq    uint64
+}
+
+func NewMemoryRepository() *MemoryRepository {
+	return &MemoryRepository{
+		wallets:     make(map[string]*domain.Wallet),
+		accounts:    make(map[string][]int),
+		idempotency: make(map[string]*domain.IdempotencyRecord),
+		holds:       make(map[string]*domain.Hold),
+		outbox:      make(map[uint64]*domain.OutboxEvent),
+	}
+}
+
+func (s *MemoryRepository) View(fn func(tx domain.WalletTx) error) error {
+	s.RLock()
+	defer s.RUnlock()
+	return fn(&memoryTx{repo: s})
+}
+
+func (s *MemoryRepository) Update(fn func(tx domain.WalletTx) error) error {
+	s.Lock()
+	defer s.Unlock()
+
+	tx := &memoryTx{
+		repo:        s,
+		writable:    true,
+		wallets:     make(map[string]*domain.Wallet),
+		idempotency: make(map[string]*domain.IdempotencyRecord),
+		holds:       make(map[string]*domain.Hold),
+		outbox:      make(map[uint64]*domain.OutboxEvent),
+		outboxSeq:   s.outboxSeq,
+	}
+	if err := fn(tx); err != nil {
+		return err
+	}
+	for id, w := range tx.wallets {
+		s.wallets[id] = w
+	}
+	for _, t := range tx.transactions {
+		s.transactions = append(s.transactions, t)
+		for _, account := range entryAccounts(t) {
+			s.accounts[account] = append(s.accounts[account], len(s.transactions)-1)
+		}
+	}
+	for key, r := range tx.idempotency {
+		if r == nil {
+			delete(s.idempotency, key)
+		} else {
+			s.idempotency[key] = r
+		}
+	}
+	for id, h := range tx.holds {
+		s.holds[id] = h
+	}
+	for id, e := range tx.outbox {
+		if e == nil {
+			delete(s.outbox, id)
+		} else {
+			s.outbox[id] = e
+		}
+	}
+	s.outboxSeq = tx.outboxSeq
+	return nil
+}
+
+func (s *MemoryRepository) Close() error {
+	return nil
+}
+
+// memoryTx buffers writes until the Update callback succeeds,
+// so a failed callback leaves the repository untouched.
+type memoryTx struct {
+	repo         *MemoryRepository
+	writable     bool
+	wallets      map[string]*domain.Wallet
+	transactions []*domain.Transaction
+	// idempotency holds nil values for purged records
+	idempotency map[string]*domain.IdempotencyRecord
+	holds       map[string]*domain.Hold
+	// outbox holds nil values for delivered events
+	outbox    map[uint64]*domain.OutboxEvent
+	outboxSeq uint64
+}
+
+func (s *memoryTx) GetAll() ([]*domain.Wallet, error) {
+	wallets := make([]*domain.Wallet, 0, len(s.repo.wallets))
+	for id, w := range s.repo.wallets {
+		if pending, ok := s.wallets[id]; ok {
+			w = pending
+		}
+		wallets = append(wallets, copyWallet(w))
+	}
+	for id, w := range s.wallets {
+		if _, ok := s.repo.wallets[id]; !ok {
+			wallets = append(wallets, copyWallet(w))
+		}
+	}
+	return wallets, nil
+}
+
+func (s *memoryTx) Get(id string) (*domain.Wallet, error) {
+	if w, ok := s.wallets[id]; ok {
+		return copyWallet(w), nil
+	}
+	if w, ok := s.repo.wallets[id]; ok {
+		return copyWallet(w), nil
+	}
+	return nil, domain.ErrWalletNotFound
+}
+
+func (s *memoryTx) Put(wallet *domain.Wallet) error {
+	if !s.writable {
+		return errReadOnlyTx
+	}
+	s.wallets[wallet.ID] = copyWallet(wallet)
+	return nil
+}
+
+func (s *memoryTx) AddTransaction(t *domain.Transaction) error {
+	if !s.writable {
+		return errReadOnlyTx
+	}
+	t.ID = uint64(len(s.repo.transactions) + len(s.transactions) + 1)
+	s.transactions = append(s.transactions, copyTransaction(t))
+	return nil
+}
+
+func (s *memoryTx) Transactions(account string, q domain.TransactionQuery) ([]*domain.Transaction, error) {
+	var transactions []*domain.Transaction
+	add := func(t *domain.Transaction) bool {
+		if q.Match(t) {
+			transactions = append(transactions, copyTransaction(t))
+		}
+		return q.Limit == 0 || len(transactions) < q.Limit
+	}
+
+	for i := len(s.transactions) - 1; i >= 0; i-- {
+		t := s.transactions[i]
+		if _, ok := t.Entry(account); ok && !add(t) {
+			return transactions, nil
+		}
+	}
+	index := s.repo.accounts[account]
+	for i := len(index) - 1; i >= 0; i-- {
+		if !add(s.repo.transactions[index[i]]) {
+			break
+		}
+	}
+	return transactions, nil
+}
+
+func (s *memoryTx) GetIdempotency(key string) (*domain.IdempotencyRecord, error) {
+	r, ok := s.idempotency[key]
+	if !ok {
+		r = s.repo.idempotency[key]
+	}
+	if r == nil {
+		return nil, nil
+	}
+	c := *r
+	return &c, nil
+}
+
+func (s *memoryTx) PutIdempotency(r *domain.IdempotencyRecord) error {
+	if !s.writable {
+		return errReadOnlyTx
+	}
+	c := *r
+	s.idempotency[r.Key] = &c
+	return nil
+}
+
+func (s *memoryTx) PurgeIdempotency(before time.Time) error {
+	if !s.writable {
+		return errReadOnlyTx
+	}
+	for key, r := range s.repo.idempotency {
+		if r.CreatedAt.Before(before) {
+			s.idempotency[key] = nil
+		}
+	}
+	for key, r := range s.idempotency {
+		if r != nil && r.CreatedAt.Before(before) {
+			s.idempotency[key] = nil
+		}
+	}
+	return nil
+}
+
+func (s *memoryTx) GetHold(id string) (*domain.Hold, error) {
+	h, ok := s.holds[id]
+	if !ok {
+		h, ok = s.repo.holds[id]
+	}
+	if !ok {
+		return nil, domain.ErrHoldNotFound
+	}
+	c := *h
+	return &c, nil
+}
+
+func (s *memoryTx) PutHold(h *domain.Hold) error {
+	if !s.writable {
+		return errReadOnlyTx
+	}
+	c := *h
+	s.holds[h.ID] = &c
+	return nil
+}
+
+func (s *memoryTx) ActiveHolds() ([]*domain.Hold, error) {
+	var holds []*domain.Hold
+	for id, h := range s.repo.holds {
+		if pending, ok := s.holds[id]; ok {
+			h = pending
+		}
+		if h.Status == domain.HoldActive {
+			c := *h
+			holds = append(holds, &c)
+		}
+	}
+	for id, h := range s.holds {
+		if _, ok := s.repo.holds[id]; !ok && h.Status == domain.HoldActive {
+			c := *h
+			holds = append(holds, &c)
+		}
+	}
+	return holds, nil
+}
+
+func (s *memoryTx) AddOutbox(e *domain.OutboxEvent) error {
+	if !s.writable {
+		return errReadOnlyTx
+	}
+	s.outboxSeq++
+	e.ID = s.outboxSeq
+	c := *e
+	s.outbox[e.ID] = &c
+	return nil
+}
+
+func (s *memoryTx) PendingOutbox(limit int) ([]*domain.OutboxEvent, error) {
+	var events []*domain.OutboxEvent
+	for id, e := range s.repo.outbox {
+		if _, ok := s.outbox[id]; !ok {
+			c := *e
+			events = append(events, &c)
+		}
+	}
+	for _, e := range s.outbox {
+		if e != nil {
+			c := *e
+			events = append(events, &c)
+		}
+	}
+	sort.Slice(events, func(i, j int) bool {
+		return events[i].ID < events[j].ID
+	})
+	if limit > 0 && len(events) > limit {
+		events = events[:limit]
+	}
+	return events, nil
+}
+
+func (s *memoryTx) PutOutbox(e *domain.OutboxEvent) error {
+	if !s.writable {
+		return errReadOnlyTx
+	}
+	c := *e
+	s.outbox[e.ID] = &c
+	return nil
+}
+
+func (s *memoryTx) DeleteOutbox(id uint64) error {
+	if !s.writable {
+		return errReadOnlyTx
+	}
+	s.outbox[id] = nil
+	return nil
+}
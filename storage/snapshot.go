@@ -0,0 +1,55 @@
+package storage
+
+import (
+	"fmt"
+
+	"github.com/Kale-Grabovski/impay/domain"
+)
+
+// FileSnapshotStore keeps the wallet snapshot in a JSON file.
+type FileSnapshotStore struct {
+	path string
+}
+
+func NewFileSnapshotStore(path string) (*FileSnapshotStore, error) {
+	if path == "" {
+		return nil, fmt.Errorf("snapshot file is not set")
+	}
+	return &FileSnapshotStore{path: path}, nil
+}
+
+func (s *FileSnapshotStore) Load() (*domain.WalletSnapshot, error) {
+	var snapshot *domain.WalletSnapshot
+	if ok, err := loadFile(s.path, &snapshot); !ok {
+		return nil, err
+	}
+	return snapshot, nil
+}
+
+func (s *FileSnapshotStore) Save(snapshot *domain.WalletSnapshot) error {
+	return saveFile(s.path, snapshot)
+}
+
+// FileCheckpointStore keeps the stats checkpoint in a JSON file.
+type FileCheckpointStore struct {
+	path string
+}
+
+func NewFileCheckpointStore(path string) (*FileCheckpointStore, error) {
+	if path == "" {
+		return nil, fmt.Errorf("checkpoint file is not set")
+	}
+	return &FileCheckpointStore{path: path}, nil
+}
+
+func (s *FileCheckpointStore) Load() (*domain.StatsCheckpoint, error) {
+	var checkpoint *domain.StatsCheckpoint
+	if ok, err := loadFile(s.path, &checkpoint); !ok {
+		return nil, err
+	}
+	return checkpoint, nil
+}
+
+func (s *FileCheckpointStore) Save(checkpoint *domain.StatsCheckpoint) error {
+	return saveFile(s.path, checkpoint)
+}
@@ -0,0 +1,42 @@
+package storage
+
+import (
+	"fmt"
+
+	"github.com/Kale-Grabovski/impay/domain"
+)
+
+// NewWalletRepository returns the wallet repository selected by the storage driver in config.
+func NewWalletRepository(cfg *domain.Config) (domain.WalletRepository, error) {
+	switch cfg.Storage.Driver {
+	case "", domain.StorageMemory:
+		return NewMemoryRepository(), nil
+	case domain.StorageBolt:
+		return NewBoltRepository(cfg.Storage.Path)
+	}
+	return nil, fmt.Errorf("unknown storage driver: %s", cfg.Storage.Driver)
+}
+
+func copyWallet(wallet *domain.Wallet) *domain.Wallet {
+	w := *wallet
+	return &w
+}
+
+func copyTransaction(t *domain.Transaction) *domain.Transaction {
+	c := *t
+	c.Entries = append([]domain.Entry(nil), t.Entries...)
+	return &c
+}
+
+// entryAccounts returns the distinct accounts touched by the transaction.
+func entryAccounts(t *domain.Transaction) []string {
+	accounts := make([]string, 0, len(t.Entries))
+	seen := make(map[string]bool, len(t.Entries))
+	for _, e := range t.Entries {
+		if !seen[e.Account] {
+			seen[e.Account] = true
+			accounts = append(accounts, e.Account)
+		}
+	}
+	return accounts
+}
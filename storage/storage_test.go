@@ -0,0 +1,256 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/Kale-Grabovski/impay/domain"
+)
+
+func TestWalletRepository(t *testing.T) {
+	bolt, err := NewBoltRepository(filepath.Join(t.TempDir(), "impay.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bolt.Close()
+
+	repos := map[string]domain.WalletRepository{
+		domain.StorageMemory: NewMemoryRepository(),
+		domain.StorageBolt:   bolt,
+	}
+
+	for name, repo := range repos {
+		t.Run(name, func(t *testing.T) {
+			err := repo.Update(func(tx domain.WalletTx) error {
+				w := domain.NewWallet("w1", "first", "USD")
+				w.Balance = decimal.NewFromInt(10)
+				if err := tx.Put(w); err != nil {
+					return err
+				}
+				return tx.Put(domain.NewWallet("w2", "second", "USD"))
+			})
+			assert.NoError(t, err)
+
+			// Failed transaction must not leave partial writes
+			errRollback := errors.New("rollback")
+			err = repo.Update(func(tx domain.WalletTx) error {
+				w, err := tx.Get("w1")
+				if err != nil {
+					return err
+				}
+				w.Balance = decimal.Zero
+				if err = tx.Put(w); err != nil {
+					return err
+				}
+				return errRollback
+			})
+			assert.ErrorIs(t, err, errRollback)
+
+			err = repo.View(func(tx domain.WalletTx) error {
+				w, err := tx.Get("w1")
+				if assert.NoError(t, err) {
+					assert.True(t, w.Balance.Equal(decimal.NewFromInt(10)))
+					assert.Equal(t, "first", w.Name)
+					assert.Equal(t, domain.StatusActive, w.Status)
+				}
+
+				_, err = tx.Get("w3")
+				assert.ErrorIs(t, err, domain.ErrWalletNotFound)
+
+				wallets, err := tx.GetAll()
+				assert.NoError(t, err)
+				assert.Len(t, wallets, 2)
+
+				assert.Error(t, tx.Put(domain.NewWallet("w3", "", "USD")))
+				return nil
+			})
+			assert.NoError(t, err)
+
+			err = repo.Update(func(tx domain.WalletTx) error {
+				for i := 1; i <= 5; i++ {
+					err := tx.AddTransaction(&domain.Transaction{
+						Type: domain.TxTransfer,
+						Entries: []domain.Entry{
+							{Account: domain.WalletAccount("w1"), Direction: domain.Debit, Amount: decimal.NewFromInt(int64(i))},
+							{Account: domain.WalletAccount("w2"), Direction: domain.Credit, Amount: decimal.NewFromInt(int64(i))},
+						},
+					})
+					if err != nil {
+						return err
+					}
+				}
+				return nil
+			})
+			assert.NoError(t, err)
+
+			err = repo.View(func(tx domain.WalletTx) error {
+				all, err := tx.Transactions(domain.WalletAccount("w2"), domain.TransactionQuery{})
+				assert.NoError(t, err)
+				assert.Len(t, all, 5)
+				assert.Equal(t, uint64(5), all[0].ID)
+
+				page, err := tx.Transactions(domain.WalletAccount("w1"), domain.TransactionQuery{Before: 4, Limit: 2})
+				assert.NoError(t, err)
+				if assert.Len(t, page, 2) {
+					assert.Equal(t, uint64(3), page[0].ID)
+					assert.Equal(t, uint64(2), page[1].ID)
+				}
+
+				none, err := tx.Transactions(domain.WalletAccount("w3"), domain.TransactionQuery{})
+				assert.NoError(t, err)
+				assert.Empty(t, none)
+				return nil
+			})
+			assert.NoError(t, err)
+
+			now := time.Now()
+			err = repo.Update(func(tx domain.WalletTx) error {
+				for i, key := range []string{"k1", "k2", "k3"} {
+					err := tx.PutIdempotency(&domain.IdempotencyRecord{
+						Key:       key,
+						Body:      []byte(`{}`),
+						CreatedAt: now.Add(time.Duration(i-2) * time.Hour),
+					})
+					if err != nil {
+						return err
+					}
+				}
+				return nil
+			})
+			assert.NoError(t, err)
+			assert.NoError(t, repo.Update(func(tx domain.WalletTx) error {
+				return tx.PurgeIdempotency(now.Add(-time.Minute))
+			}))
+			err = repo.View(func(tx domain.WalletTx) error {
+				for key, exists := range map[string]bool{"k1": false, "k2": false, "k3": true} {
+					r, err := tx.GetIdempotency(key)
+					assert.NoError(t, err)
+					assert.Equal(t, exists, r != nil, key)
+				}
+				return nil
+			})
+			assert.NoError(t, err)
+
+			err = repo.Update(func(tx domain.WalletTx) error {
+				for _, h := range []*domain.Hold{
+					{ID: "h1", WalletID: "w1", Status: domain.HoldActive},
+					{ID: "h2", WalletID: "w1", Status: domain.HoldActive},
+				} {
+					if err := tx.PutHold(h); err != nil {
+						return err
+					}
+				}
+				return tx.PutHold(&domain.Hold{ID: "h1", WalletID: "w1", Status: domain.HoldVoided})
+			})
+			assert.NoError(t, err)
+			err = repo.View(func(tx domain.WalletTx) error {
+				h, err := tx.GetHold("h1")
+				assert.NoError(t, err)
+				assert.Equal(t, domain.HoldVoided, h.Status)
+				_, err = tx.GetHold("h3")
+				assert.ErrorIs(t, err, domain.ErrHoldNotFound)
+
+				active, err := tx.ActiveHolds()
+				assert.NoError(t, err)
+				if assert.Len(t, active, 1) {
+					assert.Equal(t, "h2", active[0].ID)
+				}
+				return nil
+			})
+			assert.NoError(t, err)
+
+			err = repo.Update(func(tx domain.WalletTx) error {
+				for _, topic := range []string{"t1", "t2", "t3"} {
+					if err := tx.AddOutbox(&domain.OutboxEvent{Topic: topic, Payload: []byte(`{}`)}); err != nil {
+						return err
+					}
+				}
+				if err := tx.DeleteOutbox(1); err != nil {
+					return err
+				}
+				return tx.PutOutbox(&domain.OutboxEvent{ID: 2, Topic: "t2", Payload: []byte(`{}`), Attempts: 1})
+			})
+			assert.NoError(t, err)
+			err = repo.View(func(tx domain.WalletTx) error {
+				events, err := tx.PendingOutbox(1)
+				assert.NoError(t, err)
+				if assert.Len(t, events, 1) {
+					assert.Equal(t, uint64(2), events[0].ID)
+					assert.Equal(t, 1, events[0].Attempts)
+				}
+				events, err = tx.PendingOutbox(0)
+				assert.NoError(t, err)
+				assert.Len(t, events, 2)
+				return nil
+			})
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestBoltRepositoryReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "impay.db")
+	repo, err := NewBoltRepository(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.NoError(t, repo.Update(func(tx domain.WalletTx) error {
+		return tx.Put(domain.NewWallet("w1", "first", "USD"))
+	}))
+	assert.NoError(t, repo.Close())
+
+	repo, err = NewBoltRepository(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer repo.Close()
+	assert.NoError(t, repo.View(func(tx domain.WalletTx) error {
+		w, err := tx.Get("w1")
+		if assert.NoError(t, err) {
+			assert.Equal(t, "first", w.Name)
+		}
+		return nil
+	}))
+}
+
+func TestFileSnapshotStore(t *testing.T) {
+	store, err := NewFileSnapshotStore(filepath.Join(t.TempDir(), "snapshot.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	snapshot, err := store.Load()
+	assert.NoError(t, err)
+	assert.Nil(t, snapshot)
+
+	w := domain.NewWallet("w1", "first", "USD")
+	w.Balance = decimal.NewFromInt(10)
+	assert.NoError(t, store.Save(&domain.WalletSnapshot{
+		Wallets: []*domain.Wallet{w},
+		Offsets: domain.Offsets{domain.TopicWalletCreated: {0: 3, 2: 1}},
+	}))
+
+	snapshot, err = store.Load()
+	if assert.NoError(t, err) && assert.NotNil(t, snapshot) {
+		assert.Equal(t, domain.Offsets{domain.TopicWalletCreated: {0: 3, 2: 1}}, snapshot.Offsets)
+		if assert.Len(t, snapshot.Wallets, 1) {
+			assert.Equal(t, "first", snapshot.Wallets[0].Name)
+			assert.True(t, decimal.NewFromInt(10).Equal(snapshot.Wallets[0].Balance))
+		}
+	}
+}
+
+func TestBoltHealthCheck(t *testing.T) {
+	repo, err := NewBoltRepository(filepath.Join(t.TempDir(), "impay.db"))
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.NoError(t, repo.HealthCheck(context.Background()))
+	assert.NoError(t, repo.Close())
+	assert.Error(t, repo.HealthCheck(context.Background()))
+}
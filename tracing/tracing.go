@@ -0,0 +1,83 @@
+// Package tracing sets up OpenTelemetry tracing of the services.
+package tracing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+
+	"github.com/Kale-Grabovski/impay/domain"
+)
+
+// Setup installs the global tracer provider of the service with the configured
+// exporter and the W3C trace context propagator. Without an exporter spans are
+// not recorded, but the trace context of requests is still passed along.
+// The returned function flushes the pending spans and closes the exporter.
+func Setup(cfg *domain.Config, service string) (func(ctx context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{},
+		propagation.Baggage{},
+	))
+
+	var w io.Writer
+	var file *os.File
+	switch cfg.Tracing.Exporter {
+	case "", domain.TracingNone:
+		return func(context.Context) error { return nil }, nil
+	case domain.TracingStdout:
+		w = os.Stdout
+	case domain.TracingFile:
+		if cfg.Tracing.File == "" {
+			return nil, errors.New("tracing file is not set")
+		}
+		var err error
+		file, err = os.OpenFile(cfg.Tracing.File, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			return nil, fmt.Errorf("cannot open tracing file: %w", err)
+		}
+		w = file
+	default:
+		return nil, fmt.Errorf("unknown tracing exporter %q", cfg.Tracing.Exporter)
+	}
+
+	exporter, err := stdouttrace.New(stdouttrace.WithWriter(w))
+	if err != nil {
+		return nil, fmt.Errorf("cannot create tracing exporter: %w", err)
+	}
+	provider := NewProvider(service, sampleRatio(cfg), sdktrace.WithBatcher(exporter))
+	otel.SetTracerProvider(provider)
+
+	return func(ctx context.Context) error {
+		err := provider.Shutdown(ctx)
+		if file != nil {
+			err = errors.Join(err, file.Close())
+		}
+		return err
+	}, nil
+}
+
+// NewProvider returns a tracer provider of the service sampling the ratio of
+// new traces, the traces of callers are sampled as they decided.
+func NewProvider(service string, ratio float64, opts ...sdktrace.TracerProviderOption) *sdktrace.TracerProvider {
+	opts = append([]sdktrace.TracerProviderOption{
+		sdktrace.WithResource(resource.NewSchemaless(semconv.ServiceName(service))),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(ratio))),
+	}, opts...)
+	return sdktrace.NewTracerProvider(opts...)
+}
+
+func sampleRatio(cfg *domain.Config) float64 {
+	if cfg.Tracing.SampleRatio <= 0 {
+		return 1
+	}
+	return min(cfg.Tracing.SampleRatio, 1)
+}
@@ -0,0 +1,44 @@
+package tracing
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace/noop"
+
+	"github.com/Kale-Grabovski/impay/domain"
+)
+
+func TestSetup(t *testing.T) {
+	defer func() {
+		otel.SetTracerProvider(noop.NewTracerProvider())
+		otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator())
+	}()
+
+	cfg := &domain.Config{}
+	cfg.Tracing.Exporter = "jaeger"
+	_, err := Setup(cfg, "test")
+	assert.EqualError(t, err, `unknown tracing exporter "jaeger"`)
+
+	// Spans are written to the file once flushed on shutdown
+	cfg.Tracing.Exporter = domain.TracingFile
+	cfg.Tracing.File = filepath.Join(t.TempDir(), "spans.json")
+	shutdown, err := Setup(cfg, "test")
+	if !assert.NoError(t, err) {
+		return
+	}
+	_, span := otel.Tracer("test").Start(context.Background(), "operation")
+	span.End()
+	assert.NoError(t, shutdown(context.Background()))
+
+	spans, err := os.ReadFile(cfg.Tracing.File)
+	assert.NoError(t, err)
+	assert.Contains(t, string(spans), `"Name":"operation"`)
+	assert.Contains(t, string(spans), `"Value":"test"`)
+	assert.Contains(t, otel.GetTextMapPropagator().Fields(), "traceparent")
+}